@@ -0,0 +1,233 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/naming"
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/drone/signal"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// provisioning phases measured by the bench command.
+var benchPhases = []string{"create", "deploy", "ssh", "purge"}
+
+type benchCommand struct {
+	Endpoint    string
+	Token       string
+	Proxy       string
+	Image       string
+	Compute     int
+	Username    string
+	Password    string
+	Count       int
+	DialTimeout time.Duration
+}
+
+func (c *benchCommand) run(*kingpin.ParseContext) error {
+	ctx, cancel := context.WithCancel(nocontext)
+	defer cancel()
+
+	// listen for operating system signals and cancel execution
+	// when received.
+	ctx = signal.WithContextFunc(ctx, func() {
+		println("received signal, terminating process")
+		cancel()
+	})
+
+	httpClient, err := orka.NewHTTPClient(c.Proxy, false)
+	if err != nil {
+		return err
+	}
+	client := &orka.Client{
+		Client:   httpClient,
+		Endpoint: c.Endpoint,
+		Token:    c.Token,
+	}
+
+	samples := map[string][]time.Duration{}
+	var failed int
+	for i := 1; i <= c.Count; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		timings, err := c.iteration(ctx, client)
+		for _, phase := range benchPhases {
+			if d, ok := timings[phase]; ok {
+				samples[phase] = append(samples[phase], d)
+			}
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stdout, "run %d/%d: error: %s\n", i, c.Count, err)
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "run %d/%d: create %s, deploy %s, ssh %s, purge %s\n", i, c.Count,
+			timings["create"],
+			timings["deploy"],
+			timings["ssh"],
+			timings["purge"],
+		)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nPHASE\tSAMPLES\tMIN\tP50\tP95\tMAX")
+	for _, phase := range benchPhases {
+		s := samples[phase]
+		if len(s) == 0 {
+			fmt.Fprintf(w, "%s\t0\t-\t-\t-\t-\n", phase)
+			continue
+		}
+		sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", phase, len(s),
+			s[0],
+			percentile(s, 50),
+			percentile(s, 95),
+			s[len(s)-1],
+		)
+	}
+	w.Flush()
+
+	if failed != 0 {
+		return fmt.Errorf("%d of %d run(s) failed", failed, c.Count)
+	}
+	return nil
+}
+
+// iteration provisions, dials and purges a single virtual
+// machine, and returns the duration of each completed phase.
+func (c *benchCommand) iteration(ctx context.Context, client *orka.Client) (map[string]time.Duration, error) {
+	timings := map[string]time.Duration{}
+	name := naming.New(naming.DefaultPrefix)
+
+	start := time.Now()
+	_, err := client.Create(ctx, &orka.Config{
+		Name:  name,
+		Image: c.Image,
+		CPU:   c.Compute,
+		VCPU:  c.Compute,
+	})
+	if err != nil {
+		return timings, err
+	}
+	timings["create"] = time.Since(start)
+
+	// the virtual machine is always purged, even if it fails
+	// to deploy, to avoid leaking cluster resources.
+	defer func() {
+		start := time.Now()
+		if _, err := client.Delete(nocontext, name); err == nil {
+			timings["purge"] = time.Since(start)
+		}
+	}()
+
+	start = time.Now()
+	deploy, err := client.Deploy(ctx, name)
+	if err != nil {
+		return timings, err
+	}
+	timings["deploy"] = time.Since(start)
+
+	start = time.Now()
+	err = c.dial(ctx, net.JoinHostPort(deploy.IP, deploy.SSHPort))
+	if err != nil {
+		return timings, err
+	}
+	timings["ssh"] = time.Since(start)
+	return timings, nil
+}
+
+// dial dials the virtual machine until the first successful
+// ssh connection, or until the dial timeout is reached.
+func (c *benchCommand) dial(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.DialTimeout)
+	defer cancel()
+	config := &ssh.ClientConfig{
+		User:            c.Username,
+		Auth:            []ssh.AuthMethod{ssh.Password(c.Password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second * 10,
+	}
+	for {
+		client, err := ssh.Dial("tcp", addr, config)
+		if err == nil {
+			client.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cannot dial %s: %s", addr, err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// helper function returns the nearest-rank percentile of
+// the sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(time.Millisecond)
+}
+
+func registerBench(app *kingpin.Application) {
+	c := new(benchCommand)
+
+	cmd := app.Command("bench", "measures virtual machine provisioning latency").
+		Action(c.run)
+
+	cmd.Flag("count", "number of virtual machines to provision").
+		Default("10").
+		IntVar(&c.Count)
+
+	cmd.Flag("dial-timeout", "maximum duration to wait for ssh").
+		Default("10m").
+		DurationVar(&c.DialTimeout)
+
+	cmd.Flag("cpu", "orka cpu count").
+		Default("12").
+		Envar("DRONE_VM_CPU").
+		IntVar(&c.Compute)
+
+	cmd.Flag("endpoint", "orka endpoint").
+		Default("http://10.221.188.100").
+		Envar("DRONE_ORKA_ENDPOINT").
+		StringVar(&c.Endpoint)
+
+	cmd.Flag("token", "orka token").
+		Envar("DRONE_ORKA_TOKEN").
+		StringVar(&c.Token)
+
+	cmd.Flag("proxy", "orka api proxy url").
+		Envar("DRONE_ORKA_PROXY").
+		StringVar(&c.Proxy)
+
+	cmd.Flag("image", "orka base image").
+		Envar("DRONE_VM_IMAGE").
+		StringVar(&c.Image)
+
+	cmd.Flag("username", "image ssh username").
+		Default("admin").
+		Envar("DRONE_VM_USERNAME").
+		StringVar(&c.Username)
+
+	cmd.Flag("password", "image ssh password").
+		Default("admin").
+		Envar("DRONE_VM_PASSWORD").
+		StringVar(&c.Password)
+}
@@ -25,6 +25,12 @@ func Command() {
 	app := kingpin.New("drone", "drone macstadium runner")
 	registerCompile(app)
 	registerExec(app)
+	registerReplay(app)
+	registerDoctor(app)
+	registerValidate(app)
+	registerGC(app)
+	registerVM(app)
+	registerBench(app)
 	daemon.Register(app)
 
 	kingpin.Version(version)
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/drone-runners/drone-runner-macstadium/command/internal"
+	"github.com/drone-runners/drone-runner-macstadium/engine"
 	"github.com/drone-runners/drone-runner-macstadium/engine/compiler"
 	"github.com/drone-runners/drone-runner-macstadium/engine/linter"
 	"github.com/drone-runners/drone-runner-macstadium/engine/resource"
@@ -32,6 +33,7 @@ type compileCommand struct {
 	Environ  map[string]string
 	Secrets  map[string]string
 	Settings compiler.Settings
+	Redact   bool
 }
 
 func (c *compileCommand) run(*kingpin.ParseContext) error {
@@ -105,7 +107,13 @@ func (c *compileCommand) run(*kingpin.ParseContext) error {
 		Stage:    c.Stage,
 		System:   c.System,
 	}
-	spec := comp.Compile(nocontext, args)
+	spec := comp.Compile(nocontext, args).(*engine.Spec)
+
+	// the secrets are optionally redacted, so that the
+	// compiled pipeline can be shared and replayed.
+	if c.Redact {
+		spec = engine.Redact(spec)
+	}
 
 	// encode the pipeline in json format and print to the
 	// console for inspection.
@@ -133,6 +141,9 @@ func registerCompile(app *kingpin.Application) {
 	cmd.Flag("environ", "environment variables").
 		StringMapVar(&c.Environ)
 
+	cmd.Flag("redact", "redact secrets and credentials").
+		BoolVar(&c.Redact)
+
 	// shared pipeline flags
 	c.Flags = internal.ParseFlags(cmd)
 }
@@ -0,0 +1,234 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/engine"
+	"github.com/drone-runners/drone-runner-macstadium/engine/linter"
+	"github.com/drone-runners/drone-runner-macstadium/engine/resource"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/drone/runner-go/pipeline/runtime"
+	"github.com/drone/runner-go/secret"
+)
+
+// maximum size of the pipeline configuration accepted by
+// the pipeline api.
+const maxConfigSize = 1 << 20
+
+// apiHandler executes ad-hoc pipelines outside of the server
+// queue, for example, to validate images or smoke test the
+// cluster. The yaml configuration is posted in the request
+// body, and the pipeline logs are streamed in the response
+// body. The pipeline status is returned in the response
+// trailer once the pipeline completes.
+//
+//	curl -H "Authorization: Bearer $TOKEN" \
+//	  --data-binary @.drone.yml \
+//	  http://localhost:3000/api/pipelines?name=default
+type apiHandler struct {
+	compiler runtime.Compiler
+	engine   *engine.Engine
+	linter   *linter.Linter
+	procs    int64
+	token    string
+	timeout  time.Duration
+}
+
+func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(r.Body, maxConfigSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// parse and lint the configuration. Ad-hoc pipelines are
+	// linted as untrusted pipelines.
+	manifest, err := manifest.ParseString(string(raw))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("name")
+	res, err := resource.Lookup(name, manifest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	repo := &drone.Repo{
+		Slug:    "adhoc/pipeline",
+		Name:    "pipeline",
+		Private: true,
+		Timeout: int64(h.timeout / time.Minute),
+	}
+	if err := h.linter.Lint(res, repo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().Unix()
+	build := &drone.Build{
+		Event:   "custom",
+		Status:  drone.StatusRunning,
+		Started: now,
+		Created: now,
+	}
+	stage := &drone.Stage{
+		Name:    res.GetName(),
+		Status:  drone.StatusRunning,
+		Started: now,
+		Created: now,
+	}
+	spec := h.compiler.Compile(r.Context(), runtime.CompilerArgs{
+		Pipeline: res,
+		Manifest: manifest,
+		Build:    build,
+		Repo:     repo,
+		Stage:    stage,
+		System:   &drone.System{},
+		Secret:   secret.Static(nil),
+	}).(*engine.Spec)
+	if spec.Error != "" {
+		http.Error(w, spec.Error, http.StatusBadRequest)
+		return
+	}
+
+	// create a step object for each pipeline step.
+	for _, step := range spec.Steps {
+		if step.RunPolicy == runtime.RunNever {
+			continue
+		}
+		stage.Steps = append(stage.Steps, &drone.Step{
+			Number:    len(stage.Steps) + 1,
+			Name:      step.Name,
+			Status:    drone.StatusPending,
+			ErrIgnore: step.ErrPolicy == runtime.ErrIgnore,
+		})
+	}
+
+	state := &pipeline.State{
+		Build:  build,
+		Stage:  stage,
+		Repo:   repo,
+		System: &drone.System{},
+	}
+
+	// the pipeline is canceled if the client disconnects.
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Trailer", "X-Drone-Status")
+	w.WriteHeader(http.StatusOK)
+
+	out := &flushWriter{w: w}
+	err = runtime.NewExecer(
+		pipeline.NopReporter(),
+		&apiStreamer{w: out},
+		h.engine,
+		h.procs,
+	).Exec(ctx, spec, state)
+	if err != nil {
+		logger.FromRequest(r).
+			WithError(err).
+			Warn("api: cannot execute the pipeline")
+		fmt.Fprintf(out, "error: %s\n", err)
+	}
+	w.Header().Set("X-Drone-Status", state.Stage.Status)
+}
+
+// apiStreamer streams the step output to the response,
+// prefixing each line with the step name.
+type apiStreamer struct {
+	w io.Writer
+}
+
+// Stream returns an io.WriteCloser to stream the stdout
+// and stderr of the pipeline step to the response.
+func (s *apiStreamer) Stream(_ context.Context, _ *pipeline.State, name string) io.WriteCloser {
+	return &lineWriter{w: s.w, prefix: "[" + name + "] "}
+}
+
+// lineWriter writes complete lines to the writer with the
+// prefix, buffering partial lines until the next write or
+// until the writer is closed.
+type lineWriter struct {
+	w      io.Writer
+	prefix string
+	buf    bytes.Buffer
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.buf.Write(p)
+	for {
+		i := bytes.IndexByte(l.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := l.buf.Next(i + 1)
+		if _, err := io.WriteString(l.w, l.prefix+string(line)); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+func (l *lineWriter) Close() error {
+	if l.buf.Len() != 0 {
+		_, err := io.WriteString(l.w, l.prefix+l.buf.String()+"\n")
+		l.buf.Reset()
+		return err
+	}
+	return nil
+}
+
+// flushWriter serializes writes from concurrent steps and
+// flushes each write to the client.
+type flushWriter struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+// helper function mounts the pipeline api handler in front
+// of the dashboard handler.
+func withAPI(h http.Handler, api *apiHandler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/pipelines", api)
+	mux.Handle("/", h)
+	return mux
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/client"
+	"github.com/sirupsen/logrus"
+)
+
+// capacityClient wraps the client and declines to request
+// new stages from the server while the cluster does not have
+// enough available cpu to deploy a virtual machine. This
+// leaves pending stages in the queue for other runners, or
+// for later polls, instead of accepting stages that cannot
+// be provisioned.
+//
+// The check is best-effort. The cluster resources are only
+// updated once a virtual machine is deployed, and concurrent
+// requests may therefore observe the same available cpu.
+type capacityClient struct {
+	client.Client
+
+	orka     *orka.Client
+	compute  int
+	interval time.Duration
+}
+
+// Request requests the next available build stage for
+// execution, once the cluster has available capacity.
+func (c *capacityClient) Request(ctx context.Context, args *client.Filter) (*drone.Stage, error) {
+	for {
+		nodes, err := c.orka.Nodes(ctx)
+		switch {
+		case err != nil:
+			// if the cluster resources cannot be retrieved the
+			// stage is requested, and the engine falls back to
+			// retrying the deployment.
+			logrus.WithError(err).
+				Warnln("cannot check the cluster capacity")
+			return c.Client.Request(ctx, args)
+		case nodes.Fits(c.compute):
+			return c.Client.Request(ctx, args)
+		}
+
+		logrus.WithField("cpu", c.compute).
+			Debugln("insufficient cluster capacity, waiting")
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.interval):
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/client"
+)
+
+// slotClient wraps the client and limits the number of
+// stages that are requested and executed concurrently, so
+// that pollers for multiple pipeline types share the runner
+// capacity. A slot is acquired before a stage is requested,
+// and is released when the stage completes, or when no stage
+// is returned.
+type slotClient struct {
+	client.Client
+
+	slots chan struct{}
+}
+
+// Request requests the next available build stage for
+// execution, once a slot is available.
+func (c *slotClient) Request(ctx context.Context, args *client.Filter) (*drone.Stage, error) {
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	stage, err := c.Client.Request(ctx, args)
+	if err != nil || stage == nil || stage.ID == 0 {
+		<-c.slots
+	}
+	return stage, err
+}
+
+// dispatch returns a dispatch function that releases the
+// slot when the stage completes.
+func (c *slotClient) dispatch(fn func(context.Context, *drone.Stage) error) func(context.Context, *drone.Stage) error {
+	return func(ctx context.Context, stage *drone.Stage) error {
+		defer func() { <-c.slots }()
+		return fn(ctx, stage)
+	}
+}
@@ -6,8 +6,18 @@ package daemon
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/drone-runners/drone-runner-macstadium/internal/toml"
+
+	"github.com/ghodss/yaml"
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 )
@@ -34,21 +44,29 @@ type Config struct {
 		Realm    string `envconfig:"DRONE_UI_REALM" default:"MyRealm"`
 	}
 
+	API struct {
+		Token   string        `envconfig:"DRONE_API_TOKEN"`
+		Timeout time.Duration `envconfig:"DRONE_API_TIMEOUT" default:"1h"`
+	}
+
 	Server struct {
 		Port  string `envconfig:"DRONE_HTTP_BIND" default:":3000"`
 		Proto string `envconfig:"DRONE_HTTP_PROTO"`
 		Host  string `envconfig:"DRONE_HTTP_HOST"`
 		Acme  bool   `envconfig:"DRONE_HTTP_ACME"`
+		Stats bool   `envconfig:"DRONE_HTTP_STATS"`
 	}
 
 	Runner struct {
-		Name     string            `envconfig:"DRONE_RUNNER_NAME"`
-		Capacity int               `envconfig:"DRONE_RUNNER_CAPACITY" default:"50"`
-		Procs    int64             `envconfig:"DRONE_RUNNER_MAX_PROCS"`
-		Environ  map[string]string `envconfig:"DRONE_RUNNER_ENVIRON"`
-		EnvFile  string            `envconfig:"DRONE_RUNNER_ENV_FILE"`
-		Secrets  map[string]string `envconfig:"DRONE_RUNNER_SECRETS"`
-		Labels   map[string]string `envconfig:"DRONE_RUNNER_LABELS"`
+		Name       string            `envconfig:"DRONE_RUNNER_NAME"`
+		Capacity   int               `envconfig:"DRONE_RUNNER_CAPACITY" default:"50"`
+		Procs      int64             `envconfig:"DRONE_RUNNER_MAX_PROCS"`
+		Environ    map[string]string `envconfig:"DRONE_RUNNER_ENVIRON"`
+		EnvFile    string            `envconfig:"DRONE_RUNNER_ENV_FILE"`
+		Secrets    map[string]string `envconfig:"DRONE_RUNNER_SECRETS"`
+		Labels     map[string]string `envconfig:"DRONE_RUNNER_LABELS"`
+		CompatVM   bool              `envconfig:"DRONE_RUNNER_COMPAT_VM"`
+		CompatExec bool              `envconfig:"DRONE_RUNNER_COMPAT_EXEC"`
 	}
 
 	Limit struct {
@@ -58,30 +76,214 @@ type Config struct {
 	}
 
 	Macstadium struct {
-		Endpoint   string `envconfig:"DRONE_ORKA_ENDPOINT" required:"true" default:"http://10.221.188.100"`
-		Token      string `envconfig:"DRONE_ORKA_TOKEN"    required:"true"`
-		SkipVerify bool   `envconfig:"DRONE_ORKA_SKIP_VERIFY"`
-		Dump       bool   `envconfig:"DRONE_ORKA_HTTP_DUMP"`
-		DumpBody   bool   `envconfig:"DRONE_ORKA_HTTP_DUMP_BODY"`
+		Endpoint         string        `envconfig:"DRONE_ORKA_ENDPOINT" required:"true" default:"http://10.221.188.100"`
+		Token            string        `envconfig:"DRONE_ORKA_TOKEN"    required:"true"`
+		SkipVerify       bool          `envconfig:"DRONE_ORKA_SKIP_VERIFY"`
+		Proxy            string        `envconfig:"DRONE_ORKA_PROXY"`
+		Metadata         bool          `envconfig:"DRONE_ORKA_VM_METADATA"`
+		Dump             bool          `envconfig:"DRONE_ORKA_HTTP_DUMP"`
+		DumpBody         bool          `envconfig:"DRONE_ORKA_HTTP_DUMP_BODY"`
+		CheckCapacity    bool          `envconfig:"DRONE_ORKA_CHECK_CAPACITY"`
+		CapacityInterval time.Duration `envconfig:"DRONE_ORKA_CAPACITY_INTERVAL" default:"30s"`
+		RetryMin         time.Duration `envconfig:"DRONE_ORKA_RETRY_MIN" default:"15s"`
+		RetryMax         time.Duration `envconfig:"DRONE_ORKA_RETRY_MAX" default:"5m"`
+		RetryTimeout     time.Duration `envconfig:"DRONE_ORKA_RETRY_TIMEOUT" default:"1h"`
+		FailFast         bool          `envconfig:"DRONE_ORKA_FAIL_FAST"`
+		FairQueue        bool          `envconfig:"DRONE_ORKA_FAIR_QUEUE"`
+		CheckNodes       bool          `envconfig:"DRONE_ORKA_CHECK_NODES"`
+		MaxSetup         int           `envconfig:"DRONE_ORKA_MAX_SETUP"`
+
+		// nodes where vms repeatedly fail to become reachable
+		// are excluded from deployments for a period of time.
+		BlacklistThreshold int           `envconfig:"DRONE_ORKA_BLACKLIST_THRESHOLD"`
+		BlacklistDuration  time.Duration `envconfig:"DRONE_ORKA_BLACKLIST_DURATION" default:"30m"`
+	}
+
+	Reports struct {
+		Endpoint string `envconfig:"DRONE_REPORTS_ENDPOINT"`
+		Token    string `envconfig:"DRONE_REPORTS_TOKEN"`
+	}
+
+	Coverage struct {
+		Destination string `envconfig:"DRONE_COVERAGE_DESTINATION"`
+		Token       string `envconfig:"DRONE_COVERAGE_TOKEN"`
+	}
+
+	Artifacts struct {
+		Destination string `envconfig:"DRONE_ARTIFACTS_DESTINATION"`
+		Token       string `envconfig:"DRONE_ARTIFACTS_TOKEN"`
+	}
+
+	Diagnostics struct {
+		Destination string `envconfig:"DRONE_DIAGNOSTICS_DESTINATION"`
+		Token       string `envconfig:"DRONE_DIAGNOSTICS_TOKEN"`
+	}
+
+	SSH struct {
+		Ciphers      []string      `envconfig:"DRONE_SSH_CIPHERS"`
+		MACs         []string      `envconfig:"DRONE_SSH_MACS"`
+		KeyExchanges []string      `envconfig:"DRONE_SSH_KEY_EXCHANGES"`
+		CAKeyFile    string        `envconfig:"DRONE_SSH_CA_KEY_FILE"`
+		CertTTL      time.Duration `envconfig:"DRONE_SSH_CERT_TTL" default:"24h"`
+		Transfer     string        `envconfig:"DRONE_SSH_TRANSFER"`
+		Compress     bool          `envconfig:"DRONE_SSH_COMPRESS"`
+		MaxPacket    int           `envconfig:"DRONE_SSH_SFTP_MAX_PACKET"`
+		Concurrency  int           `envconfig:"DRONE_SSH_SFTP_CONCURRENCY"`
+
+		// the vm ssh address is optionally rewritten for vms
+		// that are reachable through a nat appliance.
+		Address string `envconfig:"DRONE_SSH_ADDRESS"`
+
+		// the vms are optionally reached through a bastion
+		// host, if the vm network is not routable.
+		BastionAddress  string `envconfig:"DRONE_SSH_BASTION_ADDRESS"`
+		BastionUsername string `envconfig:"DRONE_SSH_BASTION_USERNAME"`
+		BastionPassword string `envconfig:"DRONE_SSH_BASTION_PASSWORD"`
+		BastionKeyFile  string `envconfig:"DRONE_SSH_BASTION_KEY_FILE"`
+		BastionHostKey  string `envconfig:"DRONE_SSH_BASTION_HOST_KEY"`
+		BastionInsecure bool   `envconfig:"DRONE_SSH_BASTION_INSECURE"`
+
+		// ssh connections are optionally tunneled through a
+		// socks5 proxy, if the runner has no direct egress.
+		Proxy string `envconfig:"DRONE_SSH_PROXY"`
+
+		// the vm host keys are optionally verified against
+		// the host keys of the base images, in authorized_keys
+		// format. Otherwise host keys are trusted on first use.
+		HostKeyFile string `envconfig:"DRONE_SSH_HOST_KEY_FILE"`
 	}
 
 	VM struct {
-		Image    string `envconfig:"DRONE_VM_IMAGE"    required:"true"`
-		Compute  int    `envconfig:"DRONE_VM_CPU"      default:"12"`
-		Username string `envconfig:"DRONE_VM_USERNAME" default:"admin"`
-		Password string `envconfig:"DRONE_VM_PASSWORD" default:"admin"`
+		Image          string            `envconfig:"DRONE_VM_IMAGE"    required:"true"`
+		Compute        int               `envconfig:"DRONE_VM_CPU"      default:"12"`
+		MaxCompute     int               `envconfig:"DRONE_VM_MAX_CPU"`
+		MaxRAMDisk     string            `envconfig:"DRONE_VM_MAX_RAMDISK"`
+		Username       string            `envconfig:"DRONE_VM_USERNAME" default:"admin"`
+		Password       string            `envconfig:"DRONE_VM_PASSWORD" default:"admin"`
+		EphemeralKey   bool              `envconfig:"DRONE_VM_EPHEMERAL_KEY"`
+		RotatePassword bool              `envconfig:"DRONE_VM_ROTATE_PASSWORD"`
+		Aliases        map[string]string `envconfig:"DRONE_VM_IMAGE_ALIASES"`
+		Shell          string            `envconfig:"DRONE_VM_SHELL" default:"sh"`
+		LoginShell     bool              `envconfig:"DRONE_VM_LOGIN_SHELL"`
+		Path           []string          `envconfig:"DRONE_VM_PATH" default:"/opt/homebrew/bin,/opt/homebrew/sbin,/usr/local/bin"`
+		Locale         string            `envconfig:"DRONE_VM_LOCALE" default:"en_US.UTF-8"`
+		Pipefail       bool              `envconfig:"DRONE_VM_PIPEFAIL"`
+		Nounset        bool              `envconfig:"DRONE_VM_NOUNSET"`
+		IdleTimeout    time.Duration     `envconfig:"DRONE_VM_IDLE_TIMEOUT"`
+
+		// Images and Tags optionally define the image and
+		// node tag for each platform architecture.
+		Images map[string]string `envconfig:"DRONE_VM_PLATFORM_IMAGES"`
+		Tags   map[string]string `envconfig:"DRONE_VM_PLATFORM_TAGS"`
+
+		// DisableSpotlight, DisableUpdates and DisableSleep
+		// tune the virtual machine for continuous integration.
+		DisableSpotlight bool `envconfig:"DRONE_VM_DISABLE_SPOTLIGHT"`
+		DisableUpdates   bool `envconfig:"DRONE_VM_DISABLE_UPDATES"`
+		DisableSleep     bool `envconfig:"DRONE_VM_DISABLE_SLEEP"`
+
+		// KnownHosts writes the known hosts of common git
+		// providers, or the known hosts file, to the build
+		// user known_hosts file.
+		KnownHosts     bool   `envconfig:"DRONE_VM_KNOWN_HOSTS"`
+		KnownHostsFile string `envconfig:"DRONE_VM_KNOWN_HOSTS_FILE"`
+		KnownHostsData []byte `ignored:"true"`
+
+		// the vms of failed pipelines are optionally retained
+		// for inspection, up to the maximum duration and the
+		// maximum number of vms.
+		KeepFailed      time.Duration `envconfig:"DRONE_VM_KEEP_FAILED"`
+		KeepFailedMax   time.Duration `envconfig:"DRONE_VM_KEEP_FAILED_MAX" default:"1h"`
+		KeepFailedLimit int           `envconfig:"DRONE_VM_KEEP_FAILED_LIMIT" default:"5"`
+	}
+
+	Logs struct {
+		Timestamps string        `envconfig:"DRONE_LOGS_TIMESTAMPS"`
+		StripANSI  bool          `envconfig:"DRONE_LOGS_STRIP_ANSI"`
+		Persist    bool          `envconfig:"DRONE_LOGS_PERSIST"`
+		SystemLogs time.Duration `envconfig:"DRONE_LOGS_SYSTEM"`
+		Interval   time.Duration `envconfig:"DRONE_LOGS_FLUSH_INTERVAL" default:"1s"`
+		BatchSize  int           `envconfig:"DRONE_LOGS_BATCH_SIZE"`
+	}
+
+	Clone struct {
+		Mirrors    map[string]string `envconfig:"DRONE_CLONE_MIRRORS"`
+		CACert     []byte            `ignored:"true"`
+		CACertFile string            `envconfig:"DRONE_CLONE_CA_CERT_FILE"`
+		SkipVerify bool              `envconfig:"DRONE_CLONE_SKIP_VERIFY"`
+	}
+
+	Netrc struct {
+		Disabled bool `envconfig:"DRONE_NETRC_DISABLED"`
+		Public   bool `envconfig:"DRONE_NETRC_PUBLIC"`
+	}
+
+	Usage struct {
+		Export   string        `envconfig:"DRONE_USAGE_EXPORT"`
+		Token    string        `envconfig:"DRONE_USAGE_EXPORT_TOKEN"`
+		Format   string        `envconfig:"DRONE_USAGE_EXPORT_FORMAT" default:"json"`
+		Interval time.Duration `envconfig:"DRONE_USAGE_EXPORT_INTERVAL" default:"1h"`
+	}
+
+	Cleanup struct {
+		Enabled bool          `envconfig:"DRONE_CLEANUP_ENABLED"`
+		Time    string        `envconfig:"DRONE_CLEANUP_TIME" default:"03:00"`
+		TTL     time.Duration `envconfig:"DRONE_CLEANUP_TTL" default:"24h"`
+	}
+
+	// Chaos injects synthetic failures to verify the retry
+	// and cleanup configuration. Must not be enabled in
+	// production.
+	Chaos struct {
+		Enabled bool    `envconfig:"DRONE_CHAOS_ENABLED"`
+		Deploy  float64 `envconfig:"DRONE_CHAOS_DEPLOY"`
+		Dial    float64 `envconfig:"DRONE_CHAOS_DIAL"`
+		Session float64 `envconfig:"DRONE_CHAOS_SESSION"`
+	}
+
+	Pool struct {
+		Schedule []string      `envconfig:"DRONE_POOL_SCHEDULE"`
+		Interval time.Duration `envconfig:"DRONE_POOL_INTERVAL" default:"1m"`
 	}
 
 	Environ struct {
-		Endpoint   string `envconfig:"DRONE_ENV_PLUGIN_ENDPOINT"`
-		Token      string `envconfig:"DRONE_ENV_PLUGIN_TOKEN"`
-		SkipVerify bool   `envconfig:"DRONE_ENV_PLUGIN_SKIP_VERIFY"`
+		Endpoint   string   `envconfig:"DRONE_ENV_PLUGIN_ENDPOINT"`
+		Token      string   `envconfig:"DRONE_ENV_PLUGIN_TOKEN"`
+		SkipVerify bool     `envconfig:"DRONE_ENV_PLUGIN_SKIP_VERIFY"`
+		Reserved   []string `envconfig:"DRONE_ENV_RESERVED" default:"DRONE_,CI_"`
 	}
 
 	Secret struct {
 		Endpoint   string `envconfig:"DRONE_SECRET_PLUGIN_ENDPOINT"`
 		Token      string `envconfig:"DRONE_SECRET_PLUGIN_TOKEN"`
 		SkipVerify bool   `envconfig:"DRONE_SECRET_PLUGIN_SKIP_VERIFY"`
+		Strict     bool   `envconfig:"DRONE_SECRET_STRICT"`
+		Lazy       bool   `envconfig:"DRONE_SECRET_LAZY"`
+
+		// Events restricts secrets, by name pattern, to a
+		// pipe-separated list of build events. Secrets that
+		// match multiple patterns must be allowed by each.
+		Events map[string]string `envconfig:"DRONE_SECRET_EVENTS"`
+	}
+
+	Vault struct {
+		Address  string `envconfig:"DRONE_VAULT_ADDR"`
+		Token    string `envconfig:"DRONE_VAULT_TOKEN"`
+		RoleID   string `envconfig:"DRONE_VAULT_APPROLE_ID"`
+		SecretID string `envconfig:"DRONE_VAULT_APPROLE_SECRET"`
+		Path     string `envconfig:"DRONE_VAULT_PATH"`
+		Dump     bool   `envconfig:"DRONE_VAULT_HTTP_DUMP"`
+		DumpBody bool   `envconfig:"DRONE_VAULT_HTTP_DUMP_BODY"`
+	}
+
+	AWS struct {
+		Service   string `envconfig:"DRONE_AWS_SECRET_SERVICE" default:"secretsmanager"`
+		Region    string `envconfig:"DRONE_AWS_REGION"`
+		Prefix    string `envconfig:"DRONE_AWS_SECRET_PREFIX"`
+		AccessKey string `envconfig:"DRONE_AWS_ACCESS_KEY_ID"`
+		SecretKey string `envconfig:"DRONE_AWS_SECRET_ACCESS_KEY"`
+		Dump      bool   `envconfig:"DRONE_AWS_HTTP_DUMP"`
+		DumpBody  bool   `envconfig:"DRONE_AWS_HTTP_DUMP_BODY"`
 	}
 }
 
@@ -91,6 +293,122 @@ var legacy = map[string]string{
 	// "DRONE_VARIABLE_OLD": "DRONE_VARIABLE_NEW"
 }
 
+// fromFile loads the configuration file and exports each
+// setting as its environment variable equivalent, unless the
+// environment variable is already set. This allows the file
+// to be used together with, and overridden by, environment
+// variables. Settings are structured to mirror the Config
+// structure using snake case keys. For example:
+//
+//	client:
+//	  host: drone.company.com
+//	vm:
+//	  image: Drone.img
+//	  ephemeral_key: true
+//
+// The file must be yaml, or json, which is a subset of yaml.
+// Files with the .toml extension are parsed as toml, where
+// settings are grouped in tables instead. For example:
+//
+//	[client]
+//	host = "drone.company.com"
+//	[vm]
+//	image = "Drone.img"
+//	ephemeral_key = true
+func fromFile(path string) ([]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	in := map[string]interface{}{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		in, err = toml.Unmarshal(raw)
+	} else {
+		err = yaml.Unmarshal(raw, &in)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var exported []string
+	err = exportFile(in, reflect.TypeOf(Config{}), &exported)
+	return exported, err
+}
+
+// helper function recursively exports the configuration
+// file values as environment variables, and appends the
+// exported variable names to the slice.
+func exportFile(in map[string]interface{}, t reflect.Type, exported *[]string) error {
+	for key, value := range in {
+		field, ok := findField(t, key)
+		if !ok {
+			return fmt.Errorf("config: unknown setting %q", key)
+		}
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+			nested, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("config: setting %q must be a map", key)
+			}
+			if err := exportFile(nested, field.Type, exported); err != nil {
+				return err
+			}
+			continue
+		}
+		name := field.Tag.Get("envconfig")
+		if name == "" {
+			continue
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		os.Setenv(name, encodeValue(value))
+		*exported = append(*exported, name)
+	}
+	return nil
+}
+
+// helper function returns the struct field that matches the
+// snake case configuration key.
+func findField(t reflect.Type, key string) (reflect.StructField, bool) {
+	key = strings.Replace(key, "_", "", -1)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if strings.EqualFold(field.Name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// helper function encodes the configuration file value using
+// the envconfig string format.
+func encodeValue(v interface{}) string {
+	switch v := v.(type) {
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			parts = append(parts, fmt.Sprint(item))
+		}
+		return strings.Join(parts, ",")
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var parts []string
+		for _, k := range keys {
+			parts = append(parts, k+":"+fmt.Sprint(v[k]))
+		}
+		return strings.Join(parts, ",")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
 func fromEnviron() (Config, error) {
 	// loop through legacy environment variable and, if set
 	// rewrite to the new variable name.
@@ -108,6 +426,9 @@ func fromEnviron() (Config, error) {
 	if config.Runner.Environ == nil {
 		config.Runner.Environ = map[string]string{}
 	}
+	if config.Runner.Labels == nil {
+		config.Runner.Labels = map[string]string{}
+	}
 	if config.Runner.Name == "" {
 		config.Runner.Name, _ = os.Hostname()
 	}
@@ -133,5 +454,23 @@ func fromEnviron() (Config, error) {
 		}
 	}
 
+	// the certificate authority trusted by the clone step
+	// is sourced from a separate file.
+	if file := config.Clone.CACertFile; file != "" {
+		config.Clone.CACert, err = ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	// the known hosts written to the virtual machine are
+	// sourced from a separate file.
+	if file := config.VM.KnownHostsFile; file != "" {
+		config.VM.KnownHostsData, err = ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+	}
+
 	return config, nil
 }
@@ -0,0 +1,88 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFromFile(t *testing.T) {
+	testFromFile(t, "testdata/config.yml")
+}
+
+func TestFromFile_TOML(t *testing.T) {
+	testFromFile(t, "testdata/config.toml")
+}
+
+func testFromFile(t *testing.T, path string) {
+	// environment variables take precedence over the
+	// configuration file.
+	os.Setenv("DRONE_VM_IMAGE", "Override.img")
+	defer os.Unsetenv("DRONE_VM_IMAGE")
+
+	exported, err := fromFile(path)
+	defer unsetenv(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range exported {
+		if name == "DRONE_VM_IMAGE" {
+			t.Errorf("Expect environment variable not overridden by the file")
+		}
+	}
+
+	config, err := fromEnviron()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Client.Host, "drone.company.com"; got != want {
+		t.Errorf("Want host %q, got %q", want, got)
+	}
+	if got, want := config.Macstadium.Token, "orka-token"; got != want {
+		t.Errorf("Want token %q, got %q", want, got)
+	}
+	if got, want := config.Macstadium.RetryMin, time.Second*30; got != want {
+		t.Errorf("Want retry min %s, got %s", want, got)
+	}
+	if got, want := config.Macstadium.MaxSetup, 4; got != want {
+		t.Errorf("Want max setup %d, got %d", want, got)
+	}
+	if got, want := config.VM.Image, "Override.img"; got != want {
+		t.Errorf("Want image %q, got %q", want, got)
+	}
+	if !config.VM.EphemeralKey {
+		t.Errorf("Expect ephemeral key enabled")
+	}
+	if diff := cmp.Diff(config.Limit.Repos, []string{"octocat/*", "drone/*"}); diff != "" {
+		t.Errorf("Unexpected repos")
+		t.Log(diff)
+	}
+	want := map[string]string{
+		"xcode15": "90GVentura-Xcode15-*.img",
+		"xcode16": "90GSonoma-Xcode16-*.img",
+	}
+	if diff := cmp.Diff(config.VM.Aliases, want); diff != "" {
+		t.Errorf("Unexpected aliases")
+		t.Log(diff)
+	}
+}
+
+func TestFromFile_Unknown(t *testing.T) {
+	exported, err := fromFile("testdata/config_unknown.yml")
+	defer unsetenv(exported)
+	if err == nil {
+		t.Errorf("Expect error for unknown setting")
+	}
+}
+
+func unsetenv(names []string) {
+	for _, name := range names {
+		os.Unsetenv(name)
+	}
+}
@@ -6,14 +6,21 @@ package daemon
 
 import (
 	"context"
+	"errors"
+	"io/ioutil"
 	"time"
 
 	"github.com/drone-runners/drone-runner-macstadium/engine"
 	"github.com/drone-runners/drone-runner-macstadium/engine/compiler"
 	"github.com/drone-runners/drone-runner-macstadium/engine/linter"
 	"github.com/drone-runners/drone-runner-macstadium/engine/resource"
-	"github.com/drone-runners/drone-runner-macstadium/internal/match"
+	"github.com/drone-runners/drone-runner-macstadium/internal/accounting"
+	"github.com/drone-runners/drone-runner-macstadium/internal/alias"
+	"github.com/drone-runners/drone-runner-macstadium/internal/artifact"
+	"github.com/drone-runners/drone-runner-macstadium/internal/aws"
 	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+	"github.com/drone-runners/drone-runner-macstadium/internal/units"
+	"github.com/drone-runners/drone-runner-macstadium/internal/vault"
 
 	"github.com/drone/runner-go/client"
 	"github.com/drone/runner-go/environ/provider"
@@ -30,6 +37,7 @@ import (
 
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -38,13 +46,28 @@ import (
 var nocontext = context.Background()
 
 type daemonCommand struct {
-	envfile string
+	envfile    string
+	configfile string
+
+	// exported tracks the environment variables exported
+	// from the configuration file.
+	exported []string
 }
 
 func (c *daemonCommand) run(*kingpin.ParseContext) error {
 	// load environment variables from file.
 	godotenv.Load(c.envfile)
 
+	// load the configuration file, if set. The configuration
+	// file values are overridden by environment variables.
+	if c.configfile != "" {
+		exported, err := fromFile(c.configfile)
+		if err != nil {
+			return err
+		}
+		c.exported = exported
+	}
+
 	// load the configuration from the environment
 	config, err := fromEnviron()
 	if err != nil {
@@ -80,7 +103,18 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		),
 	)
 
+	// the orka api client uses a dedicated transport, with
+	// a proxy independent of the pipeline proxy.
+	httpClient, err := orka.NewHTTPClient(
+		config.Macstadium.Proxy,
+		config.Macstadium.SkipVerify,
+	)
+	if err != nil {
+		logrus.WithError(err).
+			Fatalln("cannot configure the orka client")
+	}
 	orka := &orka.Client{
+		Client:   httpClient,
 		Endpoint: config.Macstadium.Endpoint,
 		Token:    config.Macstadium.Token,
 	}
@@ -89,7 +123,142 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 			config.Macstadium.DumpBody,
 		)
 	}
-	engine, err := engine.New(orka)
+	var schedules []*engine.Schedule
+	for _, s := range config.Pool.Schedule {
+		sched, err := engine.ParseSchedule(s)
+		if err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot parse the warm pool schedule")
+		}
+		schedules = append(schedules, sched)
+	}
+	cleanupClock, err := engine.ParseClock(config.Cleanup.Time)
+	if err != nil {
+		logrus.WithError(err).
+			Fatalln("cannot parse the cleanup time")
+	}
+	warmSettings := engine.Settings{
+		Compute:  config.VM.Compute,
+		Username: config.VM.Username,
+		Password: config.VM.Password,
+	}
+	authority, err := loadAuthority(config.SSH.CAKeyFile)
+	if err != nil {
+		logrus.WithError(err).
+			Fatalln("cannot load the ssh certificate authority")
+	}
+	bastion, err := loadBastion(config)
+	if err != nil {
+		logrus.WithError(err).
+			Fatalln("cannot load the ssh bastion host")
+	}
+	hostKeys, err := loadHostKeys(config.SSH.HostKeyFile)
+	if err != nil {
+		logrus.WithError(err).
+			Fatalln("cannot load the vm host keys")
+	}
+	// coverage files are optionally uploaded to the
+	// configured destination.
+	var coverage artifact.Uploader
+	if config.Coverage.Destination != "" {
+		coverage, err = artifact.New(
+			config.Coverage.Destination,
+			config.Coverage.Token,
+			setupAWS(config),
+		)
+		if err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot configure the coverage destination")
+		}
+	}
+	// diagnostic bundles are optionally uploaded to the
+	// configured destination.
+	var diagnostics artifact.Uploader
+	if config.Diagnostics.Destination != "" {
+		diagnostics, err = artifact.New(
+			config.Diagnostics.Destination,
+			config.Diagnostics.Token,
+			setupAWS(config),
+		)
+		if err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot configure the diagnostics destination")
+		}
+	}
+	// step artifacts are optionally uploaded to the
+	// configured destination.
+	var artifacts artifact.Uploader
+	if config.Artifacts.Destination != "" {
+		artifacts, err = artifact.New(
+			config.Artifacts.Destination,
+			config.Artifacts.Token,
+			setupAWS(config),
+		)
+		if err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot configure the artifacts destination")
+		}
+	}
+	// the known hosts default to the known hosts of common
+	// git providers, unless a known hosts file is provided.
+	knownHosts := config.VM.KnownHostsData
+	if len(knownHosts) == 0 && config.VM.KnownHosts {
+		knownHosts = []byte(engine.DefaultKnownHosts)
+	}
+
+	// synthetic failures are only injected if fault injection
+	// is explicitly enabled.
+	var faults engine.Faults
+	if config.Chaos.Enabled {
+		logrus.Warnln("fault injection is enabled, do not use in production")
+		faults = engine.Faults{
+			Deploy:  config.Chaos.Deploy,
+			Dial:    config.Chaos.Dial,
+			Session: config.Chaos.Session,
+		}
+	}
+
+	// image aliases are resolved to the newest matching image
+	// at compile time, and are updated to point at the images
+	// saved by bake pipelines.
+	aliases := alias.New(orka, config.VM.Aliases, time.Minute)
+
+	engine, err := engine.New(orka, engine.Opts{
+		Ciphers:              config.SSH.Ciphers,
+		MACs:                 config.SSH.MACs,
+		KeyExchanges:         config.SSH.KeyExchanges,
+		CertificateAuthority: authority,
+		CertificateTTL:       config.SSH.CertTTL,
+		Backoff:              engineBackoff(config),
+		FailFast:             config.Macstadium.FailFast,
+		FairQueue:            config.Macstadium.FairQueue,
+		CheckNodes:           config.Macstadium.CheckNodes,
+		MaxSetup:             config.Macstadium.MaxSetup,
+		Transfer:             config.SSH.Transfer,
+		Compress:             config.SSH.Compress,
+		SFTPMaxPacket:        config.SSH.MaxPacket,
+		SFTPConcurrency:      config.SSH.Concurrency,
+		ReportEndpoint:       config.Reports.Endpoint,
+		ReportToken:          config.Reports.Token,
+		Coverage:             coverage,
+		Diagnostics:          diagnostics,
+		Artifacts:            artifacts,
+		StripANSI:            config.Logs.StripANSI,
+		PersistLogs:          config.Logs.Persist,
+		KnownHosts:           knownHosts,
+
+		BlacklistThreshold: config.Macstadium.BlacklistThreshold,
+		BlacklistDuration:  config.Macstadium.BlacklistDuration,
+		Faults:             faults,
+		Address:            config.SSH.Address,
+		Aliases:            aliases,
+		Bastion:            bastion,
+		Proxy:              config.SSH.Proxy,
+		Metadata:           config.Macstadium.Metadata,
+		Runner:             config.Runner.Name,
+		MaxRetained:        config.VM.KeepFailedLimit,
+		HostKeys:           hostKeys,
+	})
 	if err != nil {
 		logrus.WithError(err).
 			Fatalln("cannot load the engine")
@@ -109,74 +278,161 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		}
 	}
 
+	// warm virtual machines are provisioned ahead of time
+	// according to the configured schedules.
+	if len(schedules) != 0 {
+		go engine.Warm(ctx, warmSettings, schedules, config.Pool.Interval)
+	}
+
+	// stale vm configurations are optionally deleted once a
+	// day at the configured time.
+	if config.Cleanup.Enabled {
+		go engine.Cleanup(ctx, cleanupClock, config.Cleanup.TTL)
+	}
+
+	// vm usage records are optionally exported to a file or
+	// http endpoint for capacity planning.
+	if config.Usage.Export != "" {
+		exporter, err := accounting.New(
+			config.Usage.Export,
+			config.Usage.Token,
+			config.Usage.Format,
+		)
+		if err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot configure the usage export")
+		}
+		go exportUsage(ctx, exporter, config.Usage.Interval)
+	}
+
+	// settings that are safe to change while the runner is
+	// running are reloaded when the SIGHUP signal is received.
+	reload := newReloadable(config, aliases, engine)
+	go c.watch(ctx, reload)
+
 	remote := remote.New(cli)
 	tracer := history.New(remote)
 	hook := loghistory.New()
 	logrus.AddHook(hook)
 
+	// step output is streamed to the server with the
+	// configured flush interval and batch size.
+	streamer := &streamer{
+		client:   cli,
+		interval: config.Logs.Interval,
+		batch:    config.Logs.BatchSize,
+	}
+
+	// the linter rejects pipelines that request more cpu or
+	// ram disk volumes than the runner limits.
+	lint := linter.New()
+	lint.MaxCompute = config.VM.MaxCompute
+	lint.DefaultCompute = config.VM.Compute
+	if config.VM.MaxRAMDisk != "" {
+		lint.MaxRAMDisk, err = units.ParseBytes(config.VM.MaxRAMDisk)
+		if err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot parse the ram disk limit")
+		}
+	}
+
 	runner := &runtime.Runner{
 		Client:   cli,
 		Machine:  config.Runner.Name,
 		Reporter: tracer,
 		Lookup:   resource.Lookup,
-		Lint:     linter.New().Lint,
-		Match: match.Func(
-			config.Limit.Repos,
-			config.Limit.Events,
-			config.Limit.Trusted,
-		),
-		Compiler: &compiler.Compiler{
-			Settings: compiler.Settings{
-				Compute:  config.VM.Compute,
-				Image:    config.VM.Image,
-				Username: config.VM.Username,
-				Password: config.VM.Password,
-			},
-			Environ: provider.Combine(
-				provider.Static(config.Runner.Environ),
-				provider.External(
-					config.Environ.Endpoint,
-					config.Environ.Token,
-					config.Environ.SkipVerify,
-				),
-			),
-			Secret: secret.Combine(
-				secret.StaticVars(
-					config.Runner.Secrets,
-				),
-				secret.External(
-					config.Secret.Endpoint,
-					config.Secret.Token,
-					config.Secret.SkipVerify,
-				),
-			),
-		},
+		Lint:     lint.Lint,
+		Match:    reload.Match,
+		Compiler: reload,
 		Exec: runtime.NewExecer(
 			tracer,
-			remote,
+			streamer,
 			engine,
 			config.Runner.Procs,
 		).Exec,
 	}
 
-	poller := &poller.Poller{
-		Client:   cli,
-		Dispatch: runner.Run,
-		Filter: &client.Filter{
+	// the poller optionally declines to request stages when
+	// the cluster is at capacity.
+	var pollerClient client.Client = cli
+	if config.Macstadium.CheckCapacity {
+		pollerClient = &capacityClient{
+			Client:   cli,
+			orka:     orka,
+			compute:  config.VM.Compute,
+			interval: config.Macstadium.CapacityInterval,
+		}
+	}
+
+	// in compatibility mode pipelines written for the vm
+	// runner, and darwin pipelines written for the exec
+	// runner, are polled separately, and the pollers share
+	// the runner capacity.
+	filters := []*client.Filter{
+		{
 			Kind:   resource.Kind,
 			Type:   resource.Type,
 			Labels: config.Runner.Labels,
 		},
 	}
+	if config.Runner.CompatVM {
+		filters = append(filters, &client.Filter{
+			Kind:   resource.Kind,
+			Type:   resource.TypeVM,
+			Labels: config.Runner.Labels,
+		})
+	}
+	if config.Runner.CompatExec {
+		filters = append(filters, &client.Filter{
+			Kind:   resource.Kind,
+			Type:   resource.TypeExec,
+			OS:     "darwin",
+			Labels: config.Runner.Labels,
+		})
+	}
+	dispatch := runner.Run
+	if len(filters) > 1 {
+		slots := &slotClient{
+			Client: pollerClient,
+			slots:  make(chan struct{}, config.Runner.Capacity),
+		}
+		pollerClient = slots
+		dispatch = slots.dispatch(runner.Run)
+	}
+
+	var pollers []*poller.Poller
+	for _, filter := range filters {
+		pollers = append(pollers, &poller.Poller{
+			Client:   pollerClient,
+			Dispatch: dispatch,
+			Filter:   filter,
+		})
+	}
 
 	var g errgroup.Group
+	handler := router.New(tracer, hook, router.Config{
+		Username: config.Dashboard.Username,
+		Password: config.Dashboard.Password,
+		Realm:    config.Dashboard.Realm,
+	})
+	if config.Server.Stats {
+		handler = withStats(handler, config)
+	}
+	// ad-hoc pipelines may be executed with the api, outside
+	// of the server queue, if an api token is configured.
+	if config.API.Token != "" {
+		handler = withAPI(handler, &apiHandler{
+			compiler: reload,
+			engine:   engine,
+			linter:   lint,
+			procs:    config.Runner.Procs,
+			token:    config.API.Token,
+			timeout:  config.API.Timeout,
+		})
+	}
 	server := server.Server{
-		Addr: config.Server.Port,
-		Handler: router.New(tracer, hook, router.Config{
-			Username: config.Dashboard.Username,
-			Password: config.Dashboard.Password,
-			Realm:    config.Dashboard.Realm,
-		}),
+		Addr:    config.Server.Port,
+		Handler: handler,
 	}
 
 	logrus.WithField("addr", config.Server.Port).
@@ -208,25 +464,156 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		}
 	}
 
-	g.Go(func() error {
-		logrus.WithField("capacity", config.Runner.Capacity).
-			WithField("endpoint", config.Client.Address).
-			WithField("kind", resource.Kind).
-			WithField("type", resource.Type).
-			Infoln("polling the remote server")
+	for _, p := range pollers {
+		p := p
+		g.Go(func() error {
+			logrus.WithField("capacity", config.Runner.Capacity).
+				WithField("endpoint", config.Client.Address).
+				WithField("kind", p.Filter.Kind).
+				WithField("type", p.Filter.Type).
+				Infoln("polling the remote server")
 
-		poller.Poll(ctx, config.Runner.Capacity)
-		return nil
-	})
+			p.Poll(ctx, config.Runner.Capacity)
+			return nil
+		})
+	}
 
 	err = g.Wait()
 	if err != nil {
 		logrus.WithError(err).
 			Errorln("shutting down the server")
 	}
+
+	// failed vms retained for inspection are deleted when
+	// the runner shuts down.
+	engine.PurgeRetained()
 	return err
 }
 
+// helper function configures the compiler from the loaded
+// configuration.
+func setupCompiler(config Config, aliases *alias.Resolver, vaultClient *vault.Client) *compiler.Compiler {
+	// the aws secret provider is optional and is only
+	// enabled when the aws region is configured.
+	awsClient := setupAWS(config)
+
+	return &compiler.Compiler{
+		Settings: compiler.Settings{
+			Compute:        config.VM.Compute,
+			Image:          config.VM.Image,
+			PlatformImages: config.VM.Images,
+			PlatformTags:   config.VM.Tags,
+			Username:       config.VM.Username,
+			Password:       config.VM.Password,
+			EphemeralKey:   config.VM.EphemeralKey,
+			RotatePassword: config.VM.RotatePassword,
+			Shell:          config.VM.Shell,
+			LoginShell:     config.VM.LoginShell,
+			Path:           config.VM.Path,
+			Locale:         config.VM.Locale,
+			Pipefail:       config.VM.Pipefail,
+			Nounset:        config.VM.Nounset,
+			IdleTimeout:    config.VM.IdleTimeout,
+			Timestamps:     config.Logs.Timestamps,
+			SystemLogs:     config.Logs.SystemLogs,
+			CloneMirrors:   config.Clone.Mirrors,
+			CloneCACert:    config.Clone.CACert,
+			CloneInsecure:  config.Clone.SkipVerify,
+			DisableNetrc:   config.Netrc.Disabled,
+			NetrcPublic:    config.Netrc.Public,
+			StrictSecrets:  config.Secret.Strict,
+			LazySecrets:    config.Secret.Lazy,
+			SecretEvents:   config.Secret.Events,
+			Reserved:       config.Environ.Reserved,
+
+			DisableSpotlight: config.VM.DisableSpotlight,
+			DisableUpdates:   config.VM.DisableUpdates,
+			DisableSleep:     config.VM.DisableSleep,
+			KeepFailed:       config.VM.KeepFailed,
+			KeepFailedMax:    config.VM.KeepFailedMax,
+		},
+		Environ: provider.Combine(
+			provider.Static(config.Runner.Environ),
+			provider.External(
+				config.Environ.Endpoint,
+				config.Environ.Token,
+				config.Environ.SkipVerify,
+			),
+		),
+		Secret: secret.Combine(
+			secret.StaticVars(
+				config.Runner.Secrets,
+			),
+			secret.External(
+				config.Secret.Endpoint,
+				config.Secret.Token,
+				config.Secret.SkipVerify,
+			),
+			vault.NewProvider(
+				vaultClient,
+				config.Vault.Path,
+			),
+			aws.NewProvider(
+				awsClient,
+				config.AWS.Service,
+				config.AWS.Prefix,
+			),
+		),
+		Resolve: aliases.Resolve,
+		Labels:  config.Runner.Labels,
+	}
+}
+
+// helper function configures the vault client from the loaded
+// configuration. The vault secret provider is optional and
+// is only enabled when the vault address is configured.
+func setupVault(config Config) *vault.Client {
+	if config.Vault.Address == "" {
+		return nil
+	}
+	client := &vault.Client{
+		Address:  config.Vault.Address,
+		Token:    config.Vault.Token,
+		RoleID:   config.Vault.RoleID,
+		SecretID: config.Vault.SecretID,
+	}
+	if config.Vault.Dump {
+		client.Dumper = logger.StandardDumper(
+			config.Vault.DumpBody,
+		)
+	}
+	return client
+}
+
+// helper function configures the aws client from the loaded
+// configuration. The client is only configured when the aws
+// region is set. Credentials are sourced from the runner
+// configuration, the standard environment variables, or the
+// instance role.
+func setupAWS(config Config) *aws.Client {
+	if config.AWS.Region == "" {
+		return nil
+	}
+	client := &aws.Client{
+		Region: config.AWS.Region,
+		Credentials: aws.Chain(
+			aws.Static(
+				config.AWS.AccessKey,
+				config.AWS.SecretKey,
+				"",
+			),
+			aws.Environ(),
+			aws.InstanceRole(),
+		),
+	}
+	if config.AWS.Dump {
+		client.Dumper = logger.StandardDumper(
+			config.AWS.DumpBody,
+		)
+	}
+	return client
+}
+
 // helper function configures the global logger from
 // the loaded configuration.
 func setupLogger(config Config) {
@@ -243,6 +630,85 @@ func setupLogger(config Config) {
 	}
 }
 
+// helper function loads the ssh certificate authority private
+// key from the file, if set.
+func loadAuthority(path string) (ssh.Signer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(raw)
+}
+
+// helper function returns the retry schedule used when the
+// cluster has insufficient capacity.
+func engineBackoff(config Config) engine.Backoff {
+	return engine.Backoff{
+		Min:     config.Macstadium.RetryMin,
+		Max:     config.Macstadium.RetryMax,
+		Timeout: config.Macstadium.RetryTimeout,
+	}
+}
+
+// helper function returns the vm host keys, or nil if no
+// host keys are configured.
+func loadHostKeys(path string) ([]ssh.PublicKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return engine.ParseHostKeys(raw)
+}
+
+// helper function returns the ssh bastion host, or nil if
+// no bastion host is configured.
+func loadBastion(config Config) (*engine.Bastion, error) {
+	if config.SSH.BastionAddress == "" {
+		return nil, nil
+	}
+	if config.SSH.BastionUsername == "" {
+		return nil, errors.New("bastion username is required")
+	}
+	bastion := &engine.Bastion{
+		Address:  config.SSH.BastionAddress,
+		Username: config.SSH.BastionUsername,
+		Password: config.SSH.BastionPassword,
+	}
+	if config.SSH.BastionKeyFile != "" {
+		raw, err := ioutil.ReadFile(config.SSH.BastionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		bastion.Signer, err = ssh.ParsePrivateKey(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the bastion host key must be verified, unless
+	// verification is explicitly disabled.
+	switch {
+	case config.SSH.BastionHostKey != "":
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(config.SSH.BastionHostKey))
+		if err != nil {
+			return nil, err
+		}
+		bastion.HostKey = key
+	case config.SSH.BastionInsecure:
+		logrus.Warnln("bastion host key verification is disabled, " +
+			"connections to the vms are vulnerable to interception")
+	default:
+		return nil, errors.New("bastion host key is required, " +
+			"set DRONE_SSH_BASTION_INSECURE to disable verification")
+	}
+	return bastion, nil
+}
+
 // Register the daemon command.
 func Register(app *kingpin.Application) {
 	c := new(daemonCommand)
@@ -254,4 +720,8 @@ func Register(app *kingpin.Application) {
 	cmd.Arg("envfile", "load the environment variable file").
 		Default("").
 		StringVar(&c.envfile)
+
+	cmd.Flag("config", "load the yaml configuration file").
+		Envar("DRONE_CONFIG_FILE").
+		StringVar(&c.configfile)
 }
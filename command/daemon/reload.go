@@ -0,0 +1,151 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/drone-runners/drone-runner-macstadium/engine"
+	"github.com/drone-runners/drone-runner-macstadium/engine/compiler"
+	"github.com/drone-runners/drone-runner-macstadium/internal/alias"
+	"github.com/drone-runners/drone-runner-macstadium/internal/match"
+	"github.com/drone-runners/drone-runner-macstadium/internal/vault"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline/runtime"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadable holds the settings that are safe to change while
+// the runner is running. This includes the default virtual
+// machine settings, the static environment and secrets, the
+// repository and event allowlists, and the engine retry
+// backoff, setup limit and fail fast settings. Settings that
+// affect the server connection, runner capacity or in-flight
+// virtual machines require a restart.
+type reloadable struct {
+	aliases  *alias.Resolver
+	engine   *engine.Engine
+	capacity int
+
+	// the vault client is re-used unless the vault settings
+	// change, since the client caches the approle login.
+	vault  *vault.Client
+	config Config
+
+	mu       sync.RWMutex
+	compiler *compiler.Compiler
+	match    func(*drone.Repo, *drone.Build) bool
+}
+
+// newReloadable returns a new reloadable from the config.
+func newReloadable(config Config, aliases *alias.Resolver, engine *engine.Engine) *reloadable {
+	r := &reloadable{
+		aliases:  aliases,
+		engine:   engine,
+		capacity: config.Runner.Capacity,
+		vault:    setupVault(config),
+		config:   config,
+	}
+	r.update(config)
+	return r
+}
+
+// update updates the reloadable settings from the config.
+func (r *reloadable) update(config Config) {
+	// the pollers are started with the runner capacity, which
+	// cannot be changed without a restart.
+	if config.Runner.Capacity != r.capacity {
+		logrus.WithField("capacity", r.capacity).
+			WithField("requested", config.Runner.Capacity).
+			Warnln("runner capacity changed, restart the runner to apply")
+	}
+	if config.Vault != r.config.Vault {
+		r.vault = setupVault(config)
+	}
+	r.config = config
+
+	r.aliases.Configure(config.VM.Aliases)
+	compiler := setupCompiler(config, r.aliases, r.vault)
+	match := match.Func(
+		config.Limit.Repos,
+		config.Limit.Events,
+		config.Limit.Trusted,
+	)
+	r.mu.Lock()
+	r.compiler = compiler
+	r.match = match
+	r.mu.Unlock()
+
+	r.engine.Update(
+		engineBackoff(config),
+		config.Macstadium.MaxSetup,
+		config.Macstadium.FailFast,
+	)
+}
+
+// Compile compiles the configuration file using the current
+// compiler settings.
+func (r *reloadable) Compile(ctx context.Context, args runtime.CompilerArgs) runtime.Spec {
+	r.mu.RLock()
+	compiler := r.compiler
+	r.mu.RUnlock()
+	return compiler.Compile(ctx, args)
+}
+
+// Match returns true if the repository and build match the
+// current allowlists.
+func (r *reloadable) Match(repo *drone.Repo, build *drone.Build) bool {
+	r.mu.RLock()
+	match := r.match
+	r.mu.RUnlock()
+	return match(repo, build)
+}
+
+// watch listens for the SIGHUP signal and reloads the
+// configuration until the context is canceled.
+func (c *daemonCommand) watch(ctx context.Context, r *reloadable) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+		}
+		config, err := c.reload()
+		if err != nil {
+			logrus.WithError(err).
+				Errorln("cannot reload the configuration")
+			continue
+		}
+		r.update(config)
+		logrus.Infoln("successfully reloaded the configuration")
+	}
+}
+
+// reload re-reads the configuration file and environment.
+// Environment variables exported from the previously loaded
+// configuration file are unset so that changes to the file
+// take effect.
+func (c *daemonCommand) reload() (Config, error) {
+	for _, key := range c.exported {
+		os.Unsetenv(key)
+	}
+	c.exported = nil
+	if c.configfile != "" {
+		exported, err := fromFile(c.configfile)
+		c.exported = exported
+		if err != nil {
+			return Config{}, err
+		}
+	}
+	return fromEnviron()
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/engine"
+	"github.com/drone-runners/drone-runner-macstadium/internal/alias"
+)
+
+func TestReloadable_Vault(t *testing.T) {
+	e, err := engine.New(nil, engine.Opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config Config
+	config.Vault.Address = "https://vault.company.com"
+	config.Vault.RoleID = "role"
+	r := newReloadable(config, alias.New(nil, nil, time.Minute), e)
+	client := r.vault
+
+	// the vault client, and the cached login, is retained
+	// when other settings are reloaded.
+	config.VM.Image = "Drone.img"
+	r.update(config)
+	if r.vault != client {
+		t.Errorf("Expect the vault client retained")
+	}
+
+	config.Vault.RoleID = "other"
+	r.update(config)
+	if r.vault == client {
+		t.Errorf("Expect the vault client replaced when the vault settings change")
+	}
+	if got, want := r.vault.RoleID, "other"; got != want {
+		t.Errorf("Want vault role %q, got %q", want, got)
+	}
+}
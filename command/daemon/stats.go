@@ -0,0 +1,70 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// start time of the process, used to calculate uptime.
+var startTime = time.Now()
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("uptime", expvar.Func(func() interface{} {
+		return int64(time.Since(startTime).Seconds())
+	}))
+}
+
+// helper function mounts the expvar handler, which exposes
+// runtime statistics (goroutines, heap and gc statistics,
+// open ssh connections and sftp sessions), and the vm usage
+// handler in front of the dashboard handler. The endpoints
+// are protected with the dashboard credentials, if
+// configured. The vm usage endpoints expose repository
+// names, and are only mounted if the dashboard password is
+// configured.
+func withStats(h http.Handler, config Config) http.Handler {
+	mux := http.NewServeMux()
+	if config.Dashboard.Password == "" {
+		mux.Handle("/debug/vars", expvar.Handler())
+		mux.Handle("/", h)
+		return mux
+	}
+	auth := func(h http.Handler) http.Handler {
+		return basicAuth(h,
+			config.Dashboard.Username,
+			config.Dashboard.Password,
+			config.Dashboard.Realm,
+		)
+	}
+	mux.Handle("/debug/vars", auth(expvar.Handler()))
+	mux.Handle("/api/usage", auth(http.HandlerFunc(usageHandler)))
+	mux.Handle("/api/usage/export", auth(http.HandlerFunc(usageExportHandler)))
+	mux.Handle("/", h)
+	return mux
+}
+
+// helper function returns an http handler that requires
+// basic authentication.
+func basicAuth(h http.Handler, username, password, realm string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithStats(t *testing.T) {
+	dashboard := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	// the usage endpoints are not mounted if the dashboard
+	// password is not configured.
+	var config Config
+	config.Dashboard.Username = "admin"
+	h := withStats(dashboard, config)
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/debug/vars", http.StatusOK},
+		{"/api/usage", http.StatusTeapot},
+		{"/api/usage/export", http.StatusTeapot},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if got := w.Code; got != test.want {
+			t.Errorf("Want status %d for %s, got %d", test.want, test.path, got)
+		}
+	}
+
+	config.Dashboard.Password = "password"
+	h = withStats(dashboard, config)
+	for _, path := range []string{"/debug/vars", "/api/usage", "/api/usage/export"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if got, want := w.Code, http.StatusUnauthorized; got != want {
+			t.Errorf("Want status %d for %s, got %d", want, path, got)
+		}
+
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.SetBasicAuth("admin", "password")
+		h.ServeHTTP(w, r)
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Errorf("Want status %d for authorized %s, got %d", want, path, got)
+		}
+	}
+}
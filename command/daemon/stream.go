@@ -0,0 +1,61 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/client"
+	"github.com/drone/runner-go/livelog"
+	"github.com/drone/runner-go/pipeline"
+)
+
+// streamer streams the step output to the server with a
+// configurable flush interval and batch size. Buffered output
+// is flushed to the server once per interval, and the flushed
+// lines are split into batches of at most the configured size
+// to limit the size of each request.
+type streamer struct {
+	client   client.Client
+	interval time.Duration
+	batch    int
+}
+
+// Stream returns an io.WriteCloser to stream the stdout
+// and stderr of the pipeline step to the server.
+func (s *streamer) Stream(ctx context.Context, state *pipeline.State, name string) io.WriteCloser {
+	src := state.Find(name)
+	var cli client.Client = s.client
+	if s.batch > 0 {
+		cli = &batchClient{Client: s.client, size: s.batch}
+	}
+	w := livelog.New(cli, src.ID)
+	if s.interval > 0 {
+		w.SetInterval(s.interval)
+	}
+	return w
+}
+
+// batchClient wraps the client and splits the batched log
+// lines into requests of at most the configured size.
+type batchClient struct {
+	client.Client
+
+	size int
+}
+
+// Batch batches and sends the log lines to the server.
+func (c *batchClient) Batch(ctx context.Context, step int64, lines []*drone.Line) error {
+	for len(lines) > c.size {
+		if err := c.Client.Batch(ctx, step, lines[:c.size]); err != nil {
+			return err
+		}
+		lines = lines[c.size:]
+	}
+	return c.Client.Batch(ctx, step, lines)
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/engine"
+	"github.com/drone-runners/drone-runner-macstadium/internal/accounting"
+
+	"github.com/sirupsen/logrus"
+)
+
+// usageHandler returns the vm core-minutes consumed by each
+// repository and namespace since the runner started, for
+// charging back macOS capacity to teams.
+func usageHandler(w http.ResponseWriter, r *http.Request) {
+	repos := engine.UsageByRepo()
+	namespaces := map[string]engine.Usage{}
+	for slug, u := range repos {
+		namespace := slug
+		if i := strings.Index(slug, "/"); i != -1 {
+			namespace = slug[:i]
+		}
+		total := namespaces[namespace]
+		total.VMs += u.VMs
+		total.CoreMinutes += u.CoreMinutes
+		namespaces[namespace] = total
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Repos      map[string]engine.Usage `json:"repos"`
+		Namespaces map[string]engine.Usage `json:"namespaces"`
+	}{repos, namespaces})
+}
+
+// usageExportHandler returns the vm usage records pending
+// export, in csv or json format, on demand.
+func usageExportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.FormValue("format")
+	if format == accounting.FormatCSV {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	err := accounting.Encode(w, format, engine.PendingUsage())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// helper function periodically exports the vm usage records
+// until the context is canceled. Records that cannot be
+// exported are retried at the next interval, and pending
+// records are exported when the context is canceled.
+func exportUsage(ctx context.Context, exporter accounting.Exporter, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			flushUsage(ctx, exporter)
+			cancel()
+			return
+		case <-time.After(interval):
+			flushUsage(ctx, exporter)
+		}
+	}
+}
+
+// helper function exports the pending vm usage records.
+func flushUsage(ctx context.Context, exporter accounting.Exporter) {
+	records := engine.DrainUsage()
+	if len(records) == 0 {
+		return
+	}
+	if err := exporter.Export(ctx, records); err != nil {
+		logrus.WithError(err).
+			WithField("records", len(records)).
+			Warnln("cannot export the vm usage")
+		engine.RequeueUsage(records)
+		return
+	}
+	logrus.WithField("records", len(records)).
+		Debugln("exported the vm usage")
+}
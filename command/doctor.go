@@ -0,0 +1,236 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/engine"
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/dchest/uniuri"
+	"github.com/drone/signal"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type doctorCommand struct {
+	Settings engine.Settings
+	Opts     engine.Opts
+	Endpoint string
+	Token    string
+	Proxy    string
+	SkipVM   bool
+	DryRun   bool
+	Timeout  time.Duration
+
+	// the vms are optionally reached through a bastion host
+	// or proxy.
+	SSH sshFlags
+}
+
+func (c *doctorCommand) run(*kingpin.ParseContext) error {
+	ctx, cancel := context.WithTimeout(nocontext, c.Timeout)
+	defer cancel()
+
+	// listen for operating system signals and cancel execution
+	// when received.
+	ctx = signal.WithContextFunc(ctx, func() {
+		println("received signal, terminating process")
+		cancel()
+	})
+
+	httpClient, err := orka.NewHTTPClient(c.Proxy, false)
+	if err != nil {
+		return err
+	}
+	client := &orka.Client{
+		Client:   httpClient,
+		Endpoint: c.Endpoint,
+		Token:    c.Token,
+	}
+
+	if err := c.SSH.load(&c.Opts); err != nil {
+		return err
+	}
+
+	r := new(report)
+
+	// verify the orka endpoint is reachable and the token
+	// is valid. The endpoint is considered reachable if the
+	// server returns a response, even if the response is an
+	// error.
+	token, err := client.CheckToken(ctx)
+	if !r.check("orka endpoint is reachable", func() error {
+		if token == nil && err != nil {
+			return err
+		}
+		return nil
+	}) {
+		return r.done()
+	}
+	if !r.check("orka token is valid", func() error {
+		switch {
+		case err != nil:
+			return err
+		case token.IsTokenRevoked:
+			return errors.New("token is revoked")
+		case !token.Authenticated:
+			return errors.New("token is not authenticated")
+		}
+		return nil
+	}) {
+		return r.done()
+	}
+
+	r.check("default image exists", func() error {
+		res, err := client.Images(ctx)
+		if err != nil {
+			return err
+		}
+		for _, image := range res.Images {
+			if image == c.Settings.Image {
+				return nil
+			}
+		}
+		return fmt.Errorf("image %q not found", c.Settings.Image)
+	})
+
+	if c.SkipVM {
+		return r.done()
+	}
+
+	// provision a throwaway virtual machine, verify ssh
+	// connectivity and command execution, and then purge
+	// the virtual machine.
+	spec := &engine.Spec{
+		Name:     "drone-doctor-" + uniuri.NewLenChars(8, []byte("abcdefghijklmnopqrstuvwxyz0123456789")),
+		Settings: c.Settings,
+	}
+	step := &engine.Step{
+		Name:    "doctor",
+		Command: "true",
+	}
+	engine, err := engine.New(client, c.Opts)
+	if err != nil {
+		return err
+	}
+
+	// in dry run mode the vm configuration is created and
+	// deleted, without deploying the vm.
+	if c.DryRun {
+		r.check("cluster capacity and vm config", func() error {
+			return engine.DryRun(ctx, spec)
+		})
+		return r.done()
+	}
+
+	if r.check("create, deploy and dial a vm", func() error {
+		return engine.Setup(ctx, spec)
+	}) {
+		r.check("run a command over ssh", func() error {
+			state, err := engine.Run(ctx, spec, step, ioutil.Discard)
+			if err != nil {
+				return err
+			}
+			if state.ExitCode != 0 {
+				return fmt.Errorf("exit code %d", state.ExitCode)
+			}
+			return nil
+		})
+	}
+	r.check("purge the vm", func() error {
+		if err := engine.Destroy(nocontext, spec); err != nil {
+			return err
+		}
+		// the vm configuration is purged directly in case
+		// the vm was never deployed.
+		client.Delete(nocontext, spec.Name)
+		return nil
+	})
+	return r.done()
+}
+
+// report collects and prints the check results.
+type report struct {
+	failed int
+}
+
+// check runs the check function and prints the result.
+func (r *report) check(name string, fn func() error) bool {
+	start := time.Now()
+	err := fn()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		r.failed++
+		fmt.Fprintf(os.Stdout, "FAIL  %s (%s): %s\n", name, elapsed, err)
+		return false
+	}
+	fmt.Fprintf(os.Stdout, "PASS  %s (%s)\n", name, elapsed)
+	return true
+}
+
+// done returns an error if one or more checks failed.
+func (r *report) done() error {
+	if r.failed != 0 {
+		return fmt.Errorf("%d check(s) failed", r.failed)
+	}
+	return nil
+}
+
+func registerDoctor(app *kingpin.Application) {
+	c := new(doctorCommand)
+
+	cmd := app.Command("doctor", "verifies the orka configuration end-to-end").
+		Action(c.run)
+
+	cmd.Flag("skip-vm", "skip provisioning a test vm").
+		BoolVar(&c.SkipVM)
+
+	cmd.Flag("dry-run", "verify the vm config without deploying a test vm").
+		BoolVar(&c.DryRun)
+
+	cmd.Flag("timeout", "maximum duration of the checks").
+		Default("30m").
+		DurationVar(&c.Timeout)
+
+	cmd.Flag("cpu", "orka cpu count").
+		Default("12").
+		Envar("DRONE_VM_CPU").
+		IntVar(&c.Settings.Compute)
+
+	cmd.Flag("endpoint", "orka endpoint").
+		Default("http://10.221.188.100").
+		Envar("DRONE_ORKA_ENDPOINT").
+		StringVar(&c.Endpoint)
+
+	cmd.Flag("token", "orka token").
+		Envar("DRONE_ORKA_TOKEN").
+		StringVar(&c.Token)
+
+	cmd.Flag("proxy", "orka api proxy url").
+		Envar("DRONE_ORKA_PROXY").
+		StringVar(&c.Proxy)
+
+	cmd.Flag("image", "orka base image").
+		Envar("DRONE_VM_IMAGE").
+		StringVar(&c.Settings.Image)
+
+	cmd.Flag("username", "image ssh username").
+		Default("admin").
+		Envar("DRONE_VM_USERNAME").
+		StringVar(&c.Settings.Username)
+
+	cmd.Flag("password", "image ssh password").
+		Default("admin").
+		Envar("DRONE_VM_PASSWORD").
+		StringVar(&c.Settings.Password)
+
+	c.SSH.register(cmd, &c.Opts)
+}
@@ -46,13 +46,22 @@ type execCommand struct {
 	Environ  map[string]string
 	Secrets  map[string]string
 	Settings compiler.Settings
+	CloneCA  string
+	Hosts    bool
+	HostFile string
+	Opts     engine.Opts
 	Endpoint string
 	Token    string
+	Proxy    string
 	Pretty   bool
 	Procs    int64
 	Debug    bool
 	Trace    bool
 	Dump     bool
+
+	// the vms are optionally reached through a bastion host
+	// or proxy.
+	SSH sshFlags
 }
 
 func (c *execCommand) run(*kingpin.ParseContext) error {
@@ -110,6 +119,33 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 		return err
 	}
 
+	// the certificate authority trusted by the clone step
+	// is sourced from a separate file.
+	if c.CloneCA != "" {
+		c.Settings.CloneCACert, err = ioutil.ReadFile(c.CloneCA)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the known hosts default to the known hosts of common
+	// git providers, unless a known hosts file is provided.
+	if c.HostFile != "" {
+		c.Opts.KnownHosts, err = ioutil.ReadFile(c.HostFile)
+		if err != nil {
+			return err
+		}
+	} else if c.Hosts {
+		c.Opts.KnownHosts = []byte(engine.DefaultKnownHosts)
+	}
+
+	// connections to the vms are tunneled through the
+	// bastion host, if provided, and the vm host keys are
+	// optionally verified.
+	if err := c.SSH.load(&c.Opts); err != nil {
+		return err
+	}
+
 	// compile the pipeline to an intermediate representation.
 	comp := &compiler.Compiler{
 		Environ:  provider.Static(c.Environ),
@@ -209,11 +245,16 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 		),
 	)
 
+	httpClient, err := orka.NewHTTPClient(c.Proxy, false)
+	if err != nil {
+		return err
+	}
 	orka := &orka.Client{
+		Client:   httpClient,
 		Endpoint: c.Endpoint,
 		Token:    c.Token,
 	}
-	engine, err := engine.New(orka)
+	engine, err := engine.New(orka, c.Opts)
 	if err != nil {
 		return err
 	}
@@ -225,6 +266,10 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 		c.Procs,
 	).Exec(ctx, spec, state)
 
+	// failed vms retained for inspection are deleted when
+	// retention expires, or when the command is interrupted.
+	engine.WaitRetained(ctx)
+
 	if c.Dump {
 		dump(state)
 	}
@@ -277,6 +322,9 @@ func registerExec(app *kingpin.Application) {
 	cmd.Flag("dump", "dump the pipeline state to stdout").
 		BoolVar(&c.Dump)
 
+	cmd.Flag("dry-run", "verify the vms can be provisioned, without running the steps").
+		BoolVar(&c.Opts.DryRun)
+
 	cmd.Flag("pretty", "pretty print the output").
 		Default(
 			fmt.Sprint(
@@ -303,10 +351,26 @@ func registerExec(app *kingpin.Application) {
 		Envar("DRONE_ORKA_TOKEN").
 		StringVar(&c.Token)
 
+	cmd.Flag("proxy", "orka api proxy url").
+		Envar("DRONE_ORKA_PROXY").
+		StringVar(&c.Proxy)
+
+	cmd.Flag("vm-metadata", "attach build metadata to the orka vm").
+		Envar("DRONE_ORKA_VM_METADATA").
+		BoolVar(&c.Opts.Metadata)
+
 	cmd.Flag("image", "orka base image").
 		Envar("DRONE_VM_IMAGE").
 		StringVar(&c.Settings.Image)
 
+	cmd.Flag("platform-image", "orka base image, by platform architecture").
+		Envar("DRONE_VM_PLATFORM_IMAGES").
+		StringMapVar(&c.Settings.PlatformImages)
+
+	cmd.Flag("platform-tag", "orka node tag, by platform architecture").
+		Envar("DRONE_VM_PLATFORM_TAGS").
+		StringMapVar(&c.Settings.PlatformTags)
+
 	cmd.Flag("username", "image ssh username").
 		Default("admin").
 		Envar("DRONE_VM_USERNAME").
@@ -317,6 +381,136 @@ func registerExec(app *kingpin.Application) {
 		Envar("DRONE_VM_PASSWORD").
 		StringVar(&c.Settings.Password)
 
+	cmd.Flag("ephemeral-key", "authenticate with a per-build ssh key").
+		Envar("DRONE_VM_EPHEMERAL_KEY").
+		BoolVar(&c.Settings.EphemeralKey)
+
+	cmd.Flag("rotate-password", "rotate the vm account password per build").
+		Envar("DRONE_VM_ROTATE_PASSWORD").
+		BoolVar(&c.Settings.RotatePassword)
+
+	cmd.Flag("disable-spotlight", "disable spotlight indexing on the vm").
+		Envar("DRONE_VM_DISABLE_SPOTLIGHT").
+		BoolVar(&c.Settings.DisableSpotlight)
+
+	cmd.Flag("disable-updates", "disable automatic software updates on the vm").
+		Envar("DRONE_VM_DISABLE_UPDATES").
+		BoolVar(&c.Settings.DisableUpdates)
+
+	cmd.Flag("disable-sleep", "disable the screen saver and sleep on the vm").
+		Envar("DRONE_VM_DISABLE_SLEEP").
+		BoolVar(&c.Settings.DisableSleep)
+
+	cmd.Flag("shell", "default shell (sh, bash or zsh)").
+		Default("sh").
+		Envar("DRONE_VM_SHELL").
+		EnumVar(&c.Settings.Shell, "sh", "bash", "zsh")
+
+	cmd.Flag("login-shell", "execute scripts with a login shell").
+		Envar("DRONE_VM_LOGIN_SHELL").
+		BoolVar(&c.Settings.LoginShell)
+
+	cmd.Flag("path", "directories prepended to the PATH").
+		Default("/opt/homebrew/bin", "/opt/homebrew/sbin", "/usr/local/bin").
+		Envar("DRONE_VM_PATH").
+		StringsVar(&c.Settings.Path)
+
+	cmd.Flag("locale", "default LANG and LC_ALL locale").
+		Default("en_US.UTF-8").
+		Envar("DRONE_VM_LOCALE").
+		StringVar(&c.Settings.Locale)
+
+	cmd.Flag("pipefail", "fail scripts when any command in a pipe fails").
+		Envar("DRONE_VM_PIPEFAIL").
+		BoolVar(&c.Settings.Pipefail)
+
+	cmd.Flag("nounset", "fail scripts when an unset variable is referenced").
+		Envar("DRONE_VM_NOUNSET").
+		BoolVar(&c.Settings.Nounset)
+
+	cmd.Flag("idle-timeout", "terminate steps that produce no output").
+		Envar("DRONE_VM_IDLE_TIMEOUT").
+		DurationVar(&c.Settings.IdleTimeout)
+
+	cmd.Flag("keep-failed", "retain the vms of failed pipelines for inspection").
+		Envar("DRONE_VM_KEEP_FAILED").
+		DurationVar(&c.Settings.KeepFailed)
+
+	cmd.Flag("timestamps", "prefix each line of output with a timestamp").
+		Envar("DRONE_LOGS_TIMESTAMPS").
+		EnumVar(&c.Settings.Timestamps, "elapsed", "clock")
+
+	cmd.Flag("system-logs", "print the recent system log when the pipeline fails").
+		Envar("DRONE_LOGS_SYSTEM").
+		DurationVar(&c.Settings.SystemLogs)
+
+	cmd.Flag("clone-mirror", "fetch the repository from a mirror, by host").
+		Envar("DRONE_CLONE_MIRRORS").
+		StringMapVar(&c.Settings.CloneMirrors)
+
+	cmd.Flag("clone-ca-cert", "certificate authority trusted by the clone step").
+		Envar("DRONE_CLONE_CA_CERT_FILE").
+		StringVar(&c.CloneCA)
+
+	cmd.Flag("known-hosts", "write the known hosts of common git providers").
+		Envar("DRONE_VM_KNOWN_HOSTS").
+		BoolVar(&c.Hosts)
+
+	cmd.Flag("known-hosts-file", "known hosts written to the vm").
+		Envar("DRONE_VM_KNOWN_HOSTS_FILE").
+		StringVar(&c.HostFile)
+
+	cmd.Flag("clone-skip-verify", "skip certificate verification in the clone step").
+		Envar("DRONE_CLONE_SKIP_VERIFY").
+		BoolVar(&c.Settings.CloneInsecure)
+
+	cmd.Flag("strict-secrets", "fail the pipeline if a secret is not found").
+		Envar("DRONE_SECRET_STRICT").
+		BoolVar(&c.Settings.StrictSecrets)
+
+	cmd.Flag("lazy-secrets", "resolve secrets just before the step runs").
+		Envar("DRONE_SECRET_LAZY").
+		BoolVar(&c.Settings.LazySecrets)
+
+	cmd.Flag("secret-events", "restrict secrets to pipe-separated build events, by name").
+		Envar("DRONE_SECRET_EVENTS").
+		StringMapVar(&c.Settings.SecretEvents)
+
+	cmd.Flag("env-reserved", "reserved environment variable prefixes").
+		Default("DRONE_", "CI_").
+		Envar("DRONE_ENV_RESERVED").
+		StringsVar(&c.Settings.Reserved)
+
+	cmd.Flag("netrc-public", "inject the netrc credentials for public repositories").
+		Envar("DRONE_NETRC_PUBLIC").
+		BoolVar(&c.Settings.NetrcPublic)
+
+	cmd.Flag("ssh-transfer", "file transfer method (sftp, shell)").
+		Envar("DRONE_SSH_TRANSFER").
+		StringVar(&c.Opts.Transfer)
+
+	c.SSH.register(cmd, &c.Opts)
+
+	cmd.Flag("sftp-max-packet", "sftp maximum packet size in bytes").
+		Envar("DRONE_SSH_SFTP_MAX_PACKET").
+		IntVar(&c.Opts.SFTPMaxPacket)
+
+	cmd.Flag("sftp-concurrency", "sftp maximum concurrent requests per file").
+		Envar("DRONE_SSH_SFTP_CONCURRENCY").
+		IntVar(&c.Opts.SFTPConcurrency)
+
+	cmd.Flag("ssh-compress", "compress uploaded files with gzip").
+		Envar("DRONE_SSH_COMPRESS").
+		BoolVar(&c.Opts.Compress)
+
+	cmd.Flag("strip-ansi", "strip ansi escape sequences from the output").
+		Envar("DRONE_LOGS_STRIP_ANSI").
+		BoolVar(&c.Opts.StripANSI)
+
+	cmd.Flag("persist-logs", "copy the step output to a log file on the vm").
+		Envar("DRONE_LOGS_PERSIST").
+		BoolVar(&c.Opts.PersistLogs)
+
 	// shared pipeline flags
 	c.Flags = internal.ParseFlags(cmd)
 }
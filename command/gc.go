@@ -0,0 +1,153 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/naming"
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type gcCommand struct {
+	Endpoint   string
+	Token      string
+	Proxy      string
+	Prefix     string
+	WarmPrefix string
+	TTL        time.Duration
+	WarmTTL    time.Duration
+	DryRun     bool
+}
+
+func (c *gcCommand) run(*kingpin.ParseContext) error {
+	httpClient, err := orka.NewHTTPClient(c.Proxy, false)
+	if err != nil {
+		return err
+	}
+	client := &orka.Client{
+		Client:   httpClient,
+		Endpoint: c.Endpoint,
+		Token:    c.Token,
+	}
+
+	res, err := client.List(nocontext)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tAGE\tACTION")
+
+	var failed int
+	now := time.Now()
+	for _, vm := range res.VirtualMachineResources {
+		prefix, ok := matchPrefix(vm.VirtualMachineName, c.WarmPrefix, c.Prefix)
+		if !ok {
+			continue
+		}
+		// warm vms are idle in the pool until claimed, and
+		// are purged after a separate, longer ttl.
+		ttl := c.TTL
+		if prefix == c.WarmPrefix {
+			ttl = c.WarmTTL
+		}
+		created, ok := vmCreated(prefix, vm)
+		age := "unknown"
+		if ok {
+			age = now.Sub(created).Round(time.Second).String()
+		}
+
+		action := "keep"
+		switch {
+		case !ok || now.Sub(created) < ttl:
+		case c.DryRun:
+			action = "purge (dry run)"
+		default:
+			action = "purged"
+			if _, err := client.Delete(nocontext, vm.VirtualMachineName); err != nil {
+				action = "error: " + err.Error()
+				failed++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			vm.VirtualMachineName,
+			vm.VMDeploymentStatus,
+			age,
+			action,
+		)
+	}
+	w.Flush()
+
+	if failed != 0 {
+		return fmt.Errorf("failed to purge %d vm(s)", failed)
+	}
+	return nil
+}
+
+// helper function returns the first prefix that matches the
+// virtual machine name. Empty prefixes are ignored.
+func matchPrefix(name string, prefixes ...string) (string, bool) {
+	for _, prefix := range prefixes {
+		if prefix != "" && naming.Match(prefix, name) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// helper function returns the virtual machine creation time,
+// derived from the virtual machine name, or from the deployment
+// creation timestamp if the name cannot be parsed.
+func vmCreated(prefix string, vm *orka.VirtualMachineResource) (time.Time, bool) {
+	if created, ok := naming.Created(prefix, vm.VirtualMachineName); ok {
+		return created, true
+	}
+	return vm.Created()
+}
+
+func registerGC(app *kingpin.Application) {
+	c := new(gcCommand)
+
+	cmd := app.Command("gc", "purges stale virtual machines").
+		Action(c.run)
+
+	cmd.Flag("ttl", "purge virtual machines older than the ttl").
+		Default("6h").
+		DurationVar(&c.TTL)
+
+	cmd.Flag("dry-run", "list virtual machines without purging").
+		BoolVar(&c.DryRun)
+
+	cmd.Flag("warm-ttl", "purge warm virtual machines older than the ttl").
+		Default("24h").
+		DurationVar(&c.WarmTTL)
+
+	cmd.Flag("prefix", "virtual machine name prefix").
+		Default(naming.DefaultPrefix).
+		StringVar(&c.Prefix)
+
+	cmd.Flag("warm-prefix", "warm virtual machine name prefix").
+		Default(naming.WarmPrefix).
+		StringVar(&c.WarmPrefix)
+
+	cmd.Flag("endpoint", "orka endpoint").
+		Default("http://10.221.188.100").
+		Envar("DRONE_ORKA_ENDPOINT").
+		StringVar(&c.Endpoint)
+
+	cmd.Flag("token", "orka token").
+		Envar("DRONE_ORKA_TOKEN").
+		StringVar(&c.Token)
+
+	cmd.Flag("proxy", "orka api proxy url").
+		Envar("DRONE_ORKA_PROXY").
+		StringVar(&c.Proxy)
+}
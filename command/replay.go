@@ -0,0 +1,217 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/command/internal"
+	"github.com/drone-runners/drone-runner-macstadium/engine"
+	"github.com/drone-runners/drone-runner-macstadium/internal/naming"
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/drone/runner-go/pipeline/runtime"
+	"github.com/drone/runner-go/pipeline/streamer/console"
+	"github.com/drone/signal"
+
+	"github.com/mattn/go-isatty"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// replayCommand executes a compiled pipeline specification,
+// for example, a redacted specification exported with the
+// compile command, to reproduce a problem without access
+// to the repository.
+type replayCommand struct {
+	*internal.Flags
+
+	Source   *os.File
+	Secrets  map[string]string
+	Password string
+	Opts     engine.Opts
+	Endpoint string
+	Token    string
+	Proxy    string
+	Pretty   bool
+	Procs    int64
+	Debug    bool
+	Trace    bool
+	Dump     bool
+}
+
+func (c *replayCommand) run(*kingpin.ParseContext) error {
+	spec := new(engine.Spec)
+	if err := json.NewDecoder(c.Source).Decode(spec); err != nil {
+		return err
+	}
+
+	// the vm names are replaced, since the vms provisioned
+	// for the original pipeline may still exist, and the
+	// redacted credentials and secrets are replaced with
+	// the values provided on the command line.
+	for _, s := range append([]*engine.Spec{spec}, spec.Groups...) {
+		s.Name = naming.New(naming.DefaultPrefix)
+		if s.Settings.Password == "" {
+			s.Settings.Password = c.Password
+		}
+		for _, step := range s.Steps {
+			for _, secret := range step.Secrets {
+				if v, ok := c.Secrets[secret.Name]; ok {
+					secret.Data = []byte(v)
+				}
+			}
+		}
+	}
+
+	// create a step object for each pipeline step.
+	for _, step := range spec.Steps {
+		if step.RunPolicy == runtime.RunNever {
+			continue
+		}
+		c.Stage.Steps = append(c.Stage.Steps, &drone.Step{
+			StageID:   c.Stage.ID,
+			Number:    len(c.Stage.Steps) + 1,
+			Name:      step.Name,
+			Status:    drone.StatusPending,
+			ErrIgnore: step.ErrPolicy == runtime.ErrIgnore,
+		})
+	}
+
+	// configures the pipeline timeout.
+	timeout := time.Duration(c.Repo.Timeout) * time.Minute
+	ctx, cancel := context.WithTimeout(nocontext, timeout)
+	defer cancel()
+
+	// listen for operating system signals and cancel execution
+	// when received.
+	ctx = signal.WithContextFunc(ctx, func() {
+		println("received signal, terminating process")
+		cancel()
+	})
+
+	state := &pipeline.State{
+		Build:  c.Build,
+		Stage:  c.Stage,
+		Repo:   c.Repo,
+		System: c.System,
+	}
+
+	// enable debug logging
+	logrus.SetLevel(logrus.WarnLevel)
+	if c.Debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	if c.Trace {
+		logrus.SetLevel(logrus.TraceLevel)
+	}
+	logger.Default = logger.Logrus(
+		logrus.NewEntry(
+			logrus.StandardLogger(),
+		),
+	)
+
+	httpClient, err := orka.NewHTTPClient(c.Proxy, false)
+	if err != nil {
+		return err
+	}
+	orka := &orka.Client{
+		Client:   httpClient,
+		Endpoint: c.Endpoint,
+		Token:    c.Token,
+	}
+	engine, err := engine.New(orka, c.Opts)
+	if err != nil {
+		return err
+	}
+
+	err = runtime.NewExecer(
+		pipeline.NopReporter(),
+		console.New(c.Pretty),
+		engine,
+		c.Procs,
+	).Exec(ctx, spec, state)
+
+	// failed vms retained for inspection are deleted when
+	// retention expires, or when the command is interrupted.
+	engine.WaitRetained(ctx)
+
+	if c.Dump {
+		dump(state)
+	}
+	if err != nil {
+		return err
+	}
+	switch state.Stage.Status {
+	case drone.StatusError, drone.StatusFailing, drone.StatusKilled:
+		os.Exit(1)
+	}
+	return nil
+}
+
+func registerReplay(app *kingpin.Application) {
+	c := new(replayCommand)
+	c.Secrets = map[string]string{}
+
+	cmd := app.Command("replay", "executes a compiled pipeline specification").
+		Action(c.run)
+
+	cmd.Arg("source", "compiled specification file location").
+		Required().
+		FileVar(&c.Source)
+
+	cmd.Flag("secrets", "secret parameters, replacing redacted secrets").
+		StringMapVar(&c.Secrets)
+
+	cmd.Flag("debug", "enable debug logging").
+		BoolVar(&c.Debug)
+
+	cmd.Flag("trace", "enable trace logging").
+		BoolVar(&c.Trace)
+
+	cmd.Flag("dump", "dump the pipeline state to stdout").
+		BoolVar(&c.Dump)
+
+	cmd.Flag("pretty", "pretty print the output").
+		Default(
+			fmt.Sprint(
+				isatty.IsTerminal(
+					os.Stdout.Fd(),
+				),
+			),
+		).BoolVar(&c.Pretty)
+
+	cmd.Flag("endpoint", "orka endpoint").
+		Default("http://10.221.188.100").
+		Envar("DRONE_ORKA_ENDPOINT").
+		StringVar(&c.Endpoint)
+
+	cmd.Flag("token", "orka token").
+		Envar("DRONE_ORKA_TOKEN").
+		StringVar(&c.Token)
+
+	cmd.Flag("proxy", "orka api proxy url").
+		Envar("DRONE_ORKA_PROXY").
+		StringVar(&c.Proxy)
+
+	cmd.Flag("password", "image ssh password, replacing the redacted password").
+		Default("admin").
+		Envar("DRONE_VM_PASSWORD").
+		StringVar(&c.Password)
+
+	cmd.Flag("ssh-transfer", "file transfer method (sftp, shell)").
+		Envar("DRONE_SSH_TRANSFER").
+		StringVar(&c.Opts.Transfer)
+
+	// shared pipeline flags
+	c.Flags = internal.ParseFlags(cmd)
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/drone-runners/drone-runner-macstadium/engine"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// sshFlags configures how the vms are reached, shared by
+// the commands that connect to vms.
+type sshFlags struct {
+	// the vms are optionally reached through a bastion host.
+	Bastion         engine.Bastion
+	BastionKeyFile  string
+	BastionHostKey  string
+	BastionInsecure bool
+
+	// the vm host keys are optionally verified.
+	HostKeyFile string
+}
+
+// helper function registers the ssh connection flags.
+func (f *sshFlags) register(cmd *kingpin.CmdClause, opts *engine.Opts) {
+	cmd.Flag("ssh-ciphers", "ssh ciphers").
+		Envar("DRONE_SSH_CIPHERS").
+		StringsVar(&opts.Ciphers)
+
+	cmd.Flag("ssh-macs", "ssh message authentication codes").
+		Envar("DRONE_SSH_MACS").
+		StringsVar(&opts.MACs)
+
+	cmd.Flag("ssh-key-exchanges", "ssh key exchange algorithms").
+		Envar("DRONE_SSH_KEY_EXCHANGES").
+		StringsVar(&opts.KeyExchanges)
+
+	cmd.Flag("ssh-address", "ssh address template, for vms behind a nat appliance").
+		Envar("DRONE_SSH_ADDRESS").
+		StringVar(&opts.Address)
+
+	cmd.Flag("ssh-bastion", "ssh bastion host address").
+		Envar("DRONE_SSH_BASTION_ADDRESS").
+		StringVar(&f.Bastion.Address)
+
+	cmd.Flag("ssh-bastion-username", "ssh bastion host username").
+		Envar("DRONE_SSH_BASTION_USERNAME").
+		StringVar(&f.Bastion.Username)
+
+	cmd.Flag("ssh-bastion-password", "ssh bastion host password").
+		Envar("DRONE_SSH_BASTION_PASSWORD").
+		StringVar(&f.Bastion.Password)
+
+	cmd.Flag("ssh-bastion-key-file", "ssh bastion host private key file").
+		Envar("DRONE_SSH_BASTION_KEY_FILE").
+		StringVar(&f.BastionKeyFile)
+
+	cmd.Flag("ssh-bastion-host-key", "ssh bastion host public key, in authorized_keys format").
+		Envar("DRONE_SSH_BASTION_HOST_KEY").
+		StringVar(&f.BastionHostKey)
+
+	cmd.Flag("ssh-bastion-insecure", "connect to the bastion host without verifying the host key").
+		Envar("DRONE_SSH_BASTION_INSECURE").
+		BoolVar(&f.BastionInsecure)
+
+	cmd.Flag("ssh-proxy", "socks5 proxy url for ssh connections").
+		Envar("DRONE_SSH_PROXY").
+		StringVar(&opts.Proxy)
+
+	cmd.Flag("ssh-host-key-file", "vm host keys file, in authorized_keys format").
+		Envar("DRONE_SSH_HOST_KEY_FILE").
+		StringVar(&f.HostKeyFile)
+}
+
+// helper function loads the bastion host and the vm host
+// keys into the engine options.
+func (f *sshFlags) load(opts *engine.Opts) error {
+	// connections to the vms are tunneled through the
+	// bastion host, if provided.
+	if f.Bastion.Address != "" {
+		if f.BastionKeyFile != "" {
+			raw, err := ioutil.ReadFile(f.BastionKeyFile)
+			if err != nil {
+				return err
+			}
+			f.Bastion.Signer, err = ssh.ParsePrivateKey(raw)
+			if err != nil {
+				return err
+			}
+		}
+		switch {
+		case f.BastionHostKey != "":
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(f.BastionHostKey))
+			if err != nil {
+				return fmt.Errorf("invalid bastion host key: %s", err)
+			}
+			f.Bastion.HostKey = key
+		case f.BastionInsecure:
+			fmt.Fprintln(os.Stderr, "WARNING: the bastion host key is not verified")
+		default:
+			return errors.New("bastion host key is required, use --ssh-bastion-insecure to skip verification")
+		}
+		opts.Bastion = &f.Bastion
+	}
+
+	// the vm host keys are verified against the host keys
+	// of the base images, if provided.
+	if f.HostKeyFile != "" {
+		raw, err := ioutil.ReadFile(f.HostKeyFile)
+		if err != nil {
+			return err
+		}
+		opts.HostKeys, err = engine.ParseHostKeys(raw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
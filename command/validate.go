@@ -0,0 +1,168 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/drone-runners/drone-runner-macstadium/engine/linter"
+	"github.com/drone-runners/drone-runner-macstadium/engine/resource"
+
+	"github.com/buildkite/yaml"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// regular expression to extract the line number from yaml
+// parsing errors.
+var lineRE = regexp.MustCompile(`line (\d+): `)
+
+type validateCommand struct {
+	Source  *os.File
+	Trusted bool
+	Strict  bool
+}
+
+func (c *validateCommand) run(*kingpin.ParseContext) error {
+	raw, err := ioutil.ReadAll(c.Source)
+	if err != nil {
+		return err
+	}
+
+	var errors, warnings int
+	report := func(level string, line int, msg string) {
+		fmt.Fprintf(os.Stdout, "%s:%d: %s: %s\n", c.Source.Name(), line, level, msg)
+	}
+
+	for _, doc := range splitDocuments(raw) {
+		// parse the raw document to determine the resource
+		// kind and type, and to capture syntax errors.
+		resources, err := manifest.ParseRawBytes(doc.data)
+		if err != nil {
+			errors++
+			report("error", doc.lineOf(err), err.Error())
+			continue
+		}
+		if len(resources) == 0 {
+			continue
+		}
+		rawres := resources[0]
+		if rawres.Kind != resource.Kind {
+			continue
+		}
+		switch rawres.Type {
+		case resource.Type, resource.TypeVM, resource.TypeExec, "":
+		default:
+			continue
+		}
+
+		// decode the pipeline in strict mode to detect unknown
+		// or misspelled fields, which are otherwise ignored.
+		err = yaml.UnmarshalStrict(doc.data, new(resource.Pipeline))
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, msg := range typeErr.Errors {
+				warnings++
+				report("warning", doc.lineOfMsg(msg), lineRE.ReplaceAllString(msg, ""))
+			}
+		}
+
+		// parse and lint the pipeline resource.
+		parsed, err := manifest.ParseBytes(doc.data)
+		if err != nil {
+			errors++
+			report("error", doc.lineOf(err), err.Error())
+			continue
+		}
+		pipeline := parsed.Resources[0].(*resource.Pipeline)
+		err = linter.New().Lint(pipeline, &drone.Repo{Trusted: c.Trusted})
+		if err != nil {
+			errors++
+			report("error", doc.start, err.Error())
+		}
+	}
+
+	if errors != 0 || (c.Strict && warnings != 0) {
+		return fmt.Errorf("%d error(s), %d warning(s)", errors, warnings)
+	}
+	fmt.Fprintf(os.Stdout, "%s: ok\n", c.Source.Name())
+	return nil
+}
+
+// document represents a single document in a multi-document
+// yaml file, and the line number at which it starts.
+type document struct {
+	start int
+	data  []byte
+}
+
+// lineOf returns the absolute line number of the error.
+func (d *document) lineOf(err error) int {
+	return d.lineOfMsg(err.Error())
+}
+
+// lineOfMsg returns the absolute line number referenced in
+// the message, or the document start line.
+func (d *document) lineOfMsg(msg string) int {
+	match := lineRE.FindStringSubmatch(msg)
+	if len(match) != 2 {
+		return d.start
+	}
+	line, _ := strconv.Atoi(match[1])
+	return d.start + line - 1
+}
+
+// helper function splits the multi-document yaml file into
+// individual documents, using the same separator rules as
+// the manifest parser. The file is split in memory, since
+// it is already buffered, so that lines are not limited in
+// length.
+func splitDocuments(b []byte) []*document {
+	var docs []*document
+	var doc *document
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	if len(b) == 0 {
+		return nil
+	}
+	for i, line := range bytes.Split(b, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if bytes.HasPrefix(line, []byte("---")) {
+			doc = nil
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("...")) {
+			break
+		}
+		if doc == nil {
+			doc = &document{start: i + 1}
+			docs = append(docs, doc)
+		}
+		doc.data = append(doc.data, line...)
+		doc.data = append(doc.data, '\n')
+	}
+	return docs
+}
+
+func registerValidate(app *kingpin.Application) {
+	c := new(validateCommand)
+
+	cmd := app.Command("validate", "validates the yaml file").
+		Action(c.run)
+
+	cmd.Arg("source", "source file location").
+		Default(".drone.yml").
+		FileVar(&c.Source)
+
+	cmd.Flag("trusted", "validate as a trusted repository").
+		BoolVar(&c.Trusted)
+
+	cmd.Flag("strict", "treat warnings as errors").
+		BoolVar(&c.Strict)
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSplitDocuments(t *testing.T) {
+	raw := "---\nkind: pipeline\nname: build\n\n---\nkind: secret\nname: token\n...\nkind: ignored\n"
+	docs := splitDocuments([]byte(raw))
+	if got, want := len(docs), 2; got != want {
+		t.Fatalf("Want %d documents, got %d", want, got)
+	}
+	if got, want := docs[0].start, 2; got != want {
+		t.Errorf("Want first document at line %d, got %d", want, got)
+	}
+	if got, want := string(docs[0].data), "kind: pipeline\nname: build\n\n"; got != want {
+		t.Errorf("Want first document %q, got %q", want, got)
+	}
+	if got, want := docs[1].start, 6; got != want {
+		t.Errorf("Want second document at line %d, got %d", want, got)
+	}
+	if got, want := string(docs[1].data), "kind: secret\nname: token\n"; got != want {
+		t.Errorf("Want second document %q, got %q", want, got)
+	}
+}
+
+func TestSplitDocuments_LongLine(t *testing.T) {
+	// lines are not limited to the default scanner buffer
+	// size of 64KiB.
+	long := strings.Repeat("a", 1<<20)
+	raw := "kind: pipeline\nname: " + long + "\n"
+	docs := splitDocuments([]byte(raw))
+	if got, want := len(docs), 1; got != want {
+		t.Fatalf("Want %d documents, got %d", want, got)
+	}
+	if got, want := len(docs[0].data), len(raw); got != want {
+		t.Errorf("Want document of %d bytes, got %d", want, got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		yaml    string
+		invalid bool
+	}{
+		{
+			yaml:    "kind: pipeline\ntype: macstadium\nname: default\n\nsteps:\n- name: build\n  commands:\n  - go build\n",
+			invalid: false,
+		},
+		{
+			yaml:    "kind: pipeline\ntype: macstadium\nname: default\n\nsteps:\n- name: build\n  commands:\n  - go build\n\n---\nkind: pipeline\ntype: macstadium\nname: test\n\nsteps:\n- name: test\n  commands:\n  - go test\n",
+			invalid: false,
+		},
+		{
+			// the second document fails to lint, since the
+			// step names are not unique.
+			yaml:    "kind: pipeline\ntype: macstadium\nname: default\n\nsteps:\n- name: build\n  commands:\n  - go build\n\n---\nkind: pipeline\ntype: macstadium\nname: test\n\nsteps:\n- name: test\n  commands:\n  - go test\n- name: test\n  commands:\n  - go vet\n",
+			invalid: true,
+		},
+		{
+			yaml:    "kind: pipeline\ntype: macstadium\nname: default\n\nsteps:\n- name: build\n  commands:\n  - echo " + strings.Repeat("a", 1<<17) + "\n",
+			invalid: false,
+		},
+	}
+	for i, test := range tests {
+		f, err := ioutil.TempFile("", "drone-yml-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(test.yaml)
+		f.Seek(0, 0)
+
+		c := &validateCommand{Source: f}
+		err = c.run(nil)
+		f.Close()
+		if test.invalid && err == nil {
+			t.Errorf("Expect lint error for test %d", i)
+		}
+		if !test.invalid && err != nil {
+			t.Errorf("Expect no lint error for test %d, got %s", i, err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/engine"
+	"github.com/drone-runners/drone-runner-macstadium/internal/naming"
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type vmCommand struct {
+	Endpoint   string
+	Token      string
+	Proxy      string
+	Prefix     string
+	WarmPrefix string
+	Name       string
+	Force      bool
+}
+
+func (c *vmCommand) client() (*orka.Client, error) {
+	httpClient, err := orka.NewHTTPClient(c.Proxy, false)
+	if err != nil {
+		return nil, err
+	}
+	return &orka.Client{
+		Client:   httpClient,
+		Endpoint: c.Endpoint,
+		Token:    c.Token,
+	}, nil
+}
+
+func (c *vmCommand) list(*kingpin.ParseContext) error {
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	res, err := client.List(nocontext)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tNODE\tIP\tBUILD\tAGE")
+
+	now := time.Now()
+	for _, vm := range res.VirtualMachineResources {
+		prefix, ok := matchPrefix(vm.VirtualMachineName, c.WarmPrefix, c.Prefix)
+		if !ok {
+			continue
+		}
+		node, ip, build := "-", "-", "-"
+		for _, status := range vm.Status {
+			node = status.NodeLocation
+			ip = net.JoinHostPort(status.VirtualMachineIP, status.SSHPort)
+			build = vmBuild(status.Metadata)
+		}
+		age := "-"
+		if created, ok := vmCreated(prefix, vm); ok {
+			age = now.Sub(created).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			vm.VirtualMachineName,
+			vm.VMDeploymentStatus,
+			node,
+			ip,
+			build,
+			age,
+		)
+	}
+	return w.Flush()
+}
+
+// helper function returns the repository and build number
+// attached to the vm as orka vm metadata, in repo#build
+// format, or a dash if the vm has no build metadata.
+func vmBuild(metadata *orka.Metadata) string {
+	repo := metadata.Value(engine.MetadataRepo)
+	build := metadata.Value(engine.MetadataBuild)
+	switch {
+	case repo != "" && build != "":
+		return repo + "#" + build
+	case repo != "":
+		return repo
+	default:
+		return "-"
+	}
+}
+
+func (c *vmCommand) remove(*kingpin.ParseContext) error {
+	// prevent accidental removal of virtual machines that
+	// were not created by the runner.
+	if _, ok := matchPrefix(c.Name, c.WarmPrefix, c.Prefix); !c.Force && !ok {
+		return fmt.Errorf("vm %s does not match prefix %q, use --force to remove", c.Name, c.Prefix)
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	_, err = client.Delete(nocontext, c.Name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "purged %s\n", c.Name)
+	return nil
+}
+
+func registerVM(app *kingpin.Application) {
+	c := new(vmCommand)
+
+	cmd := app.Command("vm", "manage virtual machines")
+
+	cmd.Flag("prefix", "virtual machine name prefix").
+		Default(naming.DefaultPrefix).
+		StringVar(&c.Prefix)
+
+	cmd.Flag("warm-prefix", "warm virtual machine name prefix").
+		Default(naming.WarmPrefix).
+		StringVar(&c.WarmPrefix)
+
+	cmd.Flag("endpoint", "orka endpoint").
+		Default("http://10.221.188.100").
+		Envar("DRONE_ORKA_ENDPOINT").
+		StringVar(&c.Endpoint)
+
+	cmd.Flag("token", "orka token").
+		Envar("DRONE_ORKA_TOKEN").
+		StringVar(&c.Token)
+
+	cmd.Flag("proxy", "orka api proxy url").
+		Envar("DRONE_ORKA_PROXY").
+		StringVar(&c.Proxy)
+
+	cmd.Command("ls", "list virtual machines").
+		Action(c.list)
+
+	rm := cmd.Command("rm", "purge a virtual machine").
+		Action(c.remove)
+
+	rm.Arg("name", "virtual machine name").
+		Required().
+		StringVar(&c.Name)
+
+	rm.Flag("force", "purge a virtual machine that does not match the prefix").
+		BoolVar(&c.Force)
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// addressData provides the vm address reported by orka to
+// the ssh address template.
+type addressData struct {
+	IP   string // vm ip address
+	Port int    // vm ssh port
+	Name string // vm name
+}
+
+// addressFuncs provides arithmetic helpers to the ssh
+// address template, to map the reported ssh port to the
+// port exposed by a nat appliance.
+var addressFuncs = template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+	"sub": func(a, b int) int { return a - b },
+}
+
+// helper function parses the ssh address template. A nil
+// template is returned if the text is empty.
+func parseAddress(text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	t, err := template.New("address").
+		Funcs(addressFuncs).
+		Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh address template: %s", err)
+	}
+	return t, nil
+}
+
+// helper function rewrites the vm address with the ssh
+// address template, for vms that are reachable through a
+// nat appliance, where the address differs from the address
+// reported by orka. The address is returned unchanged if
+// the template is nil.
+func rewriteAddress(t *template.Template, name, addr string) (string, error) {
+	if t == nil {
+		return addr, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	n, _ := strconv.Atoi(port)
+
+	buf := new(bytes.Buffer)
+	err = t.Execute(buf, &addressData{
+		IP:   host,
+		Port: n,
+		Name: name,
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot rewrite the ssh address: %s", err)
+	}
+
+	// the rewritten host may be a hostname, for example,
+	// the hostname of the nat appliance.
+	out := strings.TrimSpace(buf.String())
+	host, port, err = net.SplitHostPort(out)
+	if err != nil {
+		return "", fmt.Errorf("invalid rewritten ssh address: %q", out)
+	}
+	if host == "" {
+		return "", fmt.Errorf("invalid rewritten ssh address: %q", out)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid rewritten ssh port: %q", port)
+	}
+	return net.JoinHostPort(normalizeHost(host), port), nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestRewriteAddress(t *testing.T) {
+	tests := []struct {
+		text, addr, want string
+	}{
+		{"", "10.0.0.1:8822", "10.0.0.1:8822"},
+		{"{{ .IP }}:22", "10.0.0.1:8822", "10.0.0.1:22"},
+		{"{{ .IP }}:{{ add .Port 10000 }}", "10.0.0.1:8822", "10.0.0.1:18822"},
+		{"nat.company.com:{{ sub .Port 8000 }}", "10.0.0.1:8822", "nat.company.com:822"},
+		{"[{{ .IP }}]:{{ .Port }}", "[fd00::1]:8822", "[fd00::1]:8822"},
+		{"{{ .Name }}.vms.company.com:{{ .Port }}", "10.0.0.1:8822", "drone-1.vms.company.com:8822"},
+	}
+	for _, test := range tests {
+		tmpl, err := parseAddress(test.text)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		got, err := rewriteAddress(tmpl, "drone-1", test.addr)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Want address %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestRewriteAddress_Invalid(t *testing.T) {
+	if _, err := parseAddress("{{ .IP "); err == nil {
+		t.Errorf("Expect error parsing an invalid template")
+	}
+	for _, text := range []string{
+		"{{ .IP }}",
+		":{{ .Port }}",
+		"{{ .IP }}:{{ add .Port 60000 }}",
+		"{{ .Node }}:22",
+	} {
+		tmpl, err := parseAddress(text)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if _, err := rewriteAddress(tmpl, "drone-1", "10.0.0.1:8822"); err == nil {
+			t.Errorf("Expect error rewriting the address with template %q", text)
+		}
+	}
+}
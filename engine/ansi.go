@@ -0,0 +1,90 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"io"
+	"sync"
+)
+
+// ansi parser states.
+const (
+	ansiText = iota
+	ansiEscape
+	ansiCSI
+	ansiOSC
+	ansiOSCEscape
+)
+
+// ansiWriter strips ansi escape sequences, such as colors and
+// cursor movement, from the output written to the underlying
+// writer. Escape sequences may span multiple writes. The
+// writer is safe for concurrent use, since the step stdout
+// and stderr are written concurrently.
+type ansiWriter struct {
+	w io.Writer
+
+	mu    sync.Mutex
+	state int
+}
+
+// newANSIWriter returns a new ansi stripping writer.
+func newANSIWriter(w io.Writer) *ansiWriter {
+	return &ansiWriter{w: w}
+}
+
+// Write writes to the underlying writer with the escape
+// sequences removed.
+func (w *ansiWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch w.state {
+		case ansiText:
+			if b == 0x1b {
+				w.state = ansiEscape
+			} else {
+				out = append(out, b)
+			}
+		case ansiEscape:
+			switch b {
+			case '[':
+				w.state = ansiCSI
+			case ']':
+				w.state = ansiOSC
+			default:
+				// intermediate bytes, such as the character set
+				// designation, precede the final byte.
+				if b < 0x20 || b > 0x2f {
+					w.state = ansiText
+				}
+			}
+		case ansiCSI:
+			// control sequences are terminated by a byte in
+			// the range 0x40 through 0x7e.
+			if b >= 0x40 && b <= 0x7e {
+				w.state = ansiText
+			}
+		case ansiOSC:
+			// operating system commands are terminated by a
+			// bell character or the string terminator.
+			switch b {
+			case 0x07:
+				w.state = ansiText
+			case 0x1b:
+				w.state = ansiOSCEscape
+			}
+		case ansiOSCEscape:
+			w.state = ansiText
+		}
+	}
+	if len(out) != 0 {
+		if _, err := w.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestANSIWriter(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{
+			in:   []string{"\x1b[1;32m** BUILD SUCCEEDED **\x1b[0m\n"},
+			want: "** BUILD SUCCEEDED **\n",
+		},
+		{
+			in:   []string{"\x1b]0;fastlane\x07[15:30:00]: lane\n"},
+			want: "[15:30:00]: lane\n",
+		},
+		{
+			in:   []string{"\x1b]8;;https://drone.io\x1b\\link\x1b]8;;\x1b\\\n"},
+			want: "link\n",
+		},
+		{
+			in:   []string{"progress\x1b", "[2K", "\x1b[1", "G done\n"},
+			want: "progress done\n",
+		},
+		{
+			in:   []string{"\x1b(Bplain text\n"},
+			want: "plain text\n",
+		},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		w := newANSIWriter(buf)
+		for _, s := range test.in {
+			n, err := w.Write([]byte(s))
+			if err != nil {
+				t.Error(err)
+			}
+			if n != len(s) {
+				t.Errorf("Want %d bytes written, got %d", len(s), n)
+			}
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("Want output %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestANSIWriter_Concurrent(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := newANSIWriter(buf)
+
+	// stdout and stderr are written concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				w.Write([]byte("\x1b[1;32mhello\x1b[0m\n"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := strings.Repeat("hello\n", 200)
+	if got := buf.String(); got != want {
+		t.Errorf("Want escape sequences removed from each line")
+	}
+}
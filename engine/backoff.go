@@ -0,0 +1,69 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"math/rand"
+	"time"
+)
+
+// default retry schedule used when the virtual machine
+// cannot be deployed due to insufficient capacity.
+const (
+	defaultRetryMin     = time.Second * 15
+	defaultRetryMax     = time.Minute * 5
+	defaultRetryTimeout = time.Hour
+)
+
+// Backoff configures the retry schedule used when the
+// virtual machine cannot be deployed due to insufficient
+// cluster capacity. The delay doubles with each attempt,
+// starting at Min and capped at Max, and is randomized so
+// that pipelines waiting for capacity do not retry in
+// lockstep. Zero values are replaced with the defaults.
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	Timeout time.Duration
+}
+
+// timeout returns the maximum duration to retry.
+func (b Backoff) timeout() time.Duration {
+	if b.Timeout <= 0 {
+		return defaultRetryTimeout
+	}
+	return b.Timeout
+}
+
+// delay returns the randomized delay before the given retry
+// attempt, starting at zero. The delay is between half and
+// the full exponential delay.
+func (b Backoff) delay(attempt int) time.Duration {
+	d := b.exp(attempt)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// exp returns the exponential delay, without jitter, before
+// the given retry attempt.
+func (b Backoff) exp(attempt int) time.Duration {
+	min, max := b.Min, b.Max
+	if min <= 0 {
+		min = defaultRetryMin
+	}
+	if max <= 0 {
+		max = defaultRetryMax
+	}
+	if max < min {
+		max = min
+	}
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
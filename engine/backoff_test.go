@@ -0,0 +1,49 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Min: time.Second, Max: time.Second * 10}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second * 2},
+		{2, time.Second * 4},
+		{3, time.Second * 8},
+		{4, time.Second * 10},
+		{100, time.Second * 10},
+	}
+	for _, test := range tests {
+		if got := b.exp(test.attempt); got != test.want {
+			t.Errorf("Want delay %s for attempt %d, got %s", test.want, test.attempt, got)
+		}
+		for i := 0; i < 10; i++ {
+			got := b.delay(test.attempt)
+			if got < test.want/2 || got > test.want {
+				t.Errorf("Want jittered delay between %s and %s, got %s", test.want/2, test.want, got)
+			}
+		}
+	}
+}
+
+func TestBackoff_Defaults(t *testing.T) {
+	b := Backoff{}
+	if got, want := b.exp(0), defaultRetryMin; got != want {
+		t.Errorf("Want default min %s, got %s", want, got)
+	}
+	if got, want := b.exp(100), defaultRetryMax; got != want {
+		t.Errorf("Want default max %s, got %s", want, got)
+	}
+	if got, want := b.timeout(), defaultRetryTimeout; got != want {
+		t.Errorf("Want default timeout %s, got %s", want, got)
+	}
+}
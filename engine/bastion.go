@@ -0,0 +1,126 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Bastion configures an ssh bastion host, used to connect
+// to virtual machines on a network that is not routable
+// from the runner. Connections to the virtual machine are
+// tunneled through the bastion host, equivalent to the ssh
+// ProxyJump option.
+type Bastion struct {
+	// Address is the bastion host address. If the address
+	// does not include a port, port 22 is used.
+	Address string
+
+	// Username, Password and Signer authenticate with the
+	// bastion host. The key is preferred to the password
+	// if both are provided.
+	Username string
+	Password string
+	Signer   ssh.Signer
+
+	// HostKey optionally verifies the bastion host key. If
+	// nil, the host key is not verified.
+	HostKey ssh.PublicKey
+}
+
+// helper function returns the bastion host address,
+// including the port.
+func (b *Bastion) address() string {
+	if _, _, err := net.SplitHostPort(b.Address); err == nil {
+		return b.Address
+	}
+	return net.JoinHostPort(normalizeHost(b.Address), "22")
+}
+
+// helper function returns the ssh client configuration of
+// the bastion host.
+func (b *Bastion) config() *ssh.ClientConfig {
+	var auth []ssh.AuthMethod
+	if b.Signer != nil {
+		auth = append(auth, ssh.PublicKeys(b.Signer))
+	}
+	if b.Password != "" {
+		auth = append(auth, ssh.Password(b.Password))
+	}
+	config := &ssh.ClientConfig{
+		User:            b.Username,
+		Auth:            auth,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         dialTimeout,
+	}
+	if b.HostKey != nil {
+		config.HostKeyCallback = ssh.FixedHostKey(b.HostKey)
+	}
+	return config
+}
+
+// helper function connects to the bastion host with the
+// dial function and returns a connection to the address
+// tunneled through the bastion host. Closing the connection
+// closes the connection to the bastion host.
+//
+// The bastion connection and the tunnel are bounded by the
+// dial timeout, and are interrupted when the context is
+// canceled.
+func (b *Bastion) dial(ctx context.Context, dial dialFunc, addr string) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", b.address())
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the bastion host: %s", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	stop := closeOnCancel(ctx, conn)
+	defer stop()
+	c, chans, reqs, err := ssh.NewClientConn(conn, b.address(), b.config())
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot connect to the bastion host: %s", contextErr(ctx, err))
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	tunnel, err := client.Dial("tcp", addr)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("cannot tunnel through the bastion host: %s", contextErr(ctx, err))
+	}
+	conn.SetDeadline(time.Time{})
+	return &bastionConn{Conn: tunnel, client: client, conn: conn}, nil
+}
+
+// bastionConn is a connection tunneled through the bastion
+// host. The tunnel does not support deadlines, which are set
+// on the connection to the bastion host instead.
+type bastionConn struct {
+	net.Conn
+	client *ssh.Client
+	conn   net.Conn
+}
+
+func (c *bastionConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+	return err
+}
+
+func (c *bastionConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *bastionConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *bastionConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
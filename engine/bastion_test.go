@@ -0,0 +1,172 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestBastionAddress(t *testing.T) {
+	tests := []struct {
+		addr, want string
+	}{
+		{"bastion.company.com", "bastion.company.com:22"},
+		{"bastion.company.com:2222", "bastion.company.com:2222"},
+		{"10.0.0.1", "10.0.0.1:22"},
+		{"fd00::1", "[fd00::1]:22"},
+		{"[fd00::1]:2222", "[fd00::1]:2222"},
+	}
+	for _, test := range tests {
+		b := &Bastion{Address: test.addr}
+		if got := b.address(); got != test.want {
+			t.Errorf("Want bastion address %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestBastionDial(t *testing.T) {
+	// the target echoes the first line it receives.
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, line)
+	}()
+
+	hostKey, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveBastion(t, hostKey, "bastion", "password")
+
+	e := new(Engine)
+	b := &Bastion{
+		Address:  addr,
+		Username: "bastion",
+		Password: "password",
+		HostKey:  hostKey.PublicKey(),
+	}
+	conn, err := b.dial(noContext, e.dialTCP, target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(time.Second * 5)); err != nil {
+		t.Error(err)
+	}
+	io.WriteString(conn, "hello\n")
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "hello\n" {
+		t.Errorf("Want tunneled response %q, got %q", "hello\n", line)
+	}
+}
+
+func TestBastionDial_HostKeyMismatch(t *testing.T) {
+	hostKey, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveBastion(t, hostKey, "bastion", "password")
+
+	e := new(Engine)
+	b := &Bastion{
+		Address:  addr,
+		Username: "bastion",
+		Password: "password",
+		HostKey:  otherKey.PublicKey(),
+	}
+	if _, err := b.dial(noContext, e.dialTCP, "127.0.0.1:22"); err == nil {
+		t.Errorf("Expect error when the bastion host key does not match")
+	}
+}
+
+// helper function starts an ssh server that accepts a
+// single connection authenticated with the password, and
+// forwards direct-tcpip channels, and returns the address.
+func serveBastion(t *testing.T, hostKey ssh.Signer, username, password string) string {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == username && string(pass) == password {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for ch := range chans {
+			if ch.ChannelType() != "direct-tcpip" {
+				ch.Reject(ssh.UnknownChannelType, "unsupported channel type")
+				continue
+			}
+			var payload struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			ssh.Unmarshal(ch.ExtraData(), &payload)
+			target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port)))
+			if err != nil {
+				ch.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, creqs, err := ch.Accept()
+			if err != nil {
+				target.Close()
+				continue
+			}
+			go ssh.DiscardRequests(creqs)
+			go func() {
+				io.Copy(target, channel)
+				target.Close()
+			}()
+			go func() {
+				io.Copy(channel, target)
+				channel.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
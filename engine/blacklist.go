@@ -0,0 +1,87 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// number of times a node was blacklisted, published to the
+// expvar endpoint.
+var blacklisted = new(expvar.Int)
+
+// blacklist tracks nodes where deployed virtual machines
+// repeatedly fail to become reachable over ssh, and excludes
+// these nodes from node selection for a period of time. A
+// zero threshold disables the blacklist.
+type blacklist struct {
+	sync.Mutex
+	threshold int
+	duration  time.Duration
+
+	// failures counts consecutive failures by node ip.
+	failures map[string]int
+	// until holds the blacklist expiry by node ip.
+	until map[string]time.Time
+}
+
+// failure records a failure to reach a virtual machine
+// deployed to the node, and returns true if the node is
+// blacklisted as a result.
+func (b *blacklist) failure(node string, now time.Time) bool {
+	if b.threshold <= 0 || node == "" {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.failures == nil {
+		b.failures = map[string]int{}
+		b.until = map[string]time.Time{}
+	}
+	b.failures[node]++
+	if b.failures[node] < b.threshold {
+		return false
+	}
+	delete(b.failures, node)
+	b.until[node] = now.Add(b.duration)
+	blacklisted.Add(1)
+	return true
+}
+
+// success resets the consecutive failures of the node.
+func (b *blacklist) success(node string) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.Lock()
+	delete(b.failures, node)
+	b.Unlock()
+}
+
+// blocked returns true if the node is blacklisted.
+func (b *blacklist) blocked(node string, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	until, ok := b.until[node]
+	if ok && !now.Before(until) {
+		delete(b.until, node)
+		return false
+	}
+	return ok
+}
+
+// active returns true if one or more nodes are blacklisted.
+func (b *blacklist) active(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	for node, until := range b.until {
+		if !now.Before(until) {
+			delete(b.until, node)
+		}
+	}
+	return len(b.until) != 0
+}
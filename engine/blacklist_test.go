@@ -0,0 +1,56 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlacklist(t *testing.T) {
+	now := time.Now()
+	b := blacklist{threshold: 2, duration: time.Hour}
+
+	if b.failure("10.0.0.1", now) {
+		t.Errorf("Expect node not blacklisted after the first failure")
+	}
+	b.success("10.0.0.1")
+	if b.failure("10.0.0.1", now) {
+		t.Errorf("Expect success to reset consecutive failures")
+	}
+	if !b.failure("10.0.0.1", now) {
+		t.Errorf("Expect node blacklisted after consecutive failures")
+	}
+	if !b.active(now) {
+		t.Errorf("Expect blacklist active")
+	}
+	if !b.blocked("10.0.0.1", now) {
+		t.Errorf("Expect node blocked")
+	}
+	if b.blocked("10.0.0.2", now) {
+		t.Errorf("Expect other nodes not blocked")
+	}
+
+	later := now.Add(time.Hour)
+	if b.blocked("10.0.0.1", later) {
+		t.Errorf("Expect node not blocked after the blacklist expires")
+	}
+	if b.active(later) {
+		t.Errorf("Expect blacklist inactive after the blacklist expires")
+	}
+}
+
+func TestBlacklist_Disabled(t *testing.T) {
+	now := time.Now()
+	b := blacklist{}
+	for i := 0; i < 10; i++ {
+		if b.failure("10.0.0.1", now) {
+			t.Errorf("Expect node never blacklisted")
+		}
+	}
+	if b.active(now) {
+		t.Errorf("Expect blacklist inactive")
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/naming"
+
+	"github.com/drone/runner-go/logger"
+)
+
+// Cleanup deletes stale virtual machine configurations once
+// a day at the time of day, until the context is canceled.
+// Configurations are created for each pipeline, and may
+// accumulate if the runner is restarted before the
+// configuration is purged.
+func (e *Engine) Cleanup(ctx context.Context, at, ttl time.Duration) {
+	for {
+		now := time.Now()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(nextClock(now, at).Sub(now)):
+			e.cleanup(ctx, ttl, time.Now())
+		}
+	}
+}
+
+// helper function deletes virtual machine configurations
+// created by the runner, including warm virtual machines,
+// that are not deployed, and that are older than the ttl. It
+// returns the number of deleted configurations.
+func (e *Engine) cleanup(ctx context.Context, ttl time.Duration, now time.Time) int {
+	log := logger.FromContext(ctx)
+	res, err := e.client.List(ctx)
+	if err != nil {
+		log.WithError(err).Warn("cannot list the vm configurations")
+		return 0
+	}
+	var n int
+	for _, vm := range res.VirtualMachineResources {
+		if len(vm.Status) != 0 {
+			continue
+		}
+		created, ok := naming.Created(naming.DefaultPrefix, vm.VirtualMachineName)
+		if !ok {
+			created, ok = naming.Created(naming.WarmPrefix, vm.VirtualMachineName)
+		}
+		if !ok || now.Sub(created) < ttl {
+			continue
+		}
+		if _, err := e.client.Delete(ctx, vm.VirtualMachineName); err != nil {
+			log.WithError(err).
+				WithField("id", vm.VirtualMachineName).
+				Warn("cannot delete the stale vm configuration")
+			continue
+		}
+		n++
+	}
+	log.WithField("count", n).Debug("deleted stale vm configurations")
+	return n
+}
+
+// helper function returns the next time after now at which
+// the local time of day is equal to the clock.
+func nextClock(now time.Time, clock time.Duration) time.Time {
+	y, m, d := now.Date()
+	next := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(clock)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/naming"
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/h2non/gock"
+)
+
+func TestCleanup(t *testing.T) {
+	defer gock.Off()
+
+	now := time.Now()
+	stale := naming.NewAt(naming.DefaultPrefix, now.Add(-48*time.Hour))
+	recent := naming.NewAt(naming.DefaultPrefix, now.Add(-time.Hour))
+	deployed := naming.NewAt(naming.DefaultPrefix, now.Add(-48*time.Hour))
+	warm := naming.NewAt(naming.WarmPrefix, now.Add(-48*time.Hour))
+
+	gock.New("http://orka.company.com").
+		Get("/resources/vm/list").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"virtual_machine_resources": []interface{}{
+				map[string]interface{}{"virtual_machine_name": stale},
+				map[string]interface{}{"virtual_machine_name": recent},
+				map[string]interface{}{"virtual_machine_name": warm},
+				map[string]interface{}{"virtual_machine_name": "macos-dev"},
+				map[string]interface{}{
+					"virtual_machine_name": deployed,
+					"status": []interface{}{
+						map[string]interface{}{"vm_status": "running"},
+					},
+				},
+			},
+		})
+
+	gock.New("http://orka.company.com").
+		Delete("/resources/vm/purge").
+		MatchType("json").
+		JSON(map[string]string{"orka_vm_name": stale}).
+		Reply(200).
+		JSON(map[string]interface{}{})
+
+	gock.New("http://orka.company.com").
+		Delete("/resources/vm/purge").
+		MatchType("json").
+		JSON(map[string]string{"orka_vm_name": warm}).
+		Reply(200).
+		JSON(map[string]interface{}{})
+
+	e := &Engine{client: &orka.Client{Endpoint: "http://orka.company.com"}}
+	if got, want := e.cleanup(noContext, 24*time.Hour, now), 2; got != want {
+		t.Errorf("Want %d deleted configurations, got %d", want, got)
+	}
+	if gock.IsPending() {
+		t.Errorf("Expect the stale configurations deleted")
+	}
+}
+
+func TestNextClock(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := nextClock(now, 15*time.Hour), time.Date(2020, 1, 1, 15, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Want next run %s, got %s", want, got)
+	}
+	if got, want := nextClock(now, 3*time.Hour), time.Date(2020, 1, 2, 3, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Want next run %s, got %s", want, got)
+	}
+}
+
+func TestDestroy_Undeployed(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://orka.company.com").
+		Delete("/resources/vm/purge").
+		MatchType("json").
+		JSON(map[string]string{"orka_vm_name": "drone-abc123"}).
+		Reply(200).
+		JSON(map[string]interface{}{})
+
+	e := &Engine{client: &orka.Client{Endpoint: "http://orka.company.com"}}
+	if err := e.destroy(noContext, &Spec{Name: "drone-def456"}); err != nil {
+		t.Error(err)
+	}
+	spec := &Spec{Name: "drone-abc123", created: true}
+	if err := e.destroy(noContext, spec); err != nil {
+		t.Error(err)
+	}
+	if gock.IsPending() {
+		t.Errorf("Expect the undeployed vm configuration purged")
+	}
+	if spec.created {
+		t.Errorf("Expect the vm configuration marked as purged")
+	}
+}
@@ -6,35 +6,91 @@ package compiler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/drone-runners/drone-runner-macstadium/engine"
 	"github.com/drone-runners/drone-runner-macstadium/engine/compiler/shell"
 	"github.com/drone-runners/drone-runner-macstadium/engine/resource"
+	"github.com/drone-runners/drone-runner-macstadium/internal/expr"
+	"github.com/drone-runners/drone-runner-macstadium/internal/naming"
 
+	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/clone"
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/environ/provider"
+	"github.com/drone/runner-go/logger"
 	"github.com/drone/runner-go/manifest"
 	"github.com/drone/runner-go/pipeline/runtime"
 	"github.com/drone/runner-go/secret"
 
-	"github.com/dchest/uniuri"
 	"github.com/gosimple/slug"
 )
 
 // random generator function
 var random = func() string {
-	return "drone" + uniuri.NewLenChars(20, []byte("abcdefghijklmnopqrstuvwxyz0123456789"))
+	return naming.New(naming.DefaultPrefix)
 }
 
+// default duration to wait for the preferred architecture
+// before falling back to the alternate architecture.
+const defaultFallbackTimeout = time.Minute * 10
+
+// maximum number of system log lines, and crash report lines
+// per report, printed when the pipeline fails.
+const (
+	systemLogLines   = 1000
+	crashReportLines = 200
+)
+
+// current time function
+var now = time.Now
+
 // Settings defines default settings.
 type Settings struct {
-	Compute  int
-	Image    string
-	Username string
-	Password string
+	Compute        int
+	Image          string
+	Username       string
+	Password       string
+	EphemeralKey   bool
+	RotatePassword bool
+	Shell          string
+	LoginShell     bool
+	Path           []string
+	Locale         string
+	Pipefail       bool
+	Nounset        bool
+	IdleTimeout    time.Duration
+	Timestamps     string
+	SystemLogs     time.Duration
+	CloneMirrors   map[string]string
+	CloneCACert    []byte
+	CloneInsecure  bool
+	DisableNetrc   bool
+	NetrcPublic    bool
+	StrictSecrets  bool
+	LazySecrets    bool
+	SecretEvents   map[string]string
+	PlatformImages map[string]string
+	PlatformTags   map[string]string
+	Reserved       []string
+
+	// DisableSpotlight, DisableUpdates and DisableSleep
+	// tune the virtual machine for continuous integration.
+	DisableSpotlight bool
+	DisableUpdates   bool
+	DisableSleep     bool
+
+	// KeepFailed retains the vms of failed pipelines for
+	// the duration, unless overridden by the pipeline, and
+	// KeepFailedMax limits the duration. If zero, the
+	// duration is not limited. Pipelines cannot retain vms
+	// unless KeepFailed is enabled by the runner.
+	KeepFailed    time.Duration
+	KeepFailedMax time.Duration
 }
 
 // Compiler compiles the Yaml configuration file to an
@@ -51,29 +107,214 @@ type Compiler struct {
 	// Settings provides global settings that apply to
 	// all pipelines.
 	Settings Settings
+
+	// Resolve optionally resolves the image alias to the
+	// image name.
+	Resolve func(ctx context.Context, image string) string
+
+	// Labels optionally provides the runner labels. If set,
+	// the pipeline node labels must match the runner labels.
+	Labels map[string]string
 }
 
 // Compile compiles the configuration file.
 func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runtime.Spec {
 	pipeline := args.Pipeline.(*resource.Pipeline)
-	os := "posix"
+
+	// the pipeline shell defaults to the runner shell, and
+	// may be overridden by each step.
+	pipelineShell := pipeline.Settings.Shell
+	if pipelineShell == "" {
+		pipelineShell = c.Settings.Shell
+	}
+
+	// options used to generate the shell scripts.
+	scriptOpts := shell.Options{
+		Path:     c.Settings.Path,
+		Pipefail: c.Settings.Pipefail,
+		Nounset:  c.Settings.Nounset,
+		Trace:    true,
+	}
+	if pipeline.Settings.Pipefail != nil {
+		scriptOpts.Pipefail = *pipeline.Settings.Pipefail
+	}
+	if pipeline.Settings.Nounset != nil {
+		scriptOpts.Nounset = *pipeline.Settings.Nounset
+	}
+	if pipeline.Settings.Trace != nil {
+		scriptOpts.Trace = *pipeline.Settings.Trace
+	}
+
+	// scripts are optionally executed by a login shell, which
+	// loads the user profile (rbenv, nvm, homebrew, etc).
+	login := c.Settings.LoginShell || pipeline.Settings.LoginShell
 
 	spec := &engine.Spec{
-		Name: random(),
+		Name:  random(),
+		Repo:  args.Repo.Slug,
+		Build: args.Build.Number,
+		Stage: args.Stage.Name,
 		Settings: engine.Settings{
-			Compute:  c.Settings.Compute,
-			Image:    pipeline.Settings.Image,
-			Username: c.Settings.Username,
-			Password: c.Settings.Password,
+			Compute:        c.Settings.Compute,
+			Image:          pipeline.Settings.Image,
+			Username:       c.Settings.Username,
+			Password:       c.Settings.Password,
+			EphemeralKey:   c.Settings.EphemeralKey,
+			RotatePassword: c.Settings.RotatePassword,
+			Priority:       parsePriority(pipeline.Priority),
+			IdleTimeout:    c.Settings.IdleTimeout,
+			Timestamps:     c.Settings.Timestamps,
+
+			DisableSpotlight: c.Settings.DisableSpotlight,
+			DisableUpdates:   c.Settings.DisableUpdates,
+			DisableSleep:     c.Settings.DisableSleep,
 		},
 	}
 
+	// the pipeline may override the credentials used to
+	// connect to the vm, optionally sourced from secrets.
+	for _, cred := range []struct {
+		src *manifest.Variable
+		dst *string
+	}{
+		{pipeline.Settings.Username, &spec.Settings.Username},
+		{pipeline.Settings.Password, &spec.Settings.Password},
+		{pipeline.Settings.SSHKey, &spec.Settings.PrivateKey},
+	} {
+		value, err := c.findVariable(ctx, args, cred.src)
+		if err != nil && spec.Error == "" {
+			spec.Error = err.Error()
+		}
+		if value != "" {
+			*cred.dst = value
+		}
+	}
+
+	// the pipeline node labels are matched against the runner
+	// labels as a defense in depth mechanism, in case the
+	// pipeline is routed to the wrong runner.
+	if c.Labels != nil && !matchNode(pipeline.Node, c.Labels) {
+		spec.Error = "pipeline node labels do not match the runner labels"
+	}
+
+	// pipelines may share a concurrency limit by key, which
+	// is enforced by the runner. The limit defaults to one.
+	if key := pipeline.Concurrency.Key; key != "" {
+		spec.Settings.ConcurrencyKey = key
+		spec.Settings.ConcurrencyLimit = pipeline.Concurrency.Limit
+		if spec.Settings.ConcurrencyLimit <= 0 {
+			spec.Settings.ConcurrencyLimit = 1
+		}
+		// the pipeline does not wait for the key longer than
+		// the repository timeout, after which the build would
+		// be killed by the server.
+		if args.Repo != nil && args.Repo.Timeout > 0 {
+			spec.Settings.ConcurrencyTimeout = time.Duration(args.Repo.Timeout) * time.Minute
+		}
+	}
+
+	// the pipeline may override the cpu count, which is
+	// limited by the linter.
+	if pipeline.Settings.Compute != 0 {
+		spec.Settings.Compute = pipeline.Settings.Compute
+	}
+
+	// the pipeline may override the idle timeout.
+	if pipeline.Settings.IdleTimeout != 0 {
+		spec.Settings.IdleTimeout = pipeline.Settings.IdleTimeout
+	}
+
+	// the vms of a failed pipeline are optionally retained
+	// for inspection, for a duration limited by the runner.
+	// the pipeline can only override the duration if the
+	// runner enables retention.
+	spec.Settings.KeepFailed = c.Settings.KeepFailed
+	if c.Settings.KeepFailed > 0 && pipeline.Settings.KeepFailed != 0 {
+		spec.Settings.KeepFailed = pipeline.Settings.KeepFailed
+	}
+	if max := c.Settings.KeepFailedMax; max > 0 && spec.Settings.KeepFailed > max {
+		spec.Settings.KeepFailed = max
+	}
+
+	// the pipeline may request a sysdiagnose bundle if the
+	// pipeline fails.
+	if pipeline.Settings.Debug == "sysdiagnose" {
+		spec.Settings.Sysdiagnose = true
+	}
+
+	// the pipeline may keep the login keychain unlocked.
+	spec.Settings.KeychainUnlock = pipeline.Settings.KeychainUnlock
+
+	// the pipeline may store notarization credentials in a
+	// notarytool keychain profile, optionally sourced from
+	// secrets.
+	if src := pipeline.Settings.Notarization; src != nil {
+		notary, err := c.convertNotary(ctx, args, src)
+		if err != nil && spec.Error == "" {
+			spec.Error = err.Error()
+		}
+		spec.Notary = notary
+	}
+
+	// the pipeline may override the timestamp format.
+	if pipeline.Settings.Timestamps != "" {
+		spec.Settings.Timestamps = pipeline.Settings.Timestamps
+	}
+
+	// if the pipeline specifies an image per architecture,
+	// the image for the platform architecture is preferred,
+	// with the alternate architecture as a fallback.
+	if spec.Settings.Image == "" && len(pipeline.Settings.Images) != 0 {
+		preferred, fallback := selectImages(
+			pipeline.Settings.Images,
+			pipeline.Platform.Arch,
+		)
+		spec.Settings.Image = preferred
+		spec.Settings.FallbackImage = fallback
+		spec.Settings.FallbackTimeout = pipeline.Settings.FallbackTimeout
+		if spec.Settings.FallbackTimeout == 0 {
+			spec.Settings.FallbackTimeout = defaultFallbackTimeout
+		}
+	}
+
+	// the runner may define an image and node tag for each
+	// architecture, so that a single runner serves both intel
+	// and apple silicon nodes. The pipeline platform selects
+	// the architecture, unless the pipeline does not define
+	// an image for the architecture.
+	if arch := pipeline.Platform.Arch; arch != "" &&
+		(len(pipeline.Settings.Images) == 0 || pipeline.Settings.Images[arch] != "") {
+		if spec.Settings.Image == "" {
+			spec.Settings.Image = c.Settings.PlatformImages[arch]
+		}
+		spec.Settings.Tag = c.Settings.PlatformTags[arch]
+		if spec.Settings.FallbackImage != "" {
+			spec.Settings.FallbackTag = c.Settings.PlatformTags[alternateArch(arch)]
+		}
+	}
+
+	// pipelines written for the vm runner select the pool
+	// instead of the image. The pool name is used as the
+	// image name, and may be mapped to an image with an
+	// image alias.
+	if spec.Settings.Image == "" && pipeline.Pool.Use != "" {
+		spec.Settings.Image = pipeline.Pool.Use
+	}
+
 	// if the pipeline does not specify an image, fallback
 	// to the default image.
 	if spec.Settings.Image == "" {
 		spec.Settings.Image = c.Settings.Image
 	}
 
+	// resolve the image alias, if configured.
+	if c.Resolve != nil {
+		spec.Settings.Image = c.Resolve(ctx, spec.Settings.Image)
+		if spec.Settings.FallbackImage != "" {
+			spec.Settings.FallbackImage = c.Resolve(ctx, spec.Settings.FallbackImage)
+		}
+	}
+
 	// creates a source directory in the root.
 	// note: mkdirall fails on windows so we need to create all
 	// directories in the tree.
@@ -84,6 +325,16 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		IsDir: true,
 	})
 
+	// temporary volumes backed by a ram disk.
+	spec.Volumes = convertVolumes(pipeline.Volumes, engine.DefaultVolumeSize)
+
+	// simulators booted before the pipeline steps execute.
+	spec.Simulators = pipeline.Settings.Simulators
+
+	// folders synchronized with the runner host.
+	spec.Sync.Push = convertSync(pipeline.Sync.Push, sourcedir, true)
+	spec.Sync.Pull = convertSync(pipeline.Sync.Pull, sourcedir, false)
+
 	// creates the opt directory to hold all scripts.
 	scriptdir := filepath.Join("/tmp", "scripts")
 	spec.Files = append(spec.Files, &engine.File{
@@ -92,19 +343,62 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		IsDir: true,
 	})
 
+	// the pipeline may define an app store connect api key,
+	// which is written to the key directory, and exposed to
+	// the steps using the environment variables read by
+	// fastlane.
+	appStoreEnv := map[string]string{}
+	if src := pipeline.Settings.AppStoreConnect; src != nil {
+		var keyID, issuerID, key string
+		for _, v := range []struct {
+			src *manifest.Variable
+			dst *string
+		}{
+			{src.KeyID, &keyID},
+			{src.IssuerID, &issuerID},
+			{src.Key, &key},
+		} {
+			value, err := c.findVariable(ctx, args, v.src)
+			if err != nil && spec.Error == "" {
+				spec.Error = err.Error()
+			}
+			*v.dst = value
+		}
+		// the key id may be sourced from a secret, and is
+		// therefore verified before it is used in the path.
+		if keyID == "" || filepath.Base(keyID) != keyID {
+			if spec.Error == "" {
+				spec.Error = "invalid app store connect key id"
+			}
+		}
+		keydir := filepath.Join("/tmp", "appstoreconnect")
+		spec.AppStoreKey = filepath.Join(keydir, "AuthKey_"+keyID+".p8")
+		spec.Files = append(spec.Files,
+			&engine.File{
+				Path:  keydir,
+				Mode:  0700,
+				IsDir: true,
+			},
+			&engine.File{
+				Path: spec.AppStoreKey,
+				Mode: 0600,
+				Data: []byte(key),
+			},
+		)
+		appStoreEnv["APP_STORE_CONNECT_API_KEY_KEY_ID"] = keyID
+		appStoreEnv["APP_STORE_CONNECT_API_KEY_ISSUER_ID"] = issuerID
+		appStoreEnv["APP_STORE_CONNECT_API_KEY_KEY_FILEPATH"] = spec.AppStoreKey
+	}
+
 	// list the global environment variables
 	globals, _ := c.Environ.List(ctx, &provider.Request{
 		Build: args.Build,
 		Repo:  args.Repo,
 	})
 
-	// create the default environment variables.
-	envs := environ.Combine(
-		provider.ToMap(
-			provider.FilterUnmasked(globals),
-		),
-		args.Build.Params,
-		pipeline.Environment,
+	// create the system environment variables set by the
+	// runner.
+	system := environ.Combine(
 		environ.Proxy(),
 		environ.System(args.System),
 		environ.Repo(args.Repo),
@@ -126,8 +420,30 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		},
 	)
 
-	// create the netrc environment variables
-	if args.Netrc != nil && args.Netrc.Machine != "" {
+	// the fastlane environment variables, optionally sourced
+	// from secrets.
+	fastlaneEnv := fastlaneEnviron(pipeline.Settings.Fastlane)
+
+	// create the default environment variables. the locale
+	// has the lowest precedence, and can be overridden by the
+	// global or pipeline environment.
+	envs := environ.Combine(
+		convertStaticEnv(fastlaneEnv),
+		localeEnviron(c.Settings.Locale),
+		provider.ToMap(
+			provider.FilterUnmasked(globals),
+		),
+		args.Build.Params,
+		convertStaticEnv(pipeline.Environment),
+		appStoreEnv,
+		system,
+	)
+
+	// create the netrc environment variables. The netrc
+	// credentials may be omitted by the runner or the pipeline
+	// for repositories that clone anonymously, and are omitted
+	// by default for public repositories.
+	if args.Netrc != nil && args.Netrc.Machine != "" && c.useNetrc(pipeline, args.Repo) {
 		envs["DRONE_NETRC_MACHINE"] = args.Netrc.Machine
 		envs["DRONE_NETRC_USERNAME"] = args.Netrc.Login
 		envs["DRONE_NETRC_PASSWORD"] = args.Netrc.Password
@@ -139,6 +455,22 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		)
 	}
 
+	// the notarytool keychain profile name is exposed to the
+	// steps, for use with xcrun notarytool --keychain-profile.
+	if spec.Notary != nil {
+		envs["DRONE_NOTARY_PROFILE"] = spec.Notary.Profile
+	}
+
+	// the pipeline environment cannot override variables
+	// set by the runner with a reserved prefix.
+	var names []string
+	for k := range pipeline.Environment {
+		names = append(names, k)
+	}
+	if name := reservedName(names, system, c.Settings.Reserved); name != "" {
+		spec.Error = fmt.Sprintf("environment variable %s is reserved", name)
+	}
+
 	match := manifest.Match{
 		Action:   args.Build.Action,
 		Cron:     args.Build.Cron,
@@ -150,52 +482,194 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		Branch:   args.Build.Target,
 	}
 
+	// variables available to the step condition expressions.
+	vars := exprVars(args)
+
+	// additional vms inherit the pipeline settings, folders
+	// and files, and may override the image and cpu. The vms
+	// are created after all pipeline files are added.
+	for _, vm := range pipeline.VMs {
+		group := &engine.Spec{
+			Name:        random(),
+			Repo:        spec.Repo,
+			Build:       spec.Build,
+			Stage:       spec.Stage,
+			Group:       vm.Name,
+			Settings:    spec.Settings,
+			Files:       append([]*engine.File(nil), spec.Files...),
+			AppStoreKey: spec.AppStoreKey,
+		}
+		if vm.Image != "" {
+			group.Settings.Image = vm.Image
+			group.Settings.FallbackImage = ""
+			if c.Resolve != nil {
+				group.Settings.Image = c.Resolve(ctx, vm.Image)
+			}
+		}
+		if vm.Compute != 0 {
+			group.Settings.Compute = vm.Compute
+		}
+		spec.Groups = append(spec.Groups, group)
+	}
+
 	// create the clone step, maybe
 	if pipeline.Clone.Disable == false {
 		clonepath := filepath.Join(scriptdir, "clone")
-		clonefile := shell.Script(
-			clone.Commands(
-				clone.Args{
-					Branch: args.Build.Target,
-					Commit: args.Build.After,
-					Ref:    args.Build.Ref,
-					Remote: args.Repo.HTTPURL,
-				},
-			),
+
+		// the repository is optionally fetched from a mirror,
+		// after which the origin is reset to the canonical
+		// remote url.
+		remote := cloneMirror(args.Repo.HTTPURL, c.Settings.CloneMirrors)
+		clonecmds := clone.Commands(
+			clone.Args{
+				Branch: args.Build.Target,
+				Commit: args.Build.After,
+				Ref:    args.Build.Ref,
+				Remote: remote,
+			},
 		)
+		if remote != args.Repo.HTTPURL {
+			clonecmds = append(clonecmds, fmt.Sprintf(
+				"git remote set-url origin %s",
+				args.Repo.HTTPURL,
+			))
+		}
+		clonefile := shell.Script(clonecmds, scriptOpts)
+
+		// the clone step optionally trusts a custom certificate
+		// authority, or skips certificate verification, for
+		// self-signed git servers. These settings only apply
+		// to the clone step.
+		cloneenvs := envs
+		clonefiles := []*engine.File{
+			{
+				Path: clonepath,
+				Mode: 0700,
+				Data: []byte(clonefile),
+			},
+		}
+		if len(c.Settings.CloneCACert) != 0 {
+			capath := filepath.Join(scriptdir, "clone-ca.pem")
+			clonefiles = append(clonefiles, &engine.File{
+				Path: capath,
+				Mode: 0600,
+				Data: c.Settings.CloneCACert,
+			})
+			cloneenvs = environ.Combine(cloneenvs, map[string]string{
+				"GIT_SSL_CAINFO": capath,
+			})
+		}
+		if c.Settings.CloneInsecure {
+			cloneenvs = environ.Combine(cloneenvs, map[string]string{
+				"GIT_SSL_NO_VERIFY": "true",
+			})
+		}
+
+		cmd, args := getCommand(pipelineShell, clonepath, login)
+		clonestep := &engine.Step{
+			Name:       "clone",
+			Args:       args,
+			Command:    cmd,
+			Envs:       cloneenvs,
+			RunPolicy:  runtime.RunAlways,
+			Files:      clonefiles,
+			Secrets:    []*engine.Secret{},
+			WorkingDir: sourcedir,
+		}
+		spec.Steps = append(spec.Steps, clonestep)
 
-		cmd, args := getCommand(os, clonepath)
+		// the repository is also cloned on each additional vm.
+		for _, group := range spec.Groups {
+			dst := *clonestep
+			dst.Name = cloneName(group.Group)
+			dst.VM = group.Group
+			spec.Steps = append(spec.Steps, &dst)
+		}
+	}
+
+	// the gems are optionally installed with bundler before
+	// the pipeline steps execute, and optionally cached on the
+	// runner host between pipelines.
+	if f := pipeline.Settings.Fastlane; f != nil && f.Bundle {
+		bundlepath := filepath.Join(scriptdir, "bundle")
+		bundlefile := shell.Script([]string{
+			"bundle config set --local path vendor/bundle",
+			"bundle install --jobs 4 --retry 3",
+		}, scriptOpts)
+		cmd, args := getCommand(pipelineShell, bundlepath, login)
 		spec.Steps = append(spec.Steps, &engine.Step{
-			Name:      "clone",
+			Name:      "bundle",
 			Args:      args,
 			Command:   cmd,
 			Envs:      envs,
-			RunPolicy: runtime.RunAlways,
+			RunPolicy: runtime.RunOnSuccess,
 			Files: []*engine.File{
 				{
-					Path: clonepath,
+					Path: bundlepath,
 					Mode: 0700,
-					Data: []byte(clonefile),
+					Data: []byte(bundlefile),
 				},
 			},
-			Secrets:    []*engine.Secret{},
+			Secrets: mergeSecrets(
+				convertSecretEnv(fastlaneEnv),
+				convertSecretEnv(pipeline.Environment),
+			),
 			WorkingDir: sourcedir,
 		})
+		if f.BundleCache != "" {
+			bundledir := remotePath(sourcedir, "vendor/bundle")
+			spec.Sync.Push = append(spec.Sync.Push, &engine.SyncPath{
+				Source:   f.BundleCache,
+				Target:   bundledir,
+				Optional: true,
+			})
+			spec.Sync.Pull = append(spec.Sync.Pull, &engine.SyncPath{
+				Source: bundledir,
+				Target: f.BundleCache,
+			})
+		}
 	}
 
 	// create steps
+	groups := map[string]string{}
 	for _, src := range pipeline.Steps {
+		if src.Parallel != "" {
+			groups[src.Name] = src.Parallel
+		}
 		buildslug := slug.Make(src.Name)
 		buildpath := filepath.Join(scriptdir, buildslug)
-		buildfile := shell.Script(src.Commands)
+		// the step may override whether commands are echoed
+		// before execution.
+		stepOpts := scriptOpts
+		if src.Trace != nil {
+			stepOpts.Trace = *src.Trace
+		}
+		buildfile := shell.Script(src.Commands, stepOpts)
 
-		cmd, args := getCommand(os, buildpath)
+		// the step environment cannot override variables set
+		// by the runner with a reserved prefix.
+		if spec.Error == "" {
+			var names []string
+			for k := range src.Environment {
+				names = append(names, k)
+			}
+			if name := reservedName(names, system, c.Settings.Reserved); name != "" {
+				spec.Error = fmt.Sprintf("environment variable %s is reserved", name)
+			}
+		}
+
+		stepShell := src.Shell
+		if stepShell == "" {
+			stepShell = pipelineShell
+		}
+		cmd, args := getCommand(stepShell, buildpath, login)
 		dst := &engine.Step{
 			Name:      src.Name,
 			Args:      args,
 			Command:   cmd,
 			Detach:    src.Detach,
 			DependsOn: src.DependsOn,
+			Formatter: src.Formatter,
 			Envs: environ.Combine(envs,
 				environ.Expand(
 					convertStaticEnv(src.Environment),
@@ -209,9 +683,25 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 					Data: []byte(buildfile),
 				},
 			},
-			Secrets:    convertSecretEnv(src.Environment),
+			Secrets: append(
+				mergeSecrets(
+					mergeSecrets(
+						convertSecretEnv(fastlaneEnv),
+						convertSecretEnv(pipeline.Environment),
+					),
+					convertSecrets(src.Environment, src.Secrets),
+				),
+				convertSecretFiles(src.SecretFiles, sourcedir)...,
+			),
+			VM:         src.VM,
 			WorkingDir: sourcedir,
 		}
+		for _, pattern := range src.Reports {
+			dst.Reports = append(dst.Reports, remotePath(sourcedir, pattern))
+		}
+		for _, pattern := range src.Coverage {
+			dst.Coverage = append(dst.Coverage, remotePath(sourcedir, pattern))
+		}
 		spec.Steps = append(spec.Steps, dst)
 
 		// set the pipeline step run policy. steps run on
@@ -227,22 +717,106 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		// automatically skipped.
 		if !src.When.Match(match) {
 			dst.RunPolicy = runtime.RunNever
+		} else if src.When.Expr != "" {
+			ok, err := expr.Eval(src.When.Expr, vars)
+			if err != nil && spec.Error == "" {
+				spec.Error = fmt.Sprintf("step %s: %s", src.Name, err)
+			}
+			if !ok {
+				dst.RunPolicy = runtime.RunNever
+			}
 		}
 	}
 
-	if isGraph(spec) == false {
-		configureSerial(spec)
+	graph := isGraph(spec)
+	if graph == false {
+		configureSerial(spec, groups)
 	} else if pipeline.Clone.Disable == false {
 		configureCloneDeps(spec)
 	} else if pipeline.Clone.Disable == true {
 		removeCloneDeps(spec)
 	}
+	if f := pipeline.Settings.Fastlane; f != nil && f.Bundle && graph {
+		configureBundleDeps(spec)
+	}
+	if graph {
+		configureGroupDeps(spec, groups)
+	}
+
+	// if the pipeline bakes an image, a final step saves the
+	// virtual machine as a new base image once all other
+	// steps complete successfully.
+	if pipeline.Settings.Bake != "" {
+		dst := &engine.Step{
+			Name:      "bake",
+			Bake:      bakeImage(pipeline.Settings.Bake, now()),
+			BakeAlias: pipeline.Settings.Bake,
+			RunPolicy: runtime.RunOnSuccess,
+		}
+		for _, step := range spec.Steps {
+			dst.DependsOn = append(dst.DependsOn, step.Name)
+		}
+		spec.Steps = append(spec.Steps, dst)
+	}
+
+	// if system log collection is enabled, a final step
+	// prints the recent macOS system log and crash reports
+	// when the pipeline fails.
+	systemLogs := c.Settings.SystemLogs
+	if pipeline.Settings.SystemLogs != 0 {
+		systemLogs = pipeline.Settings.SystemLogs
+	}
+	if systemLogs > 0 {
+		logpath := filepath.Join(scriptdir, "system-logs")
+		logfile := shell.Script(
+			systemLogCommands(systemLogs, scriptdir),
+			scriptOpts,
+		)
+		cmd, args := getCommand(pipelineShell, logpath, login)
+		dst := &engine.Step{
+			Name:      "system-logs",
+			Args:      args,
+			Command:   cmd,
+			Envs:      envs,
+			RunPolicy: runtime.RunOnFailure,
+			Files: []*engine.File{
+				{
+					Path: logpath,
+					Mode: 0700,
+					Data: []byte(logfile),
+				},
+			},
+			Secrets:    []*engine.Secret{},
+			WorkingDir: sourcedir,
+		}
+		for _, step := range spec.Steps {
+			dst.DependsOn = append(dst.DependsOn, step.Name)
+		}
+		spec.Steps = append(spec.Steps, dst)
+	}
 
 	for _, step := range spec.Steps {
 		for _, s := range step.Secrets {
-			secret, ok := c.findSecret(ctx, args, s.Name)
+			// in lazy mode the secret is resolved just before
+			// the step runs, so that short-lived credentials
+			// do not expire before they are used.
+			if c.Settings.LazySecrets {
+				s.Resolve = c.resolveSecret(args, s.Name)
+				continue
+			}
+			secret, ok, err := c.findSecret(ctx, args, s.Name)
 			if ok {
 				s.Data = []byte(secret)
+				continue
+			}
+			// in strict mode a missing secret fails the
+			// pipeline, instead of injecting an empty value.
+			if c.Settings.StrictSecrets && spec.Error == "" {
+				if err != nil {
+					spec.Error = fmt.Sprintf("secret %s: %s", s.Name, err)
+				} else {
+					spec.Error = fmt.Sprintf("secret %s not found", s.Name)
+				}
 			}
 		}
 	}
@@ -252,21 +826,31 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 
 // helper function attempts to find and return the named secret.
 // from the secret provider.
-func (c *Compiler) findSecret(ctx context.Context, args runtime.CompilerArgs, name string) (s string, ok bool) {
+func (c *Compiler) findSecret(ctx context.Context, args runtime.CompilerArgs, name string) (s string, ok bool, err error) {
 	if name == "" {
 		return
 	}
+	// the runner may restrict the secret to a subset of
+	// build events, for example, to prevent exposing signing
+	// certificates to pull requests.
+	if event := args.Build.Event; !secretAllowed(name, event, c.Settings.SecretEvents) {
+		logger.FromContext(ctx).
+			WithField("secret", name).
+			WithField("event", event).
+			Debug("secret restricted from event")
+		return "", false, fmt.Errorf("restricted from %s events", event)
+	}
 	// source secrets from the global secret provider
 	// and the repository secret provider.
 	provider := secret.Combine(
 		args.Secret,
 		c.Secret,
 	)
-	// fine the secret from the provider. please note we
-	// currently ignore errors if the secret is not found,
-	// which is something that we'll need to address in the
-	// next major (breaking) release.
-	found, _ := provider.Find(ctx, &secret.Request{
+	// fine the secret from the provider. please note the
+	// error is ignored by the caller unless strict mode is
+	// enabled, which is something that we'll need to address
+	// in the next major (breaking) release.
+	found, err := provider.Find(ctx, &secret.Request{
 		Name:  name,
 		Build: args.Build,
 		Repo:  args.Repo,
@@ -275,5 +859,102 @@ func (c *Compiler) findSecret(ctx context.Context, args runtime.CompilerArgs, na
 	if found == nil {
 		return
 	}
-	return found.Data, true
+	return found.Data, true, nil
+}
+
+// helper function returns the variable value, or the value
+// of the secret if the variable is sourced from a secret. A
+// missing secret returns an error.
+func (c *Compiler) findVariable(ctx context.Context, args runtime.CompilerArgs, v *manifest.Variable) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	if v.Secret == "" {
+		return v.Value, nil
+	}
+	s, ok, err := c.findSecret(ctx, args, v.Secret)
+	switch {
+	case ok:
+		return s, nil
+	case err != nil:
+		return "", fmt.Errorf("secret %s: %s", v.Secret, err)
+	default:
+		return "", fmt.Errorf("secret %s not found", v.Secret)
+	}
+}
+
+// helper function converts the notarization settings to the
+// notarization credentials, resolving values sourced from
+// secrets. The profile name defaults to notary.
+func (c *Compiler) convertNotary(ctx context.Context, args runtime.CompilerArgs, src *resource.Notarization) (*engine.Notary, error) {
+	dst := &engine.Notary{Profile: src.Profile}
+	if dst.Profile == "" {
+		dst.Profile = "notary"
+	}
+	for _, v := range []struct {
+		src *manifest.Variable
+		dst *string
+	}{
+		{src.AppleID, &dst.AppleID},
+		{src.Password, &dst.Password},
+		{src.TeamID, &dst.TeamID},
+		{src.APIKey, &dst.Key},
+		{src.APIKeyID, &dst.KeyID},
+		{src.APIIssuer, &dst.Issuer},
+	} {
+		value, err := c.findVariable(ctx, args, v.src)
+		if err != nil {
+			return dst, err
+		}
+		*v.dst = value
+	}
+	return dst, nil
+}
+
+// helper function returns a function that resolves the named
+// secret from the secret provider when the step runs. The
+// secret is resolved once and the result is cached. In strict
+// mode a missing secret returns an error.
+func (c *Compiler) resolveSecret(args runtime.CompilerArgs, name string) func(context.Context) ([]byte, error) {
+	var (
+		once sync.Once
+		data []byte
+		err  error
+	)
+	return func(ctx context.Context) ([]byte, error) {
+		once.Do(func() {
+			secret, ok, findErr := c.findSecret(ctx, args, name)
+			switch {
+			case ok:
+				data = []byte(secret)
+			case c.Settings.StrictSecrets && findErr != nil:
+				err = findErr
+			case c.Settings.StrictSecrets:
+				err = errors.New("not found")
+			}
+		})
+		return data, err
+	}
+}
+
+// helper function returns true if the netrc credentials should
+// be injected into the pipeline. The runner may disable the
+// netrc credentials for all pipelines, and the pipeline may
+// disable the netrc credentials if the repository is cloned
+// anonymously. Public repositories are cloned anonymously
+// unless the runner explicitly enables the netrc credentials.
+// The pipeline cannot enable the netrc credentials, since
+// the pipeline configuration of a public repository may be
+// changed by a pull request from a fork.
+func (c *Compiler) useNetrc(pipeline *resource.Pipeline, repo *drone.Repo) bool {
+	if c.Settings.DisableNetrc {
+		return false
+	}
+	if pipeline.Settings.Netrc != nil && !*pipeline.Settings.Netrc {
+		return false
+	}
+	if repo != nil && !repo.Private {
+		return c.Settings.NetrcPublic
+	}
+	return true
 }
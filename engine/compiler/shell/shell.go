@@ -12,14 +12,91 @@ import (
 	"strings"
 )
 
+// Supported shells.
+const (
+	Sh   = "sh"
+	Bash = "bash"
+	Zsh  = "zsh"
+)
+
+// Default is the default shell.
+const Default = Sh
+
+// IsValid returns true if the shell is supported.
+func IsValid(name string) bool {
+	switch name {
+	case Sh, Bash, Zsh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Command returns the shell command and arguments used to
+// execute the script with the named shell. The generated
+// scripts are posix-compliant and can be executed by any
+// of the supported shells. If the shell is not supported,
+// the default shell is used. If login is true the script is
+// executed by a login shell, which loads the user profile.
+func Command(name, script string, login bool) (string, []string) {
+	var args []string
+	if login {
+		args = append(args, "-l")
+	}
+	args = append(args, "-e", script)
+	switch name {
+	case Bash:
+		return "/bin/bash", args
+	case Zsh:
+		return "/bin/zsh", args
+	default:
+		return "/bin/sh", args
+	}
+}
+
+// Options configures the generated script.
+type Options struct {
+	// Path is a list of directories that are prepended to
+	// the PATH environment variable. Non-interactive ssh
+	// sessions do not load the user profile, and would
+	// otherwise be unable to find tools installed with
+	// package managers such as Homebrew.
+	Path []string
+
+	// Pipefail causes a pipeline to fail if any command in
+	// the pipeline fails, instead of only the last command.
+	Pipefail bool
+
+	// Nounset causes the script to fail when an unset
+	// variable is referenced.
+	Nounset bool
+
+	// Trace echoes each command before it is executed.
+	Trace bool
+}
+
 // Script converts a slice of individual shell commands to
 // a posix-compliant shell script.
-func Script(commands []string) string {
+func Script(commands []string, opts Options) string {
 	buf := new(bytes.Buffer)
 	fmt.Fprintln(buf)
 	fmt.Fprintf(buf, optionScript)
+	if opts.Pipefail {
+		fmt.Fprintln(buf, "set -o pipefail")
+	}
+	if opts.Nounset {
+		fmt.Fprintln(buf, "set -u")
+	}
+	if len(opts.Path) != 0 {
+		fmt.Fprintf(buf, pathScript, strings.Join(opts.Path, ":"))
+	}
 	fmt.Fprintln(buf)
 	for _, command := range commands {
+		if !opts.Trace {
+			fmt.Fprintln(buf)
+			fmt.Fprintln(buf, command)
+			continue
+		}
 		escaped := fmt.Sprintf("%q", command)
 		escaped = strings.Replace(escaped, "$", `\$`, -1)
 		buf.WriteString(fmt.Sprintf(
@@ -46,6 +123,11 @@ unset DRONE_NETRC_FILE
 set -e
 `
 
+// pathScript is a helper script that is added to the build
+// script to prepend directories to the PATH.
+const pathScript = `export PATH="%s:$PATH"
+`
+
 // traceScript is a helper script that is added to
 // the build script to trace a command.
 const traceScript = `
@@ -3,3 +3,86 @@
 // that can be found in the LICENSE file.
 
 package shell
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		shell string
+		cmd   string
+	}{
+		{"", "/bin/sh"},
+		{"sh", "/bin/sh"},
+		{"bash", "/bin/bash"},
+		{"zsh", "/bin/zsh"},
+		{"fish", "/bin/sh"},
+	}
+	for _, test := range tests {
+		cmd, args := Command(test.shell, "/tmp/scripts/build", false)
+		if cmd != test.cmd {
+			t.Errorf("Want command %q for shell %q, got %q", test.cmd, test.shell, cmd)
+		}
+		if want := []string{"-e", "/tmp/scripts/build"}; !reflect.DeepEqual(args, want) {
+			t.Errorf("Want args %v for shell %q, got %v", want, test.shell, args)
+		}
+	}
+}
+
+func TestCommand_Login(t *testing.T) {
+	cmd, args := Command("zsh", "/tmp/scripts/build", true)
+	if want := "/bin/zsh"; cmd != want {
+		t.Errorf("Want command %q, got %q", want, cmd)
+	}
+	if want := []string{"-l", "-e", "/tmp/scripts/build"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("Want args %v, got %v", want, args)
+	}
+}
+
+func TestScript_Path(t *testing.T) {
+	script := Script([]string{"brew --version"}, Options{
+		Path: []string{"/opt/homebrew/bin", "/usr/local/bin"},
+	})
+	want := `export PATH="/opt/homebrew/bin:/usr/local/bin:$PATH"`
+	if !strings.Contains(script, want) {
+		t.Errorf("Want script to prepend the path, got %s", script)
+	}
+	if strings.Contains(Script([]string{"brew --version"}, Options{}), "export PATH") {
+		t.Errorf("Want path unchanged when no directories are configured")
+	}
+}
+
+func TestScript_Options(t *testing.T) {
+	script := Script([]string{"go test ./... | tee test.log"}, Options{
+		Pipefail: true,
+		Nounset:  true,
+	})
+	for _, want := range []string{"set -e\n", "set -o pipefail\n", "set -u\n"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Want script to contain %q, got %s", want, script)
+		}
+	}
+	script = Script([]string{"go test ./... | tee test.log"}, Options{})
+	for _, unwanted := range []string{"set -o pipefail", "set -u"} {
+		if strings.Contains(script, unwanted) {
+			t.Errorf("Want script to not contain %q, got %s", unwanted, script)
+		}
+	}
+}
+
+func TestScript_Trace(t *testing.T) {
+	script := Script([]string{"go build"}, Options{Trace: true})
+	if !strings.Contains(script, `echo + "go build"`) {
+		t.Errorf("Want command echoed when tracing, got %s", script)
+	}
+	script = Script([]string{"go build"}, Options{Trace: false})
+	if strings.Contains(script, "echo +") {
+		t.Errorf("Want command not echoed when not tracing, got %s", script)
+	}
+	if !strings.Contains(script, "\ngo build\n") {
+		t.Errorf("Want command in script, got %s", script)
+	}
+}
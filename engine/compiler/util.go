@@ -5,20 +5,229 @@
 package compiler
 
 import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/drone-runners/drone-runner-macstadium/engine"
+	"github.com/drone-runners/drone-runner-macstadium/engine/compiler/shell"
 	"github.com/drone-runners/drone-runner-macstadium/engine/resource"
+	"github.com/drone-runners/drone-runner-macstadium/internal/units"
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/manifest"
-	"github.com/drone/runner-go/shell/bash"
+	"github.com/drone/runner-go/pipeline/runtime"
 )
 
 // helper function returns the shell command and arguments
-// based on the target platform to invoke the script
-func getCommand(os, script string) (string, []string) {
-	cmd, args := bash.Command()
-	return cmd, append(args, script)
+// based on the configured shell to invoke the script
+func getCommand(name, script string, login bool) (string, []string) {
+	return shell.Command(name, script, login)
+}
+
+// helper function returns the numeric priority for the named
+// pipeline priority. Unknown values use the normal priority.
+func parsePriority(s string) int {
+	switch strings.ToLower(s) {
+	case "high":
+		return engine.PriorityHigh
+	case "low":
+		return engine.PriorityLow
+	default:
+		return engine.PriorityNormal
+	}
+}
+
+// helper function returns the locale environment variables.
+// Non-interactive ssh sessions on macOS do not set a locale,
+// which causes tools such as CocoaPods and Ruby to fail with
+// encoding errors.
+func localeEnviron(locale string) map[string]string {
+	if locale == "" {
+		return nil
+	}
+	return map[string]string{
+		"LANG":   locale,
+		"LC_ALL": locale,
+	}
+}
+
+// helper function returns the fastlane environment variables,
+// which disable the update check and the changelog, and set
+// the utf-8 locale that fastlane requires. The credentials may
+// be sourced from secrets.
+func fastlaneEnviron(src *resource.Fastlane) map[string]*manifest.Variable {
+	if src == nil {
+		return nil
+	}
+	dst := map[string]*manifest.Variable{
+		"FASTLANE_SKIP_UPDATE_CHECK": {Value: "1"},
+		"FASTLANE_HIDE_CHANGELOG":    {Value: "1"},
+		"LANG":                       {Value: "en_US.UTF-8"},
+		"LC_ALL":                     {Value: "en_US.UTF-8"},
+	}
+	for k, v := range map[string]*manifest.Variable{
+		"FASTLANE_USER":                 src.User,
+		"FASTLANE_PASSWORD":             src.Password,
+		"FASTLANE_SESSION":              src.Session,
+		"MATCH_PASSWORD":                src.MatchPassword,
+		"MATCH_GIT_BASIC_AUTHORIZATION": src.MatchGitBasicAuthorization,
+	} {
+		if v != nil {
+			dst[k] = v
+		}
+	}
+	return dst
+}
+
+// helper function returns the alternate architecture.
+func alternateArch(arch string) string {
+	if arch == "amd64" {
+		return "arm64"
+	}
+	return "amd64"
+}
+
+// helper function returns the preferred image for the
+// architecture, and the fallback image for the alternate
+// architecture. If the architecture is not set, arm64 is
+// preferred.
+func selectImages(images map[string]string, arch string) (preferred, fallback string) {
+	if arch == "" {
+		arch = "arm64"
+	}
+	preferred, fallback = images[arch], images[alternateArch(arch)]
+	if preferred == "" {
+		return fallback, ""
+	}
+	return preferred, fallback
+}
+
+// helper function returns the name of the baked image, which
+// is suffixed with the creation time so that images baked
+// from the same pipeline sort chronologically.
+func bakeImage(name string, t time.Time) string {
+	return name + "-" + t.UTC().Format("20060102150405") + ".img"
+}
+
+// helper function converts the sync paths. Paths on the
+// virtual machine that are not absolute are relative to the
+// workspace root.
+func convertSync(src []*resource.SyncPath, root string, push bool) []*engine.SyncPath {
+	var dst []*engine.SyncPath
+	for _, p := range src {
+		out := &engine.SyncPath{
+			Source:  p.Source,
+			Target:  p.Target,
+			Include: p.Include,
+			Exclude: p.Exclude,
+		}
+		if push {
+			out.Target = remotePath(root, p.Target)
+		} else {
+			out.Source = remotePath(root, p.Source)
+		}
+		dst = append(dst, out)
+	}
+	return dst
+}
+
+// helper function returns the variables available to step
+// condition expressions. Build parameters are prefixed with
+// params.
+func exprVars(args runtime.CompilerArgs) map[string]string {
+	vars := map[string]string{
+		"action":   args.Build.Action,
+		"author":   args.Build.Author,
+		"branch":   args.Build.Target,
+		"cron":     args.Build.Cron,
+		"event":    args.Build.Event,
+		"instance": args.System.Host,
+		"message":  args.Build.Message,
+		"ref":      args.Build.Ref,
+		"repo":     args.Repo.Slug,
+		"source":   args.Build.Source,
+		"target":   args.Build.Deploy,
+	}
+	for k, v := range args.Build.Params {
+		vars["params."+k] = v
+	}
+	return vars
+}
+
+// helper function returns true if each pipeline node label
+// matches the runner label with the same key.
+func matchNode(node, labels map[string]string) bool {
+	for k, v := range node {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// helper function converts the pipeline volumes to ram disks
+// of the requested size, or the default size.
+func convertVolumes(src []*resource.Volume, size int64) []*engine.Volume {
+	var dst []*engine.Volume
+	for _, v := range src {
+		if v.Temp == nil {
+			continue
+		}
+		vol := &engine.Volume{
+			Name: v.Name,
+			Path: v.Path,
+			Size: size,
+		}
+		if n, err := units.ParseBytes(v.Temp.Size); err == nil {
+			vol.Size = n
+		}
+		dst = append(dst, vol)
+	}
+	return dst
+}
+
+// helper function returns the absolute path on the virtual
+// machine, relative to the root.
+func remotePath(root, p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return path.Join(root, p)
+}
+
+// helper function returns the mirror url for the remote
+// repository url, if a mirror is configured for the remote
+// host. The mirror maps the host name to the base url of the
+// mirror, and the repository path is appended to the base
+// url. If no mirror is configured the remote url is returned.
+func cloneMirror(remote string, mirrors map[string]string) string {
+	uri, err := url.Parse(remote)
+	if err != nil || uri.Host == "" {
+		return remote
+	}
+	base, ok := mirrors[uri.Host]
+	if !ok || base == "" {
+		return remote
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(uri.Path, "/")
+}
+
+// helper function returns the commands that print the recent
+// macOS system log, and the crash reports created since the
+// pipeline started. Simulator and code signing failures are
+// often only reported in the system log.
+func systemLogCommands(last time.Duration, since string) []string {
+	return []string{
+		fmt.Sprintf("log show --last %ds --style compact 2>&1 | tail -n %d || true",
+			int(last.Seconds()), systemLogLines),
+		fmt.Sprintf("find ~/Library/Logs/DiagnosticReports /Library/Logs/DiagnosticReports -type f -newer %s 2>/dev/null | "+
+			"while read -r f; do echo \"--- $f\"; head -n %d \"$f\"; done || true",
+			since, crashReportLines),
+	}
 }
 
 // helper function returns true if the step is configured to
@@ -54,15 +263,77 @@ func isGraph(spec *engine.Spec) bool {
 }
 
 // helper function creates the dependency graph for serial
-// pipeline execution.
-func configureSerial(spec *engine.Spec) {
-	var prev *engine.Step
+// pipeline execution. Consecutive steps in the same parallel
+// group depend on the previous step, or on every step of the
+// previous group, and execute concurrently.
+func configureSerial(spec *engine.Spec, groups map[string]string) {
+	var prev []string
+	for i := 0; i < len(spec.Steps); {
+		j := i + 1
+		if group := groups[spec.Steps[i].Name]; group != "" {
+			for j < len(spec.Steps) && groups[spec.Steps[j].Name] == group {
+				j++
+			}
+		}
+		var names []string
+		for _, step := range spec.Steps[i:j] {
+			if len(prev) != 0 {
+				step.DependsOn = append([]string(nil), prev...)
+			}
+			names = append(names, step.Name)
+		}
+		prev = names
+		i = j
+	}
+}
+
+// helper function modifies the pipeline dependency graph to
+// replace dependencies on a parallel group with dependencies
+// on every step in the group.
+func configureGroupDeps(spec *engine.Spec, groups map[string]string) {
+	if len(groups) == 0 {
+		return
+	}
+	members := map[string][]string{}
+	for _, step := range spec.Steps {
+		if group := groups[step.Name]; group != "" {
+			members[group] = append(members[group], step.Name)
+		}
+	}
 	for _, step := range spec.Steps {
-		if prev != nil {
-			step.DependsOn = []string{prev.Name}
+		if len(step.DependsOn) == 0 {
+			continue
+		}
+		var deps []string
+		for _, dep := range step.DependsOn {
+			if names, ok := members[dep]; ok {
+				deps = append(deps, names...)
+			} else {
+				deps = append(deps, dep)
+			}
+		}
+		step.DependsOn = deps
+	}
+}
+
+// helper function returns the first variable name that would
+// override a variable set by the runner with a reserved prefix,
+// or an empty string if no reserved variable is overridden.
+// Variables with a reserved prefix that are not set by the
+// runner may be defined freely.
+func reservedName(names []string, system map[string]string, prefixes []string) string {
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := system[name]; !ok {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if prefix != "" && strings.HasPrefix(name, prefix) {
+				return name
+			}
 		}
-		prev = step
 	}
+	return ""
 }
 
 // helper function converts the environment variables to a map,
@@ -101,15 +372,137 @@ func convertSecretEnv(src map[string]*manifest.Variable) []*engine.Secret {
 	return dst
 }
 
+// helper function returns the secrets derived from the
+// environment variables, and the secrets imported by name.
+// Secrets imported by name are exposed as environment
+// variables with the same name, unless the environment
+// defines a variable with the same name.
+func convertSecrets(env map[string]*manifest.Variable, names []string) []*engine.Secret {
+	dst := convertSecretEnv(env)
+	for _, name := range names {
+		if _, ok := env[name]; ok {
+			continue
+		}
+		dst = append(dst, &engine.Secret{
+			Name: name,
+			Mask: true,
+			Env:  name,
+		})
+	}
+	return dst
+}
+
+// helper function converts the secret files to secrets that
+// are written to files on the virtual machine, relative to
+// the root.
+func convertSecretFiles(src []*resource.SecretFile, root string) []*engine.Secret {
+	var dst []*engine.Secret
+	for _, v := range src {
+		dst = append(dst, &engine.Secret{
+			Name:   v.Secret,
+			Mask:   true,
+			Path:   remotePath(root, v.Path),
+			Base64: v.Base64,
+		})
+	}
+	return dst
+}
+
+// helper function merges the pipeline secrets and the step
+// secrets. The step secrets take precedence over pipeline
+// secrets exposed as the same environment variable.
+func mergeSecrets(pipeline, step []*engine.Secret) []*engine.Secret {
+	dst := []*engine.Secret{}
+	for _, s := range pipeline {
+		if !hasSecretEnv(step, s.Env) {
+			dst = append(dst, s)
+		}
+	}
+	return append(dst, step...)
+}
+
+// helper function returns true if a secret is exposed as the
+// named environment variable.
+func hasSecretEnv(secrets []*engine.Secret, env string) bool {
+	for _, s := range secrets {
+		if s.Env == env {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the named secret may be
+// exposed to the build event. The policy maps secret name
+// patterns to a pipe-separated list of allowed events.
+// Secrets that do not match a pattern are not restricted.
+// If the secret matches multiple patterns, the event must be
+// allowed by every matching pattern, so that the result does
+// not depend on the order of the patterns.
+func secretAllowed(name, event string, policy map[string]string) bool {
+	for pattern, events := range policy {
+		if ok, _ := filepath.Match(pattern, name); !ok {
+			continue
+		}
+		if !eventAllowed(event, events) {
+			return false
+		}
+	}
+	return true
+}
+
+// helper function returns true if the event is included in
+// the pipe-separated list of events.
+func eventAllowed(event, events string) bool {
+	for _, allowed := range strings.Split(events, "|") {
+		if strings.TrimSpace(allowed) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns the name of the clone step for the
+// vm group, or the default clone step if the group is empty.
+func cloneName(group string) string {
+	if group == "" {
+		return "clone"
+	}
+	return "clone-" + group
+}
+
 // helper function modifies the pipeline dependency graph to
-// account for the clone step.
+// account for the clone step. Steps that execute on a separate
+// vm depend on the clone step for that vm.
 func configureCloneDeps(spec *engine.Spec) {
 	for _, step := range spec.Steps {
-		if step.Name == "clone" {
+		clone := cloneName(step.VM)
+		if step.Name == clone {
 			continue
 		}
 		if len(step.DependsOn) == 0 {
-			step.DependsOn = []string{"clone"}
+			step.DependsOn = []string{clone}
+		}
+	}
+}
+
+// helper function modifies the pipeline dependency graph to
+// account for the bundle step. Steps on the default vm that
+// depend on the clone step, or have no dependencies, depend on
+// the bundle step instead.
+func configureBundleDeps(spec *engine.Spec) {
+	for _, step := range spec.Steps {
+		if step.Name == "bundle" || step.Name == "clone" || step.VM != "" {
+			continue
+		}
+		if len(step.DependsOn) == 0 {
+			step.DependsOn = []string{"bundle"}
+			continue
+		}
+		for i, dep := range step.DependsOn {
+			if dep == "clone" {
+				step.DependsOn[i] = "bundle"
+			}
 		}
 	}
 }
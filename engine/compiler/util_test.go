@@ -81,7 +81,7 @@ func Test_configureSerial(t *testing.T) {
 		{Name: "test", DependsOn: []string{"build"}},
 		{Name: "deploy", DependsOn: []string{"test"}},
 	}
-	configureSerial(before)
+	configureSerial(before, nil)
 
 	opts := cmpopts.IgnoreUnexported(engine.Spec{})
 	if diff := cmp.Diff(before, after, opts); diff != "" {
@@ -90,6 +90,67 @@ func Test_configureSerial(t *testing.T) {
 	}
 }
 
+func Test_configureSerial_Parallel(t *testing.T) {
+	before := new(engine.Spec)
+	before.Steps = []*engine.Step{
+		{Name: "build"},
+		{Name: "shard-1"},
+		{Name: "shard-2"},
+		{Name: "lint"},
+		{Name: "deploy"},
+	}
+	groups := map[string]string{
+		"shard-1": "test",
+		"shard-2": "test",
+		"lint":    "check",
+	}
+
+	after := new(engine.Spec)
+	after.Steps = []*engine.Step{
+		{Name: "build"},
+		{Name: "shard-1", DependsOn: []string{"build"}},
+		{Name: "shard-2", DependsOn: []string{"build"}},
+		{Name: "lint", DependsOn: []string{"shard-1", "shard-2"}},
+		{Name: "deploy", DependsOn: []string{"lint"}},
+	}
+	configureSerial(before, groups)
+
+	opts := cmpopts.IgnoreUnexported(engine.Spec{})
+	if diff := cmp.Diff(before, after, opts); diff != "" {
+		t.Errorf("Unexpected serial configuration")
+		t.Log(diff)
+	}
+}
+
+func Test_configureGroupDeps(t *testing.T) {
+	before := new(engine.Spec)
+	before.Steps = []*engine.Step{
+		{Name: "clone"},
+		{Name: "shard-1", DependsOn: []string{"clone"}},
+		{Name: "shard-2", DependsOn: []string{"clone"}},
+		{Name: "deploy", DependsOn: []string{"test", "clone"}},
+	}
+	groups := map[string]string{
+		"shard-1": "test",
+		"shard-2": "test",
+	}
+
+	after := new(engine.Spec)
+	after.Steps = []*engine.Step{
+		{Name: "clone"},
+		{Name: "shard-1", DependsOn: []string{"clone"}},
+		{Name: "shard-2", DependsOn: []string{"clone"}},
+		{Name: "deploy", DependsOn: []string{"shard-1", "shard-2", "clone"}},
+	}
+	configureGroupDeps(before, groups)
+
+	opts := cmpopts.IgnoreUnexported(engine.Spec{})
+	if diff := cmp.Diff(before, after, opts); diff != "" {
+		t.Errorf("Unexpected group dependencies")
+		t.Log(diff)
+	}
+}
+
 func Test_convertStaticEnv(t *testing.T) {
 	vars := map[string]*manifest.Variable{
 		"username": {Value: "octocat"},
@@ -122,6 +183,111 @@ func Test_convertSecretEnv(t *testing.T) {
 	}
 }
 
+func Test_convertSecrets(t *testing.T) {
+	vars := map[string]*manifest.Variable{
+		"PASSWORD": {Secret: "password"},
+		"token":    {Value: "none"},
+	}
+	envs := convertSecrets(vars, []string{"username", "token"})
+	want := []*engine.Secret{
+		{
+			Name: "password",
+			Env:  "PASSWORD",
+			Mask: true,
+		},
+		{
+			Name: "username",
+			Env:  "username",
+			Mask: true,
+		},
+	}
+	if diff := cmp.Diff(envs, want); diff != "" {
+		t.Errorf("Unexpected secret list")
+		t.Log(diff)
+	}
+}
+
+func Test_secretAllowed(t *testing.T) {
+	policy := map[string]string{
+		"signing_*": "push|tag",
+		"npm_token": "tag",
+	}
+	tests := []struct {
+		name  string
+		event string
+		want  bool
+	}{
+		{"signing_cert", "push", true},
+		{"signing_cert", "tag", true},
+		{"signing_cert", "pull_request", false},
+		{"npm_token", "push", false},
+		{"npm_token", "tag", true},
+		{"docker_password", "pull_request", true},
+	}
+	for _, test := range tests {
+		if got := secretAllowed(test.name, test.event, policy); got != test.want {
+			t.Errorf("Want secret %s allowed %v for event %s", test.name, test.want, test.event)
+		}
+	}
+	if !secretAllowed("signing_cert", "pull_request", nil) {
+		t.Errorf("Want secrets unrestricted without a policy")
+	}
+}
+
+func Test_secretAllowed_Overlapping(t *testing.T) {
+	// the secret matches both patterns, and must only be
+	// exposed to events allowed by both patterns, regardless
+	// of the map iteration order.
+	policy := map[string]string{
+		"signing_*":    "push|tag|promote",
+		"signing_prod": "tag",
+		"*_prod":       "tag|promote",
+	}
+	tests := []struct {
+		name  string
+		event string
+		want  bool
+	}{
+		{"signing_prod", "tag", true},
+		{"signing_prod", "push", false},
+		{"signing_prod", "promote", false},
+		{"signing_dev", "push", true},
+		{"npm_prod", "promote", true},
+	}
+	for i := 0; i < 10; i++ {
+		for _, test := range tests {
+			if got := secretAllowed(test.name, test.event, policy); got != test.want {
+				t.Errorf("Want secret %s allowed %v for event %s", test.name, test.want, test.event)
+			}
+		}
+	}
+}
+
+func Test_convertSecretFiles(t *testing.T) {
+	files := []*resource.SecretFile{
+		{Secret: "signing_p12", Path: "certs/signing.p12", Base64: true},
+		{Secret: "profile", Path: "/tmp/app.mobileprovision"},
+	}
+	got := convertSecretFiles(files, "/Users/anka/drone/src")
+	want := []*engine.Secret{
+		{
+			Name:   "signing_p12",
+			Mask:   true,
+			Path:   "/Users/anka/drone/src/certs/signing.p12",
+			Base64: true,
+		},
+		{
+			Name: "profile",
+			Mask: true,
+			Path: "/tmp/app.mobileprovision",
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Unexpected secret files")
+		t.Log(diff)
+	}
+}
+
 func Test_configureCloneDeps(t *testing.T) {
 	before := new(engine.Spec)
 	before.Steps = []*engine.Step{
@@ -198,3 +364,108 @@ func Test_removeCloneDeps_CloneEnabled(t *testing.T) {
 		t.Log(diff)
 	}
 }
+
+func Test_selectImages(t *testing.T) {
+	images := map[string]string{
+		"arm64": "Ventura-arm64.img",
+		"amd64": "Ventura-amd64.img",
+	}
+	tests := []struct {
+		images    map[string]string
+		arch      string
+		preferred string
+		fallback  string
+	}{
+		{images, "", "Ventura-arm64.img", "Ventura-amd64.img"},
+		{images, "arm64", "Ventura-arm64.img", "Ventura-amd64.img"},
+		{images, "amd64", "Ventura-amd64.img", "Ventura-arm64.img"},
+		{map[string]string{"amd64": "Ventura-amd64.img"}, "arm64", "Ventura-amd64.img", ""},
+		{map[string]string{"arm64": "Ventura-arm64.img"}, "arm64", "Ventura-arm64.img", ""},
+	}
+	for _, test := range tests {
+		preferred, fallback := selectImages(test.images, test.arch)
+		if preferred != test.preferred || fallback != test.fallback {
+			t.Errorf("Want images %q, %q for arch %q, got %q, %q",
+				test.preferred, test.fallback, test.arch, preferred, fallback)
+		}
+	}
+}
+
+func Test_cloneMirror(t *testing.T) {
+	mirrors := map[string]string{
+		"github.com": "https://git-cache.company.com/github/",
+	}
+	tests := []struct {
+		remote string
+		want   string
+	}{
+		{"https://github.com/octocat/hello-world.git", "https://git-cache.company.com/github/octocat/hello-world.git"},
+		{"https://gitlab.com/octocat/hello-world.git", "https://gitlab.com/octocat/hello-world.git"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := cloneMirror(test.remote, mirrors); got != test.want {
+			t.Errorf("Want mirror %q for remote %q, got %q", test.want, test.remote, got)
+		}
+	}
+}
+
+func Test_reservedName(t *testing.T) {
+	system := map[string]string{
+		"DRONE_WORKSPACE": "/tmp/source",
+		"CI_COMMIT_SHA":   "a6586b3",
+		"HTTP_PROXY":      "http://proxy",
+	}
+	prefixes := []string{"DRONE_", "CI_"}
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"GOPATH", "DRONE_CUSTOM"}, ""},
+		{[]string{"HTTP_PROXY"}, ""},
+		{[]string{"GOPATH", "DRONE_WORKSPACE"}, "DRONE_WORKSPACE"},
+		{[]string{"DRONE_WORKSPACE", "CI_COMMIT_SHA"}, "CI_COMMIT_SHA"},
+	}
+	for _, test := range tests {
+		if got := reservedName(test.names, system, prefixes); got != test.want {
+			t.Errorf("Want reserved name %q for %v, got %q", test.want, test.names, got)
+		}
+	}
+	if got := reservedName([]string{"DRONE_WORKSPACE"}, system, nil); got != "" {
+		t.Errorf("Want no reserved name without prefixes, got %q", got)
+	}
+}
+
+func Test_matchNode(t *testing.T) {
+	labels := map[string]string{"cluster": "ams", "tier": "m1"}
+	tests := []struct {
+		node map[string]string
+		want bool
+	}{
+		{nil, true},
+		{map[string]string{"tier": "m1"}, true},
+		{map[string]string{"cluster": "ams", "tier": "m1"}, true},
+		{map[string]string{"tier": "intel"}, false},
+		{map[string]string{"region": "us"}, false},
+	}
+	for _, test := range tests {
+		if got := matchNode(test.node, labels); got != test.want {
+			t.Errorf("Want match %v for node %v, got %v", test.want, test.node, got)
+		}
+	}
+}
+
+func Test_localeEnviron(t *testing.T) {
+	got := localeEnviron("en_US.UTF-8")
+	want := map[string]string{
+		"LANG":   "en_US.UTF-8",
+		"LC_ALL": "en_US.UTF-8",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Unexpected locale environment")
+		t.Log(diff)
+	}
+	if got := localeEnviron(""); len(got) != 0 {
+		t.Errorf("Want empty environment when locale is empty")
+	}
+}
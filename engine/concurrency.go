@@ -0,0 +1,109 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// keyed limits the number of concurrent pipelines that share
+// a concurrency key. The limit of a key is defined by the
+// most recent pipeline that acquires the key, so that a
+// changed limit takes effect without waiting for the key to
+// become idle.
+type keyed struct {
+	sync.Mutex
+	limiters map[string]*keyedLimiter
+}
+
+// keyedLimiter limits the pipelines that share a key, and
+// counts the pipelines that hold or wait for the key, so
+// that the limiter is removed when the key becomes idle.
+type keyedLimiter struct {
+	*limiter
+	count int
+}
+
+// acquire blocks until a pipeline with the key may proceed,
+// or until the context is canceled. It returns true if the
+// key was acquired, in which case the key must be released
+// when the pipeline completes.
+func (k *keyed) acquire(ctx context.Context, key string, limit int) (bool, error) {
+	if key == "" || limit <= 0 {
+		return false, nil
+	}
+	k.Lock()
+	if k.limiters == nil {
+		k.limiters = map[string]*keyedLimiter{}
+	}
+	l, ok := k.limiters[key]
+	if !ok {
+		l = &keyedLimiter{limiter: newLimiter(limit)}
+		k.limiters[key] = l
+	}
+	l.count++
+	l.resize(limit)
+	k.Unlock()
+
+	if err := l.acquire(ctx); err != nil {
+		k.done(key)
+		return false, err
+	}
+	return true, nil
+}
+
+// release releases the key.
+func (k *keyed) release(key string) {
+	k.Lock()
+	l, ok := k.limiters[key]
+	k.Unlock()
+	if !ok {
+		return
+	}
+	l.release()
+	k.done(key)
+}
+
+// helper function decrements the number of pipelines that
+// hold or wait for the key, and removes the limiter when the
+// key becomes idle.
+func (k *keyed) done(key string) {
+	k.Lock()
+	defer k.Unlock()
+	l, ok := k.limiters[key]
+	if !ok {
+		return
+	}
+	l.count--
+	if l.count <= 0 {
+		delete(k.limiters, key)
+	}
+}
+
+// helper function acquires the concurrency key of the
+// pipeline, waiting no longer than the concurrency timeout.
+func (e *Engine) lock(ctx context.Context, spec *Spec) error {
+	timeout := spec.Settings.ConcurrencyTimeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	locked, err := e.keys.acquire(ctx,
+		spec.Settings.ConcurrencyKey,
+		spec.Settings.ConcurrencyLimit,
+	)
+	if err == context.DeadlineExceeded && timeout > 0 {
+		return fmt.Errorf("timed out after %s waiting for the concurrency key %q",
+			timeout, spec.Settings.ConcurrencyKey)
+	}
+	if err != nil {
+		return err
+	}
+	spec.locked = locked
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyed(t *testing.T) {
+	var k keyed
+	if _, err := k.acquire(noContext, "deploy", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second pipeline with the same key waits until the
+	// first pipeline releases the key.
+	ctx, cancel := context.WithTimeout(noContext, 10*time.Millisecond)
+	defer cancel()
+	if _, err := k.acquire(ctx, "deploy", 1); err != context.DeadlineExceeded {
+		t.Errorf("Want deadline exceeded, got %v", err)
+	}
+
+	// pipelines with a different key do not wait.
+	if _, err := k.acquire(noContext, "release", 1); err != nil {
+		t.Error(err)
+	}
+	k.release("release")
+
+	k.release("deploy")
+	if _, err := k.acquire(noContext, "deploy", 1); err != nil {
+		t.Error(err)
+	}
+	k.release("deploy")
+
+	if len(k.limiters) != 0 {
+		t.Errorf("Expect idle keys removed, got %d keys", len(k.limiters))
+	}
+}
+
+func TestKeyed_Resize(t *testing.T) {
+	var k keyed
+	if _, err := k.acquire(noContext, "deploy", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the limit of the most recent pipeline wins.
+	if _, err := k.acquire(noContext, "deploy", 2); err != nil {
+		t.Error(err)
+	}
+
+	ctx, cancel := context.WithTimeout(noContext, 10*time.Millisecond)
+	defer cancel()
+	if _, err := k.acquire(ctx, "deploy", 2); err != context.DeadlineExceeded {
+		t.Errorf("Want deadline exceeded, got %v", err)
+	}
+
+	k.release("deploy")
+	k.release("deploy")
+	if len(k.limiters) != 0 {
+		t.Errorf("Expect idle keys removed, got %d keys", len(k.limiters))
+	}
+}
+
+func TestKeyed_NoKey(t *testing.T) {
+	var k keyed
+	locked, err := k.acquire(noContext, "", 1)
+	if err != nil || locked {
+		t.Errorf("Expect no lock without a key")
+	}
+	k.release("")
+}
+
+func TestLock_Timeout(t *testing.T) {
+	e := new(Engine)
+	if _, err := e.keys.acquire(noContext, "testflight", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pipeline gives up waiting for the key when the
+	// concurrency timeout expires.
+	spec := &Spec{
+		Settings: Settings{
+			ConcurrencyKey:     "testflight",
+			ConcurrencyLimit:   1,
+			ConcurrencyTimeout: 10 * time.Millisecond,
+		},
+	}
+	err := e.lock(noContext, spec)
+	if err == nil {
+		t.Fatalf("Expect error when the concurrency key is not acquired before the timeout")
+	}
+	if want := `timed out after 10ms waiting for the concurrency key "testflight"`; err.Error() != want {
+		t.Errorf("Want error %q, got %q", want, err)
+	}
+	if spec.locked {
+		t.Errorf("Expect the concurrency key not locked")
+	}
+
+	e.keys.release("testflight")
+	if err := e.lock(noContext, spec); err != nil {
+		t.Error(err)
+	}
+	if !spec.locked {
+		t.Errorf("Expect the concurrency key locked")
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/drone/runner-go/logger"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// helper function fetches the coverage files that match the
+// step coverage patterns and uploads the files to the
+// coverage destination. Errors are logged and written to the
+// output, and do not fail the step.
+func (e *Engine) collectCoverage(ctx context.Context, client *ssh.Client, step *Step, output io.Writer) {
+	log := logger.FromContext(ctx).WithField("step", step.Name)
+
+	if e.opts.Coverage == nil {
+		fmt.Fprintln(output, "\ncoverage destination is not configured, skipping upload")
+		return
+	}
+
+	clientftp, err := newSFTP(client, e.sftpOptions()...)
+	if err != nil {
+		log.WithError(err).Debug("cannot create sftp client to fetch coverage")
+		fmt.Fprintf(output, "\ncannot fetch coverage: %s\n", err)
+		return
+	}
+	defer clientftp.Close()
+
+	paths := glob(ctx, clientftp, step.Coverage)
+	if len(paths) == 0 {
+		fmt.Fprintln(output, "\nno coverage files found")
+		return
+	}
+	for _, p := range paths {
+		f, err := clientftp.Open(p)
+		if err != nil {
+			log.WithError(err).WithField("path", p).Debug("cannot open coverage file")
+			continue
+		}
+		name := coverageName(step, p)
+		err = streamMasked(ctx, e.opts.Coverage, name, f, step.Secrets)
+		f.Close()
+		if err != nil {
+			log.WithError(err).WithField("path", p).Warn("cannot upload coverage file")
+			fmt.Fprintf(output, "\ncannot upload coverage file %s: %s\n", p, err)
+			continue
+		}
+		fmt.Fprintf(output, "\nuploaded coverage file %s\n", name)
+	}
+}
+
+// helper function returns the artifact name of the coverage
+// file, or other step artifact, which is scoped to the
+// repository, build, stage and step.
+func coverageName(step *Step, file string) string {
+	return artifactName(
+		step.Envs["DRONE_REPO"],
+		step.Envs["DRONE_BUILD_NUMBER"],
+		step.Envs["DRONE_STAGE_NAME"],
+		step.Name,
+		path.Base(file),
+	)
+}
+
+// helper function returns the artifact name scoped to the
+// repository. The remaining name segments are user defined,
+// and are sanitized so that the name cannot escape the
+// repository prefix.
+func artifactName(repo string, elem ...string) string {
+	var parts []string
+	for _, s := range strings.Split(repo, "/") {
+		if s != "" {
+			parts = append(parts, artifactSegment(s))
+		}
+	}
+	for _, s := range elem {
+		if s != "" {
+			parts = append(parts, artifactSegment(s))
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// helper function returns the name segment with path
+// separators and control characters replaced, and relative
+// path segments renamed.
+func artifactSegment(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < 0x20 {
+			return '-'
+		}
+		return r
+	}, s)
+	if s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCoverageName(t *testing.T) {
+	step := &Step{
+		Name: "test",
+		Envs: map[string]string{
+			"DRONE_REPO":         "octocat/hello-world",
+			"DRONE_BUILD_NUMBER": "42",
+			"DRONE_STAGE_NAME":   "default",
+		},
+	}
+	got := coverageName(step, "/tmp/source/build/coverage/lcov.info")
+	want := "octocat/hello-world/42/default/test/lcov.info"
+	if got != want {
+		t.Errorf("Want coverage name %q, got %q", want, got)
+	}
+}
+
+func TestCoverageName_Traversal(t *testing.T) {
+	tests := []struct {
+		stage, step, file string
+		want              string
+	}{
+		{"..", "..", "lcov.info", "octocat/hello-world/42/_/_/lcov.info"},
+		{"../../other", "test", "lcov.info", "octocat/hello-world/42/..-..-other/test/lcov.info"},
+		{"default", `..\..`, "..", "octocat/hello-world/42/default/..-../_"},
+		{"default", ".", "/", "octocat/hello-world/42/default/_/-"},
+	}
+	for _, test := range tests {
+		step := &Step{
+			Name: test.step,
+			Envs: map[string]string{
+				"DRONE_REPO":         "octocat/hello-world",
+				"DRONE_BUILD_NUMBER": "42",
+				"DRONE_STAGE_NAME":   test.stage,
+			},
+		}
+		got := coverageName(step, test.file)
+		if got != test.want {
+			t.Errorf("Want coverage name %q, got %q", test.want, got)
+		}
+		if !strings.HasPrefix(got, "octocat/hello-world/42/") {
+			t.Errorf("Expect coverage name %q scoped to the repository", got)
+		}
+	}
+}
+
+func TestStreamMasked(t *testing.T) {
+	secrets := []*Secret{
+		{Name: "token", Data: []byte("correct-horse"), Mask: true},
+	}
+	u := new(mockUploader)
+	err := streamMasked(noContext, u, "lcov.info", strings.NewReader("SF:correct-horse.swift\n"), secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := u.data, "SF:******.swift\n"; got != want {
+		t.Errorf("Want masked artifact %q, got %q", want, got)
+	}
+	if got, want := u.size, int64(len(u.data)); got != want {
+		t.Errorf("Want artifact size %d, got %d", want, got)
+	}
+}
+
+// mockUploader records the streamed artifact.
+type mockUploader struct {
+	name string
+	data string
+	size int64
+}
+
+func (u *mockUploader) Upload(ctx context.Context, name string, data []byte) error {
+	u.name, u.data, u.size = name, string(data), int64(len(data))
+	return nil
+}
+
+func (u *mockUploader) Stream(ctx context.Context, name string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	u.name, u.data, u.size = name, string(data), size
+	return err
+}
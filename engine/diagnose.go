@@ -0,0 +1,153 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+	"github.com/drone/runner-go/logger"
+)
+
+// describeTimeout limits the duration of the orka requests
+// used to describe the virtual machine.
+const describeTimeout = time.Second * 30
+
+// sysdiagnoseTimeout limits the duration of the sysdiagnose
+// collection, which can take several minutes.
+const sysdiagnoseTimeout = time.Minute * 15
+
+// sysdiagnose bundle location on the virtual machine.
+const (
+	sysdiagnoseDir  = "/tmp/diagnostics"
+	sysdiagnoseName = "sysdiagnose"
+)
+
+// helper function collects a sysdiagnose bundle from the
+// virtual machine and uploads the bundle to the diagnostics
+// destination. Errors are logged and ignored, since they
+// should not prevent the virtual machine from being deleted.
+func (e *Engine) sysdiagnose(ctx context.Context, spec *Spec) {
+	log := logger.FromContext(ctx).WithField("id", spec.Name)
+
+	if e.opts.Diagnostics == nil {
+		log.Warn("cannot collect sysdiagnose, diagnostics destination is not configured")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sysdiagnoseTimeout)
+	defer cancel()
+
+	client, err := e.dial(ctx, spec)
+	if err != nil {
+		log.WithError(err).Error("cannot dial the vm to collect sysdiagnose")
+		return
+	}
+	defer client.Close()
+	defer closeOnCancel(ctx, client)()
+
+	log.Info("collecting sysdiagnose")
+	out, err := execute(client, sysdiagnoseCommand(sysdiagnoseDir, sysdiagnoseName))
+	if err != nil {
+		log.WithError(err).
+			WithField("output", string(out)).
+			Error("cannot collect sysdiagnose")
+		return
+	}
+
+	clientftp, err := newSFTP(client, e.sftpOptions()...)
+	if err != nil {
+		log.WithError(err).Error("cannot create sftp client to fetch sysdiagnose")
+		return
+	}
+	defer clientftp.Close()
+
+	// the bundle can be several hundred megabytes, and is
+	// streamed to the diagnostics destination instead of
+	// being buffered in memory.
+	f, err := clientftp.Open(path.Join(sysdiagnoseDir, sysdiagnoseName+".tar.gz"))
+	if err != nil {
+		log.WithError(err).Error("cannot open sysdiagnose bundle")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.WithError(err).Error("cannot stat sysdiagnose bundle")
+		return
+	}
+
+	name := artifactName(artifactPrefix(spec), spec.Name+"-sysdiagnose.tar.gz")
+	if err := e.opts.Diagnostics.Stream(ctx, name, f, info.Size()); err != nil {
+		log.WithError(err).Error("cannot upload sysdiagnose bundle")
+		return
+	}
+	log.WithField("name", name).Info("uploaded sysdiagnose bundle")
+}
+
+// helper function returns the artifact name prefix for the
+// pipeline, which is scoped to the repository, build and
+// stage.
+func artifactPrefix(spec *Spec) string {
+	if len(spec.Steps) == 0 {
+		return ""
+	}
+	envs := spec.Steps[0].Envs
+	return artifactName(
+		envs["DRONE_REPO"],
+		envs["DRONE_BUILD_NUMBER"],
+		envs["DRONE_STAGE_NAME"],
+	)
+}
+
+// helper function returns a description of the virtual
+// machine and node status reported by orka, used to explain
+// why the virtual machine is unreachable. Errors are ignored,
+// since the description is best effort.
+func (e *Engine) describe(spec *Spec) string {
+	// the pipeline context may already be expired when the
+	// virtual machine is unreachable.
+	ctx, cancel := context.WithTimeout(noContext, describeTimeout)
+	defer cancel()
+
+	res, err := e.client.Check(ctx, spec.Name)
+	if err != nil {
+		return fmt.Sprintf("cannot get vm status: %s", err)
+	}
+	nodes := map[string]*orka.Node{}
+	if list, err := e.client.Nodes(ctx); err == nil {
+		for _, node := range list.Nodes {
+			nodes[node.Name] = node
+		}
+	}
+
+	var parts []string
+	for _, vm := range res.VirtualMachineResources {
+		for _, status := range vm.Status {
+			s := fmt.Sprintf("vm status %q, deployment status %q, node %s status %q",
+				status.VMStatus,
+				vm.VMDeploymentStatus,
+				status.NodeLocation,
+				status.NodeStatus,
+			)
+			if node, ok := nodes[status.NodeLocation]; ok {
+				s += fmt.Sprintf(" state %q with %d of %d cpu available",
+					node.State,
+					node.AvailableCPU,
+					node.TotalCPU,
+				)
+			}
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) == 0 {
+		return "vm is not deployed"
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/h2non/gock"
+)
+
+func TestDescribe(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://orka.company.com").
+		Get("/resources/vm/status/drone-abc123").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"virtual_machine_resources": []interface{}{
+				map[string]interface{}{
+					"virtual_machine_name": "drone-abc123",
+					"vm_deployment_status": "Deployed",
+					"status": []interface{}{
+						map[string]interface{}{
+							"node_location": "macpro-1",
+							"node_status":   "UP",
+							"vm_status":     "Pending",
+						},
+					},
+				},
+			},
+		})
+
+	gock.New("http://orka.company.com").
+		Get("/resources/node/list").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"nodes": []interface{}{
+				map[string]interface{}{
+					"name":          "macpro-1",
+					"state":         "READY",
+					"available_cpu": 0,
+					"total_cpu":     12,
+				},
+			},
+		})
+
+	e := &Engine{client: &orka.Client{Endpoint: "http://orka.company.com"}}
+	got := e.describe(&Spec{Name: "drone-abc123"})
+	want := `vm status "Pending", deployment status "Deployed", node macpro-1 status "UP" state "READY" with 0 of 12 cpu available`
+	if got != want {
+		t.Errorf("Want description %q, got %q", want, got)
+	}
+}
+
+func TestArtifactPrefix(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{
+				Envs: map[string]string{
+					"DRONE_REPO":         "octocat/hello-world",
+					"DRONE_BUILD_NUMBER": "42",
+					"DRONE_STAGE_NAME":   "default",
+				},
+			},
+		},
+	}
+	if got, want := artifactPrefix(spec), "octocat/hello-world/42/default"; got != want {
+		t.Errorf("Want artifact prefix %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/drone/runner-go/logger"
+)
+
+// DryRun verifies the pipeline vms can be provisioned,
+// without deploying the vms. The image, and the fallback
+// image if any, must exist, the nodes with the platform tag
+// must have capacity for the requested cpu count, with the
+// preferred or the fallback image, and the vm configuration
+// must be accepted by orka. The vm configuration is deleted
+// immediately. The dry run is used by the doctor command and
+// the engine dry run mode; the validate command only lints
+// the configuration file, and does not connect to orka.
+func (e *Engine) DryRun(ctx context.Context, spec *Spec) error {
+	for _, s := range append([]*Spec{spec}, spec.Groups...) {
+		if err := e.dryRun(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function verifies the vm can be provisioned.
+func (e *Engine) dryRun(ctx context.Context, spec *Spec) error {
+	log := logger.FromContext(ctx).
+		WithField("id", spec.Name).
+		WithField("image", spec.Settings.Image)
+
+	images, err := e.client.Images(ctx)
+	if err != nil {
+		return err
+	}
+	if !hasImage(images.Images, spec.Settings.Image) {
+		return fmt.Errorf("image %q not found", spec.Settings.Image)
+	}
+	fallback := spec.Settings.FallbackImage
+	if fallback != "" && !hasImage(images.Images, fallback) {
+		return fmt.Errorf("fallback image %q not found", fallback)
+	}
+
+	// the pipeline falls back to the alternate image if the
+	// preferred image cannot be deployed, and only requires
+	// capacity for one of the images.
+	nodes, err := e.client.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fitsTag(nodes, spec.Settings.Compute, spec.Settings.Tag):
+	case fallback != "" && fitsTag(nodes, spec.Settings.Compute, spec.Settings.FallbackTag):
+		log.WithField("fallback", fallback).
+			Debug("dry run: insufficient capacity for the image, the fallback image fits")
+	case spec.Settings.Tag != "":
+		return fmt.Errorf("insufficient cluster capacity for %d cpu on nodes tagged %q", spec.Settings.Compute, spec.Settings.Tag)
+	default:
+		return fmt.Errorf("insufficient cluster capacity for %d cpu", spec.Settings.Compute)
+	}
+
+	_, err = e.client.Create(ctx, vmConfig(spec))
+	if err != nil {
+		log.WithError(err).Debug("dry run: failed to create the vm config")
+		return err
+	}
+	log.Debug("dry run: created the vm config")
+
+	// the vm configuration is deleted even if the context
+	// is canceled.
+	_, err = e.client.Delete(noContext, spec.Name)
+	if err != nil {
+		log.WithError(err).Warn("dry run: failed to delete the vm config")
+	}
+	return err
+}
+
+// helper function returns true if a vm with the requested
+// cpu count can be deployed to a ready node with the tag.
+// If no node reports tags, as with older orka versions, all
+// nodes are considered.
+func fitsTag(nodes *orka.NodesResponse, cpu int, tag string) bool {
+	if tag == "" || !reportsTags(nodes.Nodes) {
+		return nodes.Fits(cpu)
+	}
+	return nodes.Tagged(tag).Fits(cpu)
+}
+
+// helper function returns true if the image is in the list.
+func hasImage(images []string, image string) bool {
+	for _, name := range images {
+		if name == image {
+			return true
+		}
+	}
+	return false
+}
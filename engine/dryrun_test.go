@@ -0,0 +1,170 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/h2non/gock"
+)
+
+func TestDryRun(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://orka.company.com").
+		Get("/resources/image/list").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"images": []string{"ventura-xcode-14.img"},
+		})
+
+	gock.New("http://orka.company.com").
+		Get("/resources/node/list").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"nodes": []interface{}{
+				map[string]interface{}{"name": "macpro-1", "available_cpu": 12, "state": "READY"},
+			},
+		})
+
+	gock.New("http://orka.company.com").
+		Post("/resources/vm/create").
+		Reply(200).
+		JSON(map[string]interface{}{})
+
+	gock.New("http://orka.company.com").
+		Delete("/resources/vm/purge").
+		MatchType("json").
+		JSON(map[string]string{"orka_vm_name": "drone-abc123"}).
+		Reply(200).
+		JSON(map[string]interface{}{})
+
+	e := &Engine{client: &orka.Client{Endpoint: "http://orka.company.com"}}
+	spec := &Spec{
+		Name:     "drone-abc123",
+		Settings: Settings{Image: "ventura-xcode-14.img", Compute: 6},
+	}
+	if err := e.DryRun(noContext, spec); err != nil {
+		t.Error(err)
+	}
+	if gock.IsPending() {
+		t.Errorf("Expect the vm config created and deleted")
+	}
+}
+
+func TestDryRun_ImageNotFound(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://orka.company.com").
+		Get("/resources/image/list").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"images": []string{"monterey-xcode-13.img"},
+		})
+
+	e := &Engine{client: &orka.Client{Endpoint: "http://orka.company.com"}}
+	spec := &Spec{
+		Name:     "drone-abc123",
+		Settings: Settings{Image: "ventura-xcode-14.img", Compute: 6},
+	}
+	err := e.DryRun(noContext, spec)
+	if err == nil {
+		t.Fatalf("Expect error when the image does not exist")
+	}
+	if got, want := err.Error(), `image "ventura-xcode-14.img" not found`; got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+}
+
+func TestDryRun_Tag(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://orka.company.com").
+		Get("/resources/image/list").
+		Times(2).
+		Reply(200).
+		JSON(map[string]interface{}{
+			"images": []string{"sonoma-xcode-15.img", "ventura-xcode-14.img"},
+		})
+
+	gock.New("http://orka.company.com").
+		Get("/resources/node/list").
+		Times(2).
+		Reply(200).
+		JSON(map[string]interface{}{
+			"nodes": []interface{}{
+				map[string]interface{}{"name": "macmini-1", "available_cpu": 4, "state": "READY", "orka_tags": []string{"arm64"}},
+				map[string]interface{}{"name": "macpro-1", "available_cpu": 12, "state": "READY", "orka_tags": []string{"amd64"}},
+			},
+		})
+
+	gock.New("http://orka.company.com").
+		Post("/resources/vm/create").
+		Reply(200).
+		JSON(map[string]interface{}{})
+
+	gock.New("http://orka.company.com").
+		Delete("/resources/vm/purge").
+		Reply(200).
+		JSON(map[string]interface{}{})
+
+	e := &Engine{client: &orka.Client{Endpoint: "http://orka.company.com"}}
+
+	// the arm64 nodes do not have capacity, and the vm can
+	// only be deployed with the fallback image.
+	spec := &Spec{
+		Name: "drone-abc123",
+		Settings: Settings{
+			Image:         "sonoma-xcode-15.img",
+			Tag:           "arm64",
+			FallbackImage: "ventura-xcode-14.img",
+			FallbackTag:   "amd64",
+			Compute:       6,
+		},
+	}
+	if err := e.DryRun(noContext, spec); err != nil {
+		t.Error(err)
+	}
+
+	spec.Settings.FallbackImage = ""
+	spec.Settings.FallbackTag = ""
+	err := e.DryRun(noContext, spec)
+	if err == nil {
+		t.Fatalf("Expect error when the tagged nodes do not have capacity")
+	}
+	if got, want := err.Error(), `insufficient cluster capacity for 6 cpu on nodes tagged "arm64"`; got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+}
+
+func TestDryRun_FallbackNotFound(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://orka.company.com").
+		Get("/resources/image/list").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"images": []string{"sonoma-xcode-15.img"},
+		})
+
+	e := &Engine{client: &orka.Client{Endpoint: "http://orka.company.com"}}
+	spec := &Spec{
+		Name: "drone-abc123",
+		Settings: Settings{
+			Image:         "sonoma-xcode-15.img",
+			FallbackImage: "ventura-xcode-14.img",
+			Compute:       6,
+		},
+	}
+	err := e.DryRun(noContext, spec)
+	if err == nil {
+		t.Fatalf("Expect error when the fallback image does not exist")
+	}
+	if got, want := err.Error(), `fallback image "ventura-xcode-14.img" not found`; got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka/orkatest"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSetup_InsufficientCapacity(t *testing.T) {
+	server := orkatest.NewServer()
+	defer server.Close()
+	server.Fail(orkatest.EndpointDeploy, 1, orka.ErrInsufficientCPU.Error())
+
+	e, err := New(server.Client(), Opts{FailFast: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &Spec{
+		Name:     "drone-abc123",
+		Settings: Settings{Image: "ventura-xcode-14.img", Compute: 12},
+	}
+	if err := e.Setup(noContext, spec); err != ErrInsufficientCapacity {
+		t.Errorf("Want insufficient capacity error, got %v", err)
+	}
+	if err := e.Destroy(noContext, spec); err != nil {
+		t.Error(err)
+	}
+	if vms := server.VMs(); len(vms) != 0 {
+		t.Errorf("Expect undeployed vm config purged, got %v", vms)
+	}
+}
+
+func TestCreate_DialFailure(t *testing.T) {
+	server := orkatest.NewServer()
+	defer server.Close()
+
+	// the setup slot is held while the vm is created, and
+	// must not be acquired again when the vm is discarded.
+	e, err := New(server.Client(), Opts{
+		MaxSetup: 1,
+		Faults:   Faults{Dial: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &Spec{
+		Name:     "drone-abc123",
+		Repo:     "octocat/dial-failure",
+		Settings: Settings{Image: "ventura-xcode-14.img", Compute: 4},
+	}
+	if _, err := e.client.Create(noContext, &orka.Config{Name: spec.Name, CPU: 4}); err != nil {
+		t.Fatal(err)
+	}
+	spec.created = true
+
+	ctx, cancel := context.WithTimeout(noContext, time.Millisecond*100)
+	defer cancel()
+	if _, err := e.createRetry(ctx, spec); err == nil {
+		t.Errorf("Expect error when the vm cannot be dialed")
+	}
+	if server.Deployed(spec.Name) {
+		t.Errorf("Expect the unreachable vm deleted")
+	}
+
+	ctx, cancel = context.WithTimeout(noContext, time.Second)
+	defer cancel()
+	if err := e.setups.acquire(ctx); err != nil {
+		t.Errorf("Expect the setup slot released, got %v", err)
+	}
+}
+
+func TestCreate_DialFailureUsage(t *testing.T) {
+	server := orkatest.NewServer()
+	defer server.Close()
+
+	e, err := New(server.Client(), Opts{Faults: Faults{Dial: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &Spec{
+		Name:     "drone-abc123",
+		Repo:     "octocat/dial-failure-usage",
+		Settings: Settings{Image: "ventura-xcode-14.img", Compute: 4},
+	}
+	if _, err := e.client.Create(noContext, &orka.Config{Name: spec.Name, CPU: 4}); err != nil {
+		t.Fatal(err)
+	}
+	spec.created = true
+
+	ctx, cancel := context.WithTimeout(noContext, time.Millisecond*100)
+	defer cancel()
+	if _, err := e.createRetry(ctx, spec); err == nil {
+		t.Errorf("Expect error when the vm cannot be dialed")
+	}
+
+	// the vm is purged when the dial fails, and must not
+	// be deleted, or its usage recorded, again when the
+	// pipeline is destroyed.
+	if err := e.Destroy(noContext, spec); err != nil {
+		t.Error(err)
+	}
+	if got := UsageByRepo()[spec.Repo].VMs; got != 1 {
+		t.Errorf("Want usage recorded once, got %d", got)
+	}
+}
+
+func TestBake_RotatePassword(t *testing.T) {
+	server := orkatest.NewServer()
+	defer server.Close()
+
+	hostKey, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := make(chan string, 10)
+	addr := serveCommands(t, hostKey, "admin", "rotated", commands)
+
+	e, err := New(server.Client(), Opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &Spec{
+		Name: "drone-abc123",
+		Settings: Settings{
+			Image:          "ventura-xcode-14.img",
+			Username:       "admin",
+			Password:       "admin",
+			RotatePassword: true,
+		},
+		ip:       addr,
+		password: "rotated",
+	}
+	if _, err := e.client.Create(noContext, vmConfig(spec)); err != nil {
+		t.Fatal(err)
+	}
+	step := &Step{Bake: "xcode15-20240601120000.img"}
+	state, err := e.bake(noContext, spec, step, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ExitCode != 0 {
+		t.Errorf("Want bake exit code 0, got %d", state.ExitCode)
+	}
+	close(commands)
+
+	var got []string
+	for cmd := range commands {
+		got = append(got, cmd)
+	}
+	want := []string{
+		passwordCommand("admin", "rotated", "admin"),
+		"sync",
+		passwordCommand("admin", "admin", "rotated"),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Expect the image password restored before the image is saved")
+		t.Log(diff)
+	}
+}
+
+// helper function starts an ssh server that accepts a single
+// connection authenticated with the password, and sends the
+// commands executed in each session to the channel.
+func serveCommands(t *testing.T, hostKey ssh.Signer, username, password string, commands chan<- string) string {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == username && string(pass) == password {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for ch := range chans {
+			channel, creqs, err := ch.Accept()
+			if err != nil {
+				continue
+			}
+			for req := range creqs {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				ssh.Unmarshal(req.Payload, &payload)
+				commands <- payload.Command
+				req.Reply(true, nil)
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				channel.Close()
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
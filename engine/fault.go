@@ -0,0 +1,85 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/drone/runner-go/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// Faults configures synthetic failures injected by the
+// engine, to verify the retry and cleanup behavior before
+// relying on it in production. Each value is the probability,
+// between 0 and 1, that the failure is injected.
+type Faults struct {
+	// Deploy fails the vm deployment.
+	Deploy float64
+
+	// Dial fails an ssh dial attempt with a timeout.
+	Dial float64
+
+	// Session closes the ssh connection while a step is
+	// running, after a random delay.
+	Session float64
+}
+
+// errors returned for injected failures.
+var (
+	errFaultDeploy = errors.New("fault injection: deploy failed")
+	errFaultDial   = errors.New("fault injection: ssh dial timeout")
+)
+
+// maximum delay before the ssh connection of a running step
+// is closed.
+const maxFaultDelay = 30 * time.Second
+
+// helper function returns an error if a fault probability
+// is not between 0 and 1.
+func validateFaults(f Faults) error {
+	for _, p := range []float64{f.Deploy, f.Dial, f.Session} {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("invalid fault probability: %v", p)
+		}
+	}
+	return nil
+}
+
+// helper function returns true if a fault with the
+// probability is injected.
+func inject(p float64) bool {
+	return p > 0 && rand.Float64() < p
+}
+
+// helper function deploys the vm, or injects a deploy
+// failure.
+func (e *Engine) deployNode(ctx context.Context, spec *Spec, node string) (*orka.DeployResponse, error) {
+	if inject(e.opts.Faults.Deploy) {
+		logger.FromContext(ctx).
+			WithField("id", spec.Name).
+			Warn("fault injection: failing the deploy")
+		return nil, errFaultDeploy
+	}
+	return e.client.DeployMetadata(ctx, spec.Name, spec.Settings.Tag, node, e.metadata(spec))
+}
+
+// helper function closes the ssh connection after a random
+// delay, unless the returned timer is stopped.
+func dropAfter(ctx context.Context, client *ssh.Client) *time.Timer {
+	delay := time.Duration(rand.Int63n(int64(maxFaultDelay)))
+	return time.AfterFunc(delay, func() {
+		logger.FromContext(ctx).
+			WithField("delay", delay).
+			Warn("fault injection: dropping the ssh session")
+		client.Close()
+	})
+}
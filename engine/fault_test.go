@@ -0,0 +1,46 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+)
+
+func TestValidateFaults(t *testing.T) {
+	if err := validateFaults(Faults{Deploy: 0.5, Dial: 1}); err != nil {
+		t.Error(err)
+	}
+	if err := validateFaults(Faults{Session: 1.5}); err == nil {
+		t.Errorf("Expect error for a probability greater than one")
+	}
+	if err := validateFaults(Faults{Dial: -1}); err == nil {
+		t.Errorf("Expect error for a negative probability")
+	}
+}
+
+func TestInject(t *testing.T) {
+	if inject(0) {
+		t.Errorf("Expect no fault injected with a zero probability")
+	}
+	if !inject(1) {
+		t.Errorf("Expect fault injected with a probability of one")
+	}
+}
+
+func TestDeployNode_Fault(t *testing.T) {
+	e := &Engine{opts: Opts{Faults: Faults{Deploy: 1}}}
+	_, err := e.deployNode(noContext, &Spec{Name: "drone-abc123"}, "")
+	if err != errFaultDeploy {
+		t.Errorf("Want injected deploy failure, got %v", err)
+	}
+}
+
+func TestDial_Fault(t *testing.T) {
+	e := &Engine{opts: Opts{Faults: Faults{Dial: 1}}}
+	_, err := e.dial(noContext, &Spec{Name: "drone-abc123"})
+	if err != errFaultDial {
+		t.Errorf("Want injected dial failure, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseHostKeys(t *testing.T) {
+	first, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("# base image host keys\n\n")
+	buf.Write(ssh.MarshalAuthorizedKey(first.PublicKey()))
+	buf.Write(ssh.MarshalAuthorizedKey(second.PublicKey()))
+	buf.WriteString("# trailing comment\n")
+
+	keys, err := ParseHostKeys(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(keys), 2; got != want {
+		t.Fatalf("Want %d host keys, got %d", want, got)
+	}
+
+	verify := hostKeysCallback(keys)
+	for _, key := range []ssh.Signer{first, second} {
+		if err := verify("", nil, key.PublicKey()); err != nil {
+			t.Errorf("Expect configured host key accepted, got %s", err)
+		}
+	}
+	if err := verify("", nil, other.PublicKey()); err == nil {
+		t.Errorf("Expect unknown host key rejected")
+	}
+}
+
+func TestParseHostKeys_Empty(t *testing.T) {
+	if _, err := ParseHostKeys([]byte("# no keys\n")); err == nil {
+		t.Errorf("Expect error when no host keys are found")
+	}
+}
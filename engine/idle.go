@@ -0,0 +1,76 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// idleWriter records the time of the most recent write to the
+// underlying writer, used to detect steps that stop producing
+// output.
+type idleWriter struct {
+	w io.Writer
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// newIdleWriter returns a new idle writer.
+func newIdleWriter(w io.Writer) *idleWriter {
+	return &idleWriter{w: w, last: time.Now()}
+}
+
+// Write writes to the underlying writer and records the time
+// of the write.
+func (w *idleWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.last = time.Now()
+	w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// idle returns the duration since the most recent write.
+func (w *idleWriter) idle() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return time.Since(w.last)
+}
+
+// watch returns a channel that is closed when no output is
+// written for the duration of the timeout. The watch stops
+// when the context is canceled.
+func (w *idleWriter) watch(ctx context.Context, timeout time.Duration) <-chan struct{} {
+	interval := timeout / 10
+	if interval > time.Second*10 {
+		interval = time.Second * 10
+	}
+	// the interval is bounded to prevent busy polling, and
+	// to prevent the ticker from panicking if the timeout is
+	// less than 10ns.
+	if interval < time.Second {
+		interval = time.Second
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if w.idle() >= timeout {
+					close(done)
+					return
+				}
+			}
+		}
+	}()
+	return done
+}
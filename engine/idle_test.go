@@ -0,0 +1,39 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestIdleWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := newIdleWriter(ioutil.Discard)
+	idle := w.watch(ctx, time.Millisecond*100)
+
+	// writing output resets the idle timer.
+	for i := 0; i < 5; i++ {
+		time.Sleep(time.Millisecond * 40)
+		w.Write([]byte("hello"))
+		select {
+		case <-idle:
+			t.Errorf("Want watch not triggered while writing output")
+			return
+		default:
+		}
+	}
+
+	// the watch polls at most once per second.
+	select {
+	case <-idle:
+	case <-time.After(time.Second * 3):
+		t.Errorf("Want watch triggered when no output is written")
+	}
+}
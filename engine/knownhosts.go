@@ -0,0 +1,23 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "fmt"
+
+// DefaultKnownHosts provides the published ssh host keys of
+// common git providers.
+const DefaultKnownHosts = `github.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl
+gitlab.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAfuCHKVTjquxvt6CM6tdG4SLp1Btn/nOeHHE5UOzRdf
+bitbucket.org ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIazEu89wgQZ4bqs3d63QSMzYVa0MuJ2e2gKTKqu+UUO
+`
+
+// helper function returns a shell command that appends the
+// known hosts to the known_hosts file of the build user, so
+// that ssh connections to the hosts do not prompt to verify
+// the host key.
+func knownHostsCommand(hosts []byte) string {
+	return fmt.Sprintf(`mkdir -p "$HOME/.ssh" && chmod 700 "$HOME/.ssh" && printf '%%s\n' %s >> "$HOME/.ssh/known_hosts" && chmod 600 "$HOME/.ssh/known_hosts"`,
+		quote(string(hosts)))
+}
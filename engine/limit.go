@@ -0,0 +1,86 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"sync"
+)
+
+// limiter bounds the number of concurrent operations. The
+// limit may be changed while operations are in progress. A
+// nil limiter does not limit concurrency.
+type limiter struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+
+	// changed is closed and replaced when an operation is
+	// released or the limit is changed, to wake operations
+	// waiting to acquire.
+	changed chan struct{}
+}
+
+// newLimiter returns a limiter that allows n concurrent
+// operations. If n is zero or less, concurrency is not
+// limited.
+func newLimiter(n int) *limiter {
+	return &limiter{limit: n, changed: make(chan struct{})}
+}
+
+// acquire blocks until an operation may proceed, or until
+// the context is canceled.
+func (l *limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		if l.limit <= 0 || l.active < l.limit {
+			l.active++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release releases the operation.
+func (l *limiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	if l.active > 0 {
+		l.active--
+	}
+	l.notify()
+	l.mu.Unlock()
+}
+
+// resize changes the limit. If the limit is lowered below the
+// number of operations in progress, the operations proceed,
+// and waiting operations are blocked until enough operations
+// are released.
+func (l *limiter) resize(n int) {
+	l.mu.Lock()
+	l.limit = n
+	l.notify()
+	l.mu.Unlock()
+}
+
+// helper function wakes the waiting operations. The caller
+// must hold the lock.
+func (l *limiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(2)
+	if err := l.acquire(noContext); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.acquire(noContext); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(noContext, time.Millisecond*10)
+	defer cancel()
+	if err := l.acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Want deadline exceeded when the limit is reached, got %v", err)
+	}
+
+	l.release()
+	if err := l.acquire(noContext); err != nil {
+		t.Errorf("Want acquire after release, got %s", err)
+	}
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	l := newLimiter(0)
+	for i := 0; i < 100; i++ {
+		if err := l.acquire(noContext); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.release()
+}
+
+func TestLimiter_Resize(t *testing.T) {
+	l := newLimiter(1)
+	if err := l.acquire(noContext); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- l.acquire(noContext)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("Want acquire to block when the limit is reached")
+	case <-time.After(time.Millisecond * 10):
+	}
+
+	l.resize(2)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want acquire to proceed after the limit is raised")
+	}
+
+	l.resize(1)
+	l.release()
+	ctx, cancel := context.WithTimeout(noContext, time.Millisecond*10)
+	defer cancel()
+	if err := l.acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Want deadline exceeded when the limit is lowered, got %v", err)
+	}
+}
@@ -6,16 +6,62 @@ package linter
 
 import (
 	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/drone-runners/drone-runner-macstadium/engine"
+	"github.com/drone-runners/drone-runner-macstadium/engine/compiler/shell"
 	"github.com/drone-runners/drone-runner-macstadium/engine/resource"
+	"github.com/drone-runners/drone-runner-macstadium/internal/expr"
+	"github.com/drone-runners/drone-runner-macstadium/internal/units"
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/manifest"
+
+	"github.com/gosimple/slug"
 )
 
+// regular expression to validate volume and vm names, which
+// are used as the ram disk volume name and in step names.
+var nameRE = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// regular expression to validate secret names imported into
+// the step environment, which must be valid variable names.
+var envRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// regular expression to validate app store connect key ids,
+// which are used in the api key file name.
+var keyIDRE = regexp.MustCompile(`^[A-Z0-9]+$`)
+
 // Linter evaluates the pipeline against a set of
 // rules and returns an error if one or more of the
 // rules are broken.
-type Linter struct{}
+type Linter struct {
+	// MaxCompute optionally limits the total number of cpu
+	// cores a pipeline may request for the pipeline vm and
+	// the additional vms, so that a single pipeline cannot
+	// block the entire cluster. If zero, the number is not
+	// limited.
+	//
+	// Pipelines cannot request memory or disk directly. Orka
+	// allocates vm memory in proportion to the cpu count, so
+	// the cpu limit also limits memory, and the vm disk is
+	// defined by the base image.
+	MaxCompute int
+
+	// DefaultCompute is the number of cpu cores of vms that
+	// do not request a cpu count, which is counted towards
+	// the cpu limit.
+	DefaultCompute int
+
+	// MaxRAMDisk optionally limits the total size, in bytes,
+	// of the ram disk volumes a pipeline may request, which
+	// are allocated from the vm memory. If zero, the size is
+	// not limited.
+	MaxRAMDisk int64
+}
 
 // New returns a new Linter.
 func New() *Linter {
@@ -25,17 +71,264 @@ func New() *Linter {
 // Lint executes the linting rules for the pipeline
 // configuration.
 func (l *Linter) Lint(pipeline manifest.Resource, repo *drone.Repo) error {
-	return checkPipeline(pipeline.(*resource.Pipeline), repo.Trusted)
+	if err := checkPipeline(pipeline.(*resource.Pipeline), repo.Trusted); err != nil {
+		return err
+	}
+	if err := checkCompute(pipeline.(*resource.Pipeline), l.MaxCompute, l.DefaultCompute); err != nil {
+		return err
+	}
+	return checkRAMDisk(pipeline.(*resource.Pipeline), l.MaxRAMDisk)
 }
 
 func checkPipeline(pipeline *resource.Pipeline, trusted bool) error {
+	if err := checkPriority(pipeline); err != nil {
+		return err
+	}
+	if err := checkSettings(pipeline, trusted); err != nil {
+		return err
+	}
 	if err := checkSteps(pipeline, trusted); err != nil {
 		return err
 	}
+	if err := checkParallel(pipeline); err != nil {
+		return err
+	}
+	if err := checkSync(pipeline, trusted); err != nil {
+		return err
+	}
+	if err := checkVolumes(pipeline); err != nil {
+		return err
+	}
+	if err := checkVMs(pipeline); err != nil {
+		return err
+	}
+	return nil
+}
+
+func checkCompute(pipeline *resource.Pipeline, max, defaults int) error {
+	if pipeline.Settings.Compute < 0 {
+		return errors.New("Linter: invalid cpu count")
+	}
+	if max > 0 && pipeline.Settings.Compute > max {
+		return fmt.Errorf("Linter: pipeline requests %d cpu, which exceeds the runner limit of %d", pipeline.Settings.Compute, max)
+	}
+	// additional vms that do not request a cpu count inherit
+	// the pipeline cpu count, and all vms run concurrently,
+	// so the cpu count of all vms is limited.
+	compute := pipeline.Settings.Compute
+	if compute == 0 {
+		compute = defaults
+	}
+	total := compute
+	for _, vm := range pipeline.VMs {
+		if vm == nil {
+			continue
+		}
+		if vm.Compute < 0 {
+			return fmt.Errorf("Linter: invalid cpu count for vm %q", vm.Name)
+		}
+		if max > 0 && vm.Compute > max {
+			return fmt.Errorf("Linter: vm %q requests %d cpu, which exceeds the runner limit of %d", vm.Name, vm.Compute, max)
+		}
+		if vm.Compute == 0 {
+			total += compute
+		} else {
+			total += vm.Compute
+		}
+	}
+	if max > 0 && total > max {
+		return fmt.Errorf("Linter: pipeline requests %d cpu across all vms, which exceeds the runner limit of %d", total, max)
+	}
+	return nil
+}
+
+func checkRAMDisk(pipeline *resource.Pipeline, max int64) error {
+	if max <= 0 {
+		return nil
+	}
+	var total int64
+	for _, v := range pipeline.Volumes {
+		if v == nil || v.Temp == nil {
+			continue
+		}
+		size := int64(engine.DefaultVolumeSize)
+		if v.Temp.Size != "" {
+			size, _ = units.ParseBytes(v.Temp.Size)
+		}
+		total += size
+	}
+	if total > max {
+		return fmt.Errorf("Linter: pipeline requests %d bytes of ram disk volumes, which exceeds the runner limit of %d", total, max)
+	}
+	return nil
+}
+
+func checkVMs(pipeline *resource.Pipeline) error {
+	names := map[string]struct{}{}
+	for _, vm := range pipeline.VMs {
+		if vm == nil || !nameRE.MatchString(vm.Name) {
+			return errors.New("Linter: invalid or missing vm name")
+		}
+		if _, ok := names[vm.Name]; ok {
+			return fmt.Errorf("Linter: duplicate vm name %q", vm.Name)
+		}
+		names[vm.Name] = struct{}{}
+	}
+	for _, step := range pipeline.Steps {
+		if step == nil || step.VM == "" {
+			continue
+		}
+		if _, ok := names[step.VM]; !ok {
+			return fmt.Errorf("Linter: step %q references undefined vm %q", step.Name, step.VM)
+		}
+	}
+	return nil
+}
+
+func checkVolumes(pipeline *resource.Pipeline) error {
+	names := map[string]struct{}{}
+	for _, v := range pipeline.Volumes {
+		if v == nil || !nameRE.MatchString(v.Name) {
+			return errors.New("Linter: invalid or missing volume name")
+		}
+		if _, ok := names[v.Name]; ok {
+			return fmt.Errorf("Linter: duplicate volume name %q", v.Name)
+		}
+		names[v.Name] = struct{}{}
+		if v.Temp == nil {
+			return errors.New("Linter: unsupported volume, must be a temp volume")
+		}
+		if !path.IsAbs(v.Path) {
+			return errors.New("Linter: volume path must be an absolute path")
+		}
+		if v.Temp.Size != "" {
+			if _, err := units.ParseBytes(v.Temp.Size); err != nil {
+				return errors.New("Linter: invalid volume size")
+			}
+		}
+	}
+	return nil
+}
+
+func checkSync(pipeline *resource.Pipeline, trusted bool) error {
+	push, pull := pipeline.Sync.Push, pipeline.Sync.Pull
+	if len(push) == 0 && len(pull) == 0 {
+		return nil
+	}
+	if !trusted {
+		return errors.New("Linter: untrusted repositories cannot sync folders")
+	}
+	for _, p := range push {
+		if !path.IsAbs(p.Source) {
+			return errors.New("Linter: sync source must be an absolute path")
+		}
+	}
+	for _, p := range pull {
+		if p.Source == "" {
+			return errors.New("Linter: sync source is required")
+		}
+		if !path.IsAbs(p.Target) {
+			return errors.New("Linter: sync target must be an absolute path")
+		}
+	}
+	return nil
+}
+
+func checkSettings(pipeline *resource.Pipeline, trusted bool) error {
+	for _, name := range pipeline.Settings.Simulators {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("Linter: invalid or missing simulator name")
+		}
+	}
+	if n := pipeline.Settings.Notarization; n != nil {
+		if n.APIKey == nil && (n.AppleID == nil || n.Password == nil || n.TeamID == nil) {
+			return errors.New("Linter: notarization requires an apple_id, password and team_id, or an api_key")
+		}
+		if n.APIKey != nil && (n.APIKeyID == nil || n.APIIssuer == nil) {
+			return errors.New("Linter: notarization api_key requires an api_key_id and api_issuer")
+		}
+	}
+	if f := pipeline.Settings.Fastlane; f != nil && f.BundleCache != "" {
+		if !trusted {
+			return errors.New("Linter: untrusted repositories cannot cache gems")
+		}
+		if !path.IsAbs(f.BundleCache) {
+			return errors.New("Linter: bundle_cache must be an absolute path")
+		}
+	}
+	if k := pipeline.Settings.AppStoreConnect; k != nil {
+		if k.KeyID == nil || k.IssuerID == nil || k.Key == nil {
+			return errors.New("Linter: app_store_connect requires a key_id, issuer_id and key")
+		}
+		if k.KeyID.Secret == "" && !keyIDRE.MatchString(k.KeyID.Value) {
+			return errors.New("Linter: invalid app_store_connect key_id")
+		}
+	}
+	for arch, image := range pipeline.Settings.Images {
+		switch arch {
+		case "amd64", "arm64":
+		default:
+			return fmt.Errorf("Linter: invalid images architecture %q, must be amd64 or arm64", arch)
+		}
+		if strings.TrimSpace(image) == "" {
+			return fmt.Errorf("Linter: invalid or missing image for architecture %q", arch)
+		}
+	}
+	if pipeline.Settings.Bake != "" && !trusted {
+		return errors.New("Linter: untrusted repositories cannot bake images")
+	}
+	if pipeline.Settings.Shell != "" && !shell.IsValid(pipeline.Settings.Shell) {
+		return errors.New("Linter: invalid shell, must be sh, bash or zsh")
+	}
+	switch pipeline.Settings.Debug {
+	case "", "sysdiagnose":
+	default:
+		return errors.New("Linter: invalid debug option, must be sysdiagnose")
+	}
+	switch pipeline.Settings.Timestamps {
+	case "", "elapsed", "clock":
+	default:
+		return errors.New("Linter: invalid timestamps, must be elapsed or clock")
+	}
+	if t := pipeline.Settings.IdleTimeout; t != 0 && t < time.Second {
+		return errors.New("Linter: idle_timeout must be at least 1s")
+	}
 	return nil
 }
 
+func checkPriority(pipeline *resource.Pipeline) error {
+	switch strings.ToLower(pipeline.Priority) {
+	case "", "low", "normal", "high":
+		return nil
+	default:
+		return errors.New("Linter: invalid priority, must be low, normal or high")
+	}
+}
+
 func checkSteps(pipeline *resource.Pipeline, trusted bool) error {
+	// the step scripts are written to files named after the
+	// step name slug, and steps with colliding slugs would
+	// overwrite each other's scripts. The names of the steps
+	// added by the compiler are reserved.
+	slugs := map[string]string{}
+	if !pipeline.Clone.Disable {
+		slugs["clone"] = "clone"
+		for _, vm := range pipeline.VMs {
+			if vm != nil {
+				name := "clone-" + vm.Name
+				slugs[slug.Make(name)] = name
+			}
+		}
+	}
+	if f := pipeline.Settings.Fastlane; f != nil && f.Bundle {
+		slugs["bundle"] = "bundle"
+	}
+	if pipeline.Settings.Bake != "" {
+		slugs["bake"] = "bake"
+	}
+	// the system log step may be enabled by the runner, and
+	// the name is always reserved.
+	slugs["system-logs"] = "system-logs"
 	for _, step := range pipeline.Steps {
 		if step == nil {
 			return errors.New("Linter: nil step")
@@ -43,10 +336,87 @@ func checkSteps(pipeline *resource.Pipeline, trusted bool) error {
 		if err := checkStep(step, trusted); err != nil {
 			return err
 		}
+		name := slug.Make(step.Name)
+		if name == "" {
+			return errors.New("Linter: invalid or missing step name")
+		}
+		if other, ok := slugs[name]; ok {
+			if other == step.Name {
+				return fmt.Errorf("Linter: duplicate step name %q", step.Name)
+			}
+			return fmt.Errorf("Linter: step names %q and %q are too similar", other, step.Name)
+		}
+		slugs[name] = step.Name
+	}
+	return nil
+}
+
+func checkParallel(pipeline *resource.Pipeline) error {
+	names := map[string]struct{}{}
+	graph := false
+	for _, step := range pipeline.Steps {
+		names[step.Name] = struct{}{}
+		if len(step.DependsOn) != 0 {
+			graph = true
+		}
+	}
+	// in serial pipelines the steps in a parallel group must be
+	// consecutive, since the group executes as a single stage.
+	closed := map[string]struct{}{}
+	prev := ""
+	for _, step := range pipeline.Steps {
+		group := step.Parallel
+		if group != prev {
+			closed[prev] = struct{}{}
+		}
+		prev = group
+		if group == "" {
+			continue
+		}
+		if !nameRE.MatchString(group) {
+			return fmt.Errorf("Linter: invalid parallel group name %q", group)
+		}
+		if _, ok := names[group]; ok {
+			return fmt.Errorf("Linter: parallel group %q conflicts with a step name", group)
+		}
+		if _, ok := closed[group]; ok && !graph {
+			return fmt.Errorf("Linter: steps in parallel group %q must be consecutive", group)
+		}
+		for _, dep := range step.DependsOn {
+			if dep == group {
+				return fmt.Errorf("Linter: step %q cannot depend on its own parallel group", step.Name)
+			}
+		}
 	}
 	return nil
 }
 
 func checkStep(step *resource.Step, trusted bool) error {
+	if step.Shell != "" && !shell.IsValid(step.Shell) {
+		return errors.New("Linter: invalid shell, must be sh, bash or zsh")
+	}
+	for _, name := range step.Secrets {
+		if !envRE.MatchString(name) {
+			return fmt.Errorf("Linter: invalid secret name %q", name)
+		}
+	}
+	switch step.Formatter {
+	case "", engine.FormatterXcbeautify, engine.FormatterXcpretty:
+	default:
+		return errors.New("Linter: invalid formatter, must be xcbeautify or xcpretty")
+	}
+	for _, file := range step.SecretFiles {
+		if file.Secret == "" {
+			return errors.New("Linter: secret file requires a secret name")
+		}
+		if file.Path == "" {
+			return errors.New("Linter: secret file requires a path")
+		}
+	}
+	if step.When.Expr != "" {
+		if _, err := expr.Parse(step.When.Expr); err != nil {
+			return fmt.Errorf("Linter: invalid when expression: %s", err)
+		}
+	}
 	return nil
 }
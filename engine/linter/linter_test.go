@@ -19,12 +19,253 @@ func TestLint(t *testing.T) {
 		trusted bool
 		invalid bool
 		message string
+		maxcpu  int
+		maxram  int64
 	}{
 		{
 			path:    "testdata/simple.yml",
 			trusted: false,
 			invalid: false,
 		},
+		{
+			path:    "testdata/priority.yml",
+			trusted: false,
+			invalid: false,
+		},
+		{
+			path:    "testdata/bake.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: untrusted repositories cannot bake images",
+		},
+		{
+			path:    "testdata/images.yml",
+			trusted: false,
+			invalid: false,
+		},
+		{
+			path:    "testdata/images_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: invalid images architecture "x86_64", must be amd64 or arm64`,
+		},
+		{
+			path:    "testdata/bake.yml",
+			trusted: true,
+			invalid: false,
+		},
+		{
+			path:    "testdata/shell.yml",
+			trusted: false,
+			invalid: false,
+		},
+		{
+			path:    "testdata/shell_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: invalid shell, must be sh, bash or zsh",
+		},
+		{
+			path:    "testdata/sync.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: untrusted repositories cannot sync folders",
+		},
+		{
+			path:    "testdata/sync.yml",
+			trusted: true,
+			invalid: false,
+		},
+		{
+			path:    "testdata/sync_relative.yml",
+			trusted: true,
+			invalid: true,
+			message: "Linter: sync source must be an absolute path",
+		},
+		{
+			path:    "testdata/fastlane_cache.yml",
+			trusted: true,
+			invalid: false,
+		},
+		{
+			path:    "testdata/fastlane_cache.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: untrusted repositories cannot cache gems",
+		},
+		{
+			path:    "testdata/debug_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: invalid debug option, must be sysdiagnose",
+		},
+		{
+			path:    "testdata/timestamps_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: invalid timestamps, must be elapsed or clock",
+		},
+		{
+			path:    "testdata/idle_timeout_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: idle_timeout must be at least 1s",
+		},
+		{
+			path:    "testdata/notarization_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: notarization api_key requires an api_key_id and api_issuer",
+		},
+		{
+			path:    "testdata/app_store_connect_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: invalid app_store_connect key_id",
+		},
+		{
+			path:    "testdata/similar_name.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: step names "unit test" and "Unit-Test" are too similar`,
+		},
+		{
+			path:    "testdata/clone_name.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: duplicate step name "clone"`,
+		},
+		{
+			path:    "testdata/vm_clone_name.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: duplicate step name "clone-sonoma"`,
+		},
+		{
+			path:    "testdata/bake_name.yml",
+			trusted: true,
+			invalid: true,
+			message: `Linter: duplicate step name "bake"`,
+		},
+		{
+			path:    "testdata/system_logs_name.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: step names "system-logs" and "system logs" are too similar`,
+		},
+		{
+			path:    "testdata/volumes.yml",
+			trusted: false,
+			invalid: false,
+		},
+		{
+			path:    "testdata/volumes_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: invalid volume size",
+		},
+		{
+			path:    "testdata/vms.yml",
+			trusted: false,
+			invalid: false,
+		},
+		{
+			path:    "testdata/vms_undefined.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: step "test" references undefined vm "monterey"`,
+		},
+		{
+			path:    "testdata/compute.yml",
+			trusted: false,
+			invalid: false,
+			maxcpu:  18,
+		},
+		{
+			path:    "testdata/compute.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: pipeline requests 18 cpu across all vms, which exceeds the runner limit of 12`,
+			maxcpu:  12,
+		},
+		{
+			path:    "testdata/compute.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: vm "monterey" requests 12 cpu, which exceeds the runner limit of 8`,
+			maxcpu:  8,
+		},
+		{
+			path:    "testdata/compute_groups.yml",
+			trusted: false,
+			invalid: false,
+			maxcpu:  16,
+		},
+		{
+			path:    "testdata/compute_groups.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: pipeline requests 16 cpu across all vms, which exceeds the runner limit of 12`,
+			maxcpu:  12,
+		},
+		{
+			path:    "testdata/volumes.yml",
+			trusted: false,
+			invalid: false,
+			maxram:  12 << 30,
+		},
+		{
+			path:    "testdata/volumes.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: pipeline requests 12884901888 bytes of ram disk volumes, which exceeds the runner limit of 10737418240",
+			maxram:  10 << 30,
+		},
+		{
+			path:    "testdata/parallel.yml",
+			trusted: false,
+			invalid: false,
+		},
+		{
+			path:    "testdata/parallel_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: steps in parallel group "test" must be consecutive`,
+		},
+		{
+			path:    "testdata/expr.yml",
+			trusted: false,
+			invalid: false,
+		},
+		{
+			path:    "testdata/expr_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: invalid when expression: expr: unexpected end of expression`,
+		},
+		{
+			path:    "testdata/secrets.yml",
+			trusted: false,
+			invalid: false,
+		},
+		{
+			path:    "testdata/secrets_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: `Linter: invalid secret name "docker-password"`,
+		},
+		{
+			path:    "testdata/secret_files_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: secret file requires a path",
+		},
+		{
+			path:    "testdata/priority_invalid.yml",
+			trusted: false,
+			invalid: true,
+			message: "Linter: invalid priority, must be low, normal or high",
+		},
 	}
 	for _, test := range tests {
 		name := path.Base(test.path)
@@ -41,6 +282,8 @@ func TestLint(t *testing.T) {
 			}
 
 			lint := New()
+			lint.MaxCompute = test.maxcpu
+			lint.MaxRAMDisk = test.maxram
 			opts := &drone.Repo{Trusted: test.trusted}
 			err = lint.Lint(resources.Resources[0].(*resource.Pipeline), opts)
 			if err == nil && test.invalid == true {
@@ -0,0 +1,97 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// masked replaces secret values in files collected from the
+// virtual machine, consistent with the step logs.
+const masked = "******"
+
+// helper function copies the reader to the writer, and masks
+// the values of masked secrets. The reader is copied line by
+// line, since multi-line secrets are masked line by line, so
+// that a secret is never split across writes.
+func copyMasked(dst io.Writer, src io.Reader, secrets []*Secret) (int64, error) {
+	r := newMasker(secrets)
+	if r == nil {
+		return io.Copy(dst, src)
+	}
+
+	var n int64
+	reader := bufio.NewReader(src)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) != 0 {
+			m, werr := io.WriteString(dst, r.Replace(line))
+			n += int64(m)
+			if werr != nil {
+				return n, werr
+			}
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// maskWriter is an io.Writer that masks the values of masked
+// secrets, for secrets resolved when the step runs, which
+// are not known when the step output is first wrapped.
+type maskWriter struct {
+	w io.Writer
+	r *strings.Replacer
+}
+
+// newMaskWriter returns a writer that masks the values of
+// masked secrets. If no secret is masked, the writer is
+// returned unchanged.
+func newMaskWriter(w io.Writer, secrets []*Secret) io.Writer {
+	r := newMasker(secrets)
+	if r == nil {
+		return w
+	}
+	return &maskWriter{w: w, r: r}
+}
+
+// Write writes p to the underlying writer with the secret
+// values masked.
+func (m *maskWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(m.w, m.r.Replace(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// helper function returns a replacer that masks the values
+// of masked secrets, or nil if no secret is masked.
+func newMasker(secrets []*Secret) *strings.Replacer {
+	var oldnew []string
+	for _, secret := range secrets {
+		if !secret.Mask {
+			continue
+		}
+		for _, part := range strings.Split(string(secret.Data), "\n") {
+			part = strings.TrimSpace(part)
+			// avoid masking empty or single character
+			// strings.
+			if len(part) < 2 {
+				continue
+			}
+			oldnew = append(oldnew, part, masked)
+		}
+	}
+	if len(oldnew) == 0 {
+		return nil
+	}
+	return strings.NewReplacer(oldnew...)
+}
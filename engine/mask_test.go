@@ -0,0 +1,70 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCopyMasked(t *testing.T) {
+	secrets := []*Secret{
+		{Name: "password", Data: []byte("correct-horse"), Mask: true},
+		{Name: "key", Data: []byte("line-one\nline-two\n"), Mask: true},
+		{Name: "username", Data: []byte("octocat"), Mask: false},
+		{Name: "short", Data: []byte("x"), Mask: true},
+	}
+	src := "login octocat correct-horse\nline-one\nline-two\nx marks the spot"
+	want := "login octocat ******\n******\n******\nx marks the spot"
+
+	buf := new(bytes.Buffer)
+	n, err := copyMasked(buf, strings.NewReader(src), secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("Want masked output %q, got %q", want, got)
+	}
+	if n != int64(len(want)) {
+		t.Errorf("Want %d bytes written, got %d", len(want), n)
+	}
+}
+
+func TestMaskWriter(t *testing.T) {
+	var got context.Context
+	secret := &Secret{
+		Name: "token",
+		Mask: true,
+		Resolve: func(ctx context.Context) ([]byte, error) {
+			got = ctx
+			return []byte("short-lived-token"), nil
+		},
+	}
+
+	// the value is not resolved until the step runs.
+	if v := secret.GetValue(); v != "" {
+		t.Errorf("Expect lazy secret unresolved, got %q", v)
+	}
+
+	ctx := context.WithValue(noContext, struct{}{}, "step")
+	if err := secret.load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got != ctx {
+		t.Errorf("Expect secret resolved with the step context")
+	}
+
+	buf := new(bytes.Buffer)
+	w := newMaskWriter(buf, []*Secret{secret})
+	if _, err := io.WriteString(w, "token short-lived-token\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "token ******\n"; got != want {
+		t.Errorf("Want masked output %q, got %q", want, got)
+	}
+}
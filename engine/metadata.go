@@ -0,0 +1,39 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "strconv"
+
+// Defines the orka vm metadata keys.
+const (
+	MetadataRepo   = "drone_repo"
+	MetadataBuild  = "drone_build"
+	MetadataStage  = "drone_stage"
+	MetadataRunner = "drone_runner"
+)
+
+// helper function returns the orka vm metadata that
+// attributes the vm to the build, or nil if vm metadata is
+// disabled. Warm vms are not yet assigned to a build, and
+// are attributed to the runner only.
+func (e *Engine) metadata(spec *Spec) map[string]string {
+	if !e.opts.Metadata {
+		return nil
+	}
+	metadata := map[string]string{}
+	if spec.Repo != "" {
+		metadata[MetadataRepo] = spec.Repo
+	}
+	if spec.Build != 0 {
+		metadata[MetadataBuild] = strconv.FormatInt(spec.Build, 10)
+	}
+	if spec.Stage != "" {
+		metadata[MetadataStage] = spec.Stage
+	}
+	if e.opts.Runner != "" {
+		metadata[MetadataRunner] = e.opts.Runner
+	}
+	return metadata
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka/orkatest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDeployNode_Metadata(t *testing.T) {
+	server := orkatest.NewServer()
+	defer server.Close()
+
+	e, err := New(server.Client(), Opts{Metadata: true, Runner: "runner-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &Spec{
+		Name:  "drone-abc123",
+		Repo:  "octocat/hello-world",
+		Build: 42,
+		Stage: "ios",
+	}
+	if _, err := e.client.Create(noContext, &orka.Config{Name: spec.Name, CPU: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.deployNode(noContext, spec, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"drone_repo":   "octocat/hello-world",
+		"drone_build":  "42",
+		"drone_stage":  "ios",
+		"drone_runner": "runner-1",
+	}
+	if diff := cmp.Diff(server.Metadata(spec.Name), want); diff != "" {
+		t.Errorf("Unexpected vm metadata")
+		t.Log(diff)
+	}
+}
+
+func TestMetadata_Disabled(t *testing.T) {
+	e := &Engine{opts: Opts{Runner: "runner-1"}}
+	if got := e.metadata(&Spec{Repo: "octocat/hello-world"}); got != nil {
+		t.Errorf("Expect no vm metadata when disabled, got %v", got)
+	}
+}
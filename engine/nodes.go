@@ -0,0 +1,139 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+	"github.com/drone/runner-go/logger"
+)
+
+// cluster node counts, published to the expvar endpoint.
+var (
+	nodesTotal = new(expvar.Int)
+	nodesReady = new(expvar.Int)
+)
+
+// health tracks the number of cluster nodes that are not
+// ready, such as nodes in maintenance, so that degradation
+// is reported when it changes.
+type health struct {
+	sync.Mutex
+	down  int
+	total int
+}
+
+// observe records the node states, and returns the number
+// of nodes that are not ready, the total number of nodes,
+// and true if either number changed.
+func (h *health) observe(nodes []*orka.Node) (down, total int, changed bool) {
+	total = len(nodes)
+	for _, node := range nodes {
+		if node.State != "READY" {
+			down++
+		}
+	}
+	nodesTotal.Set(int64(total))
+	nodesReady.Set(int64(total - down))
+
+	h.Lock()
+	defer h.Unlock()
+	changed = h.down != down || h.total != total
+	h.down = down
+	h.total = total
+	return down, total, changed
+}
+
+// selectNode returns the name of a ready node, with capacity
+// for the requested cpu count, that is not blacklisted. An
+// empty name is returned if all nodes are ready and none are
+// blacklisted, or if node selection is not possible, in
+// which case orka selects the node.
+func (e *Engine) selectNode(ctx context.Context, spec *Spec) string {
+	now := time.Now()
+	blacklisted := e.blacklist.active(now)
+	if !blacklisted && !e.opts.CheckNodes {
+		return ""
+	}
+	res, err := e.client.Nodes(ctx)
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("id", spec.Name).
+			Warn("cannot list nodes to exclude unavailable nodes")
+		return ""
+	}
+
+	down, total, changed := e.health.observe(res.Nodes)
+	if changed {
+		log := logger.FromContext(ctx).
+			WithField("down", down).
+			WithField("total", total)
+		if down == 0 {
+			log.Info("cluster recovered, all nodes ready")
+		} else {
+			log.Warnf("cluster degraded, %d of %d nodes down", down, total)
+		}
+	}
+
+	if down == 0 && !blacklisted {
+		return ""
+	}
+
+	// tagged deployments are restricted to the nodes with
+	// the tag. Older orka versions do not report the node
+	// tags, in which case node selection is deferred to orka
+	// for tagged deployments, and orka may select a node
+	// that is blacklisted.
+	nodes := res.Nodes
+	if tag := spec.Settings.Tag; tag != "" {
+		if !reportsTags(nodes) {
+			return ""
+		}
+		nodes = res.Tagged(tag).Nodes
+	}
+	return pickNode(nodes, spec.Settings.Compute, func(node *orka.Node) bool {
+		return e.blacklist.blocked(normalizeHost(node.HostIP), now)
+	})
+}
+
+// helper function returns the name of the ready node with
+// the most available cpu, and capacity for the requested cpu
+// count, that is not excluded. Selecting the least loaded
+// node spreads deployments across the cluster, instead of
+// filling the first node in the list.
+func pickNode(nodes []*orka.Node, cpu int, exclude func(*orka.Node) bool) string {
+	var picked *orka.Node
+	for _, node := range nodes {
+		if node.State != "READY" || node.AvailableCPU < cpu {
+			continue
+		}
+		if exclude(node) {
+			continue
+		}
+		if picked == nil || node.AvailableCPU > picked.AvailableCPU {
+			picked = node
+		}
+	}
+	if picked == nil {
+		return ""
+	}
+	return picked.Name
+}
+
+// helper function returns true if the nodes report the node
+// tags, which older orka versions do not.
+func reportsTags(nodes []*orka.Node) bool {
+	for _, node := range nodes {
+		if len(node.Tags) != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/h2non/gock"
+)
+
+func TestSelectNode(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://orka.company.com").
+		Get("/resources/node/list").
+		Times(2).
+		Reply(200).
+		JSON(map[string]interface{}{
+			"nodes": []interface{}{
+				map[string]interface{}{"name": "macpro-1", "hostIP": "10.0.0.1", "available_cpu": 12, "state": "NOT READY"},
+				map[string]interface{}{"name": "macpro-2", "hostIP": "10.0.0.2", "available_cpu": 12, "state": "READY"},
+			},
+		})
+
+	e := &Engine{
+		client: &orka.Client{Endpoint: "http://orka.company.com"},
+		opts:   Opts{CheckNodes: true},
+	}
+	spec := &Spec{Name: "drone-abc123"}
+	spec.Settings.Compute = 6
+	if got, want := e.selectNode(noContext, spec), "macpro-2"; got != want {
+		t.Errorf("Want node %q, got %q", want, got)
+	}
+	if got, want := nodesReady.Value(), int64(1); got != want {
+		t.Errorf("Want %d ready nodes, got %d", want, got)
+	}
+
+	// tagged deployments are scheduled by orka if the nodes
+	// do not report tags.
+	spec.Settings.Tag = "arm64"
+	if got, want := e.selectNode(noContext, spec), ""; got != want {
+		t.Errorf("Want node %q, got %q", want, got)
+	}
+}
+
+func TestSelectNode_Tag(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://orka.company.com").
+		Get("/resources/node/list").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"nodes": []interface{}{
+				map[string]interface{}{"name": "macpro-1", "hostIP": "10.0.0.1", "available_cpu": 12, "state": "NOT READY", "orka_tags": []string{"arm64"}},
+				map[string]interface{}{"name": "macpro-2", "hostIP": "10.0.0.2", "available_cpu": 12, "state": "READY", "orka_tags": []string{"amd64"}},
+				map[string]interface{}{"name": "macmini-1", "hostIP": "10.0.0.3", "available_cpu": 8, "state": "READY", "orka_tags": []string{"arm64"}},
+			},
+		})
+
+	e := &Engine{
+		client: &orka.Client{Endpoint: "http://orka.company.com"},
+		opts:   Opts{CheckNodes: true},
+	}
+	spec := &Spec{Name: "drone-abc123"}
+	spec.Settings.Compute = 6
+	spec.Settings.Tag = "arm64"
+	if got, want := e.selectNode(noContext, spec), "macmini-1"; got != want {
+		t.Errorf("Want node %q, got %q", want, got)
+	}
+}
+
+func TestSelectNode_Disabled(t *testing.T) {
+	e := &Engine{}
+	if got, want := e.selectNode(noContext, &Spec{}), ""; got != want {
+		t.Errorf("Want node %q, got %q", want, got)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	h := health{}
+	nodes := []*orka.Node{
+		{Name: "macpro-1", State: "READY"},
+		{Name: "macpro-2", State: "MAINTENANCE"},
+	}
+	down, total, changed := h.observe(nodes)
+	if down != 1 || total != 2 || !changed {
+		t.Errorf("Want 1 of 2 nodes down and changed, got %d of %d, %v", down, total, changed)
+	}
+	if _, _, changed = h.observe(nodes); changed {
+		t.Errorf("Expect unchanged node states not reported")
+	}
+}
+
+func TestPickNode(t *testing.T) {
+	nodes := []*orka.Node{
+		{Name: "macpro-1", HostIP: "10.0.0.1", AvailableCPU: 12, State: "READY"},
+		{Name: "macpro-2", HostIP: "10.0.0.2", AvailableCPU: 12, State: "NOT READY"},
+		{Name: "macpro-3", HostIP: "10.0.0.3", AvailableCPU: 3, State: "READY"},
+		{Name: "macpro-4", HostIP: "10.0.0.4", AvailableCPU: 6, State: "READY"},
+		{Name: "macpro-5", HostIP: "10.0.0.5", AvailableCPU: 10, State: "READY"},
+	}
+	exclude := func(node *orka.Node) bool {
+		return node.HostIP == "10.0.0.1"
+	}
+	if got, want := pickNode(nodes, 6, exclude), "macpro-5"; got != want {
+		t.Errorf("Want node %q, got %q", want, got)
+	}
+	if got, want := pickNode(nodes, 12, exclude), ""; got != want {
+		t.Errorf("Want node %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/drone/runner-go/logger"
+)
+
+// service name of the notarytool keychain profiles.
+const notaryService = "com.apple.gke.notary.tool"
+
+// Notary defines the notarization credentials that are
+// stored in a notarytool keychain profile, authenticating
+// with an Apple ID and app-specific password, or with an
+// App Store Connect API key.
+type Notary struct {
+	Profile  string `json:"profile,omitempty"`
+	AppleID  string `json:"apple_id,omitempty"`
+	Password string `json:"password,omitempty"`
+	TeamID   string `json:"team_id,omitempty"`
+	Key      string `json:"key,omitempty"`
+	KeyID    string `json:"key_id,omitempty"`
+	Issuer   string `json:"issuer,omitempty"`
+}
+
+// helper function removes the notarytool keychain profile
+// from the virtual machine. Errors are logged and ignored.
+func (e *Engine) removeNotary(ctx context.Context, spec *Spec) {
+	log := logger.FromContext(ctx).WithField("id", spec.Name)
+	client, err := e.dial(ctx, spec)
+	if err != nil {
+		log.WithError(err).Debug("cannot dial the vm to remove the notary profile")
+		return
+	}
+	defer client.Close()
+	if out, err := execute(client, notaryRemoveCommand(spec.Notary.Profile)); err != nil {
+		log.WithError(err).
+			WithField("output", string(out)).
+			Warn("cannot remove the notary profile")
+	}
+}
+
+// helper function returns a shell command that stores the
+// notarization credentials in the notarytool keychain
+// profile. The api key is written to a temporary file that
+// is removed once the credentials are stored.
+func notaryCommand(n *Notary) string {
+	if n.Key == "" {
+		return fmt.Sprintf("xcrun notarytool store-credentials %s --apple-id %s --password %s --team-id %s",
+			quote(n.Profile), quote(n.AppleID), quote(n.Password), quote(n.TeamID))
+	}
+	return fmt.Sprintf(`key=$(mktemp) && printf '%%s' %s > "$key" && xcrun notarytool store-credentials %s --key "$key" --key-id %s --issuer %s; status=$?; rm -f "$key"; exit $status`,
+		quote(n.Key), quote(n.Profile), quote(n.KeyID), quote(n.Issuer))
+}
+
+// helper function returns a shell command that removes the
+// notarytool keychain profile.
+func notaryRemoveCommand(profile string) string {
+	return fmt.Sprintf("security delete-generic-password -s %s -a %s >/dev/null 2>&1 || true",
+		quote(notaryService), quote(notaryService+".saved-creds."+profile))
+}
@@ -0,0 +1,303 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/naming"
+
+	"github.com/drone/runner-go/logger"
+)
+
+// empty context.
+var noContext = context.Background()
+
+// Schedule defines the number of warm virtual machines kept
+// ready for an image during a recurring daily time window.
+type Schedule struct {
+	Image string
+	Size  int
+	Days  [7]bool
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseSchedule parses the schedule string in the format
+// "<days> <start>-<end> <image>=<size>". Days are a comma or
+// hyphen separated list or range of weekdays, or "*" for all
+// days. For example:
+//
+//	mon-fri 08:00-18:00 Drone.img=4
+//	sat,sun 10:00-14:00 Drone.img=1
+//	* 22:00-06:00 Drone.img=2
+func ParseSchedule(s string) (*Schedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid schedule %q", s)
+	}
+	sched := new(Schedule)
+
+	if err := parseDays(fields[0], &sched.Days); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %s", s, err)
+	}
+
+	window := strings.SplitN(fields[1], "-", 2)
+	if len(window) != 2 {
+		return nil, fmt.Errorf("invalid schedule %q: invalid time window", s)
+	}
+	var err error
+	if sched.Start, err = ParseClock(window[0]); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %s", s, err)
+	}
+	if sched.End, err = ParseClock(window[1]); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %s", s, err)
+	}
+
+	i := strings.LastIndex(fields[2], "=")
+	if i < 1 {
+		return nil, fmt.Errorf("invalid schedule %q: invalid image size", s)
+	}
+	sched.Image = fields[2][:i]
+	if sched.Size, err = strconv.Atoi(fields[2][i+1:]); err != nil || sched.Size < 0 {
+		return nil, fmt.Errorf("invalid schedule %q: invalid image size", s)
+	}
+	return sched, nil
+}
+
+// Active returns true if the schedule is active at the given
+// time. A window that ends before it starts spans midnight,
+// and is active on the following morning.
+func (s *Schedule) Active(t time.Time) bool {
+	clock := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute
+	switch {
+	case s.Start <= s.End:
+		return s.Days[t.Weekday()] && clock >= s.Start && clock < s.End
+	case clock >= s.Start:
+		return s.Days[t.Weekday()]
+	case clock < s.End:
+		return s.Days[(t.Weekday()+6)%7]
+	}
+	return false
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// helper function parses the list of days.
+func parseDays(s string, days *[7]bool) error {
+	if s == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return nil
+	}
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		from, ok := weekdays[bounds[0]]
+		if !ok {
+			return fmt.Errorf("invalid day %q", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[bounds[1]]; !ok {
+				return fmt.Errorf("invalid day %q", bounds[1])
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// ParseClock parses the time of day in hh:mm format, and
+// returns the duration since midnight.
+func ParseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute, nil
+}
+
+// pool holds warm virtual machines that are deployed and
+// ready to be claimed by a pipeline.
+type pool struct {
+	sync.Mutex
+
+	idle    map[string][]*Spec
+	pending map[string]int
+}
+
+// claim removes and returns an idle virtual machine that
+// matches the settings, or nil if none are available.
+func (p *pool) claim(settings Settings) *Spec {
+	p.Lock()
+	defer p.Unlock()
+	idle := p.idle[settings.Image]
+	for i, warm := range idle {
+		if !warmMatch(warm.Settings, settings) {
+			continue
+		}
+		p.idle[settings.Image] = append(idle[:i:i], idle[i+1:]...)
+		return warm
+	}
+	return nil
+}
+
+// helper function returns true if the warm virtual machine
+// was provisioned with the settings that affect the virtual
+// machine before it is claimed: the image, cpu count, node
+// tag, and the credentials used to connect. Other settings
+// are applied when the virtual machine is claimed.
+func warmMatch(warm, settings Settings) bool {
+	return warm.Image == settings.Image &&
+		warm.Compute == settings.Compute &&
+		warm.Tag == settings.Tag &&
+		warm.Username == settings.Username &&
+		warm.Password == settings.Password &&
+		warm.PrivateKey == settings.PrivateKey
+}
+
+// Warm maintains a pool of warm virtual machines according to
+// the schedules, until the context is canceled. The pool size
+// for each image is the largest size of its active schedules.
+// Idle virtual machines are purged when the pool scales down,
+// and when the context is canceled.
+func (e *Engine) Warm(ctx context.Context, settings Settings, schedules []*Schedule, interval time.Duration) {
+	for {
+		e.scale(ctx, settings, schedules, time.Now())
+		select {
+		case <-ctx.Done():
+			e.drain()
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// helper function scales the warm pool to the size of the
+// active schedules at the given time.
+func (e *Engine) scale(ctx context.Context, settings Settings, schedules []*Schedule, now time.Time) {
+	desired := map[string]int{}
+	for _, sched := range schedules {
+		if _, ok := desired[sched.Image]; !ok {
+			desired[sched.Image] = 0
+		}
+		if sched.Active(now) && sched.Size > desired[sched.Image] {
+			desired[sched.Image] = sched.Size
+		}
+	}
+
+	e.pool.Lock()
+	defer e.pool.Unlock()
+	if e.pool.idle == nil {
+		e.pool.idle = map[string][]*Spec{}
+		e.pool.pending = map[string]int{}
+	}
+	for image, size := range desired {
+		current := len(e.pool.idle[image]) + e.pool.pending[image]
+		for ; current < size; current++ {
+			settings := settings
+			settings.Image = image
+			e.pool.pending[image]++
+			go e.warm(ctx, settings)
+		}
+		for current > size && len(e.pool.idle[image]) > 0 {
+			idle := e.pool.idle[image]
+			warm := idle[len(idle)-1]
+			e.pool.idle[image] = idle[:len(idle)-1]
+			current--
+			go e.Destroy(noContext, warm)
+		}
+	}
+}
+
+// helper function provisions a warm virtual machine and adds
+// the virtual machine to the pool.
+func (e *Engine) warm(ctx context.Context, settings Settings) {
+	spec := &Spec{
+		Name:     naming.New(naming.WarmPrefix),
+		Settings: settings,
+	}
+	log := logger.FromContext(ctx).
+		WithField("id", spec.Name).
+		WithField("image", settings.Image)
+
+	err := e.provision(ctx, spec)
+
+	e.pool.Lock()
+	defer e.pool.Unlock()
+	e.pool.pending[settings.Image]--
+	if err != nil {
+		log.WithError(err).Warn("cannot provision the warm vm")
+		return
+	}
+	// if the pool was drained while the vm was provisioned
+	// the vm is purged.
+	if ctx.Err() != nil {
+		go e.Destroy(noContext, spec)
+		return
+	}
+	log.Debug("warm vm is ready")
+	e.pool.idle[settings.Image] = append(e.pool.idle[settings.Image], spec)
+}
+
+// helper function creates, deploys and dials the virtual
+// machine to verify it is ready for use.
+func (e *Engine) provision(ctx context.Context, spec *Spec) error {
+	if err := e.setups.acquire(ctx); err != nil {
+		return err
+	}
+	defer e.setups.release()
+
+	_, err := e.client.Create(ctx, vmConfig(spec))
+	if err != nil {
+		return err
+	}
+	if err := e.authenticate(ctx, spec); err != nil {
+		e.client.Delete(noContext, spec.Name)
+		return err
+	}
+	client, err := e.create(ctx, spec)
+	if err != nil {
+		// the vm configuration is purged directly in case
+		// the vm was never deployed.
+		e.client.Delete(noContext, spec.Name)
+		return err
+	}
+	client.Close()
+	return nil
+}
+
+// helper function purges all idle virtual machines.
+func (e *Engine) drain() {
+	e.pool.Lock()
+	defer e.pool.Unlock()
+	for image, idle := range e.pool.idle {
+		for _, warm := range idle {
+			e.Destroy(noContext, warm)
+		}
+		delete(e.pool.idle, image)
+	}
+}
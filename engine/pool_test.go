@@ -0,0 +1,129 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	sched, err := ParseSchedule("mon-fri 08:00-18:30 Drone.img=4")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := sched.Image, "Drone.img"; got != want {
+		t.Errorf("Want image %q, got %q", want, got)
+	}
+	if got, want := sched.Size, 4; got != want {
+		t.Errorf("Want size %d, got %d", want, got)
+	}
+	if got, want := sched.Start, 8*time.Hour; got != want {
+		t.Errorf("Want start %s, got %s", want, got)
+	}
+	if got, want := sched.End, 18*time.Hour+30*time.Minute; got != want {
+		t.Errorf("Want end %s, got %s", want, got)
+	}
+	want := [7]bool{false, true, true, true, true, true, false}
+	if sched.Days != want {
+		t.Errorf("Want days %v, got %v", want, sched.Days)
+	}
+
+	for _, s := range []string{
+		"",
+		"mon-fri 08:00-18:00",
+		"mon-xyz 08:00-18:00 Drone.img=4",
+		"mon-fri 08:00 Drone.img=4",
+		"mon-fri 08:00-25:00 Drone.img=4",
+		"mon-fri 08:00-18:00 Drone.img",
+		"mon-fri 08:00-18:00 Drone.img=-1",
+	} {
+		if _, err := ParseSchedule(s); err == nil {
+			t.Errorf("Expect error parsing schedule %q", s)
+		}
+	}
+}
+
+func TestParseSchedule_Days(t *testing.T) {
+	tests := []struct {
+		days string
+		want [7]bool
+	}{
+		{"*", [7]bool{true, true, true, true, true, true, true}},
+		{"sat,sun", [7]bool{true, false, false, false, false, false, true}},
+		{"fri-mon", [7]bool{true, true, false, false, false, true, true}},
+		{"wed", [7]bool{false, false, false, true, false, false, false}},
+	}
+	for _, test := range tests {
+		sched, err := ParseSchedule(test.days + " 08:00-18:00 Drone.img=1")
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if sched.Days != test.want {
+			t.Errorf("Want days %v for %q, got %v", test.want, test.days, sched.Days)
+		}
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	day, _ := ParseSchedule("mon-fri 08:00-18:00 Drone.img=4")
+	night, _ := ParseSchedule("fri 22:00-06:00 Drone.img=2")
+
+	tests := []struct {
+		sched *Schedule
+		time  string
+		want  bool
+	}{
+		{day, "2020-05-04T08:00:00Z", true},    // monday
+		{day, "2020-05-04T17:59:00Z", true},    // monday
+		{day, "2020-05-04T18:00:00Z", false},   // monday
+		{day, "2020-05-04T07:59:00Z", false},   // monday
+		{day, "2020-05-09T12:00:00Z", false},   // saturday
+		{night, "2020-05-08T23:00:00Z", true},  // friday
+		{night, "2020-05-09T05:00:00Z", true},  // saturday
+		{night, "2020-05-09T23:00:00Z", false}, // saturday
+		{night, "2020-05-08T05:00:00Z", false}, // friday
+	}
+	for _, test := range tests {
+		now, _ := time.Parse(time.RFC3339, test.time)
+		if got := test.sched.Active(now); got != test.want {
+			t.Errorf("Want active %v at %s, got %v", test.want, test.time, got)
+		}
+	}
+}
+
+func TestPoolClaim(t *testing.T) {
+	warm := &Spec{
+		Name: "dronewarm1",
+		Settings: Settings{
+			Image:    "Drone.img",
+			Compute:  12,
+			Username: "admin",
+		},
+	}
+	p := &pool{
+		idle: map[string][]*Spec{"Drone.img": {warm}},
+	}
+	if p.claim(Settings{Image: "Other.img", Compute: 12, Username: "admin"}) != nil {
+		t.Errorf("Expect no warm vm for a different image")
+	}
+	if p.claim(Settings{Image: "Drone.img", Compute: 6, Username: "admin"}) != nil {
+		t.Errorf("Expect no warm vm for a different cpu count")
+	}
+	if p.claim(Settings{Image: "Drone.img", Compute: 12, Username: "admin", Tag: "xcode-15"}) != nil {
+		t.Errorf("Expect no warm vm for a different node tag")
+	}
+	if p.claim(Settings{Image: "Drone.img", Compute: 12, Username: "admin", Password: "secret"}) != nil {
+		t.Errorf("Expect no warm vm for different credentials")
+	}
+	if p.claim(warm.Settings) != warm {
+		t.Errorf("Expect warm vm claimed")
+	}
+	if p.claim(warm.Settings) != nil {
+		t.Errorf("Expect warm vm removed from the pool")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialFunc establishes a tcp connection to the address.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// contextDialer is implemented by the socks5 dialer.
+type contextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// helper function returns a dial function that tunnels
+// connections through the socks5 proxy, for runners that
+// can only reach the vm network through a proxy. A nil
+// function is returned if the proxy url is empty.
+func parseProxy(rawurl string) (dialFunc, error) {
+	if rawurl == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socks proxy url: %s", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("unsupported socks proxy scheme: %s", u.Scheme)
+	}
+	dialer, err := proxy.FromURL(u, nil)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := dialer.(contextDialer)
+	if !ok {
+		return nil, errors.New("socks proxy dialer does not support cancellation")
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+		return d.DialContext(ctx, network, addr)
+	}, nil
+}
+
+// helper function establishes a tcp connection to the
+// address, through the socks5 proxy if configured.
+func (e *Engine) dialTCP(ctx context.Context, network, addr string) (net.Conn, error) {
+	if e.proxy != nil {
+		return e.proxy(ctx, network, addr)
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	return dialer.DialContext(ctx, network, addr)
+}
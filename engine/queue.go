@@ -0,0 +1,163 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "sync"
+
+// Pipeline priorities.
+const (
+	PriorityLow    = -1
+	PriorityNormal = 0
+	PriorityHigh   = 1
+)
+
+// queue orders pipelines that are waiting for cluster
+// capacity. Only the pipeline at the front of the queue, with
+// the highest priority and the earliest arrival, may attempt
+// to deploy a virtual machine. This ensures high priority
+// pipelines are provisioned first when capacity is freed.
+// Pipelines that are actively deploying a virtual machine
+// are not considered when ordering the queue.
+//
+// If the queue is fair, pipelines with equal priority are
+// ordered round-robin across repositories instead of in order
+// of arrival, so that a repository with many pending
+// pipelines does not starve other repositories. The oldest
+// pipeline of each repository is ordered first, and ties are
+// broken in favor of the repository that was least recently
+// served.
+type queue struct {
+	sync.Mutex
+
+	fair    bool
+	seq     int
+	waiters map[*waiter]struct{}
+	served  map[string]int
+	wake    chan struct{}
+}
+
+// waiter represents a pipeline waiting in the queue.
+type waiter struct {
+	priority int
+	repo     string
+	seq      int
+	busy     bool
+}
+
+// push adds a waiter to the queue with the given priority.
+func (q *queue) push(priority int, repo string) *waiter {
+	q.Lock()
+	defer q.Unlock()
+	if q.waiters == nil {
+		q.waiters = map[*waiter]struct{}{}
+	}
+	q.seq++
+	w := &waiter{priority: priority, repo: repo, seq: q.seq}
+	q.waiters[w] = struct{}{}
+	return w
+}
+
+// remove removes the waiter from the queue, and wakes the
+// remaining waiters.
+func (q *queue) remove(w *waiter) {
+	q.Lock()
+	delete(q.waiters, w)
+	if q.fair {
+		if q.served == nil {
+			q.served = map[string]int{}
+		}
+		q.seq++
+		q.served[w.repo] = q.seq
+	}
+	q.Unlock()
+	q.notify()
+}
+
+// front returns true if the waiter is at the front of the
+// queue.
+func (q *queue) front(w *waiter) bool {
+	return q.position(w) == 0
+}
+
+// position returns the number of waiters ahead of the waiter
+// in the queue.
+func (q *queue) position(w *waiter) int {
+	q.Lock()
+	defer q.Unlock()
+	var n int
+	for other := range q.waiters {
+		if other == w || other.busy {
+			continue
+		}
+		if other.priority != w.priority {
+			if other.priority > w.priority {
+				n++
+			}
+			continue
+		}
+		if q.fair {
+			if a, b := q.rank(other), q.rank(w); a != b {
+				if a < b {
+					n++
+				}
+				continue
+			}
+			if a, b := q.served[other.repo], q.served[w.repo]; a != b {
+				if a < b {
+					n++
+				}
+				continue
+			}
+		}
+		if other.seq < w.seq {
+			n++
+		}
+	}
+	return n
+}
+
+// rank returns the number of waiting pipelines for the same
+// repository that arrived before the waiter. The caller must
+// hold the lock.
+func (q *queue) rank(w *waiter) int {
+	var n int
+	for other := range q.waiters {
+		if other != w && !other.busy &&
+			other.repo == w.repo && other.seq < w.seq {
+			n++
+		}
+	}
+	return n
+}
+
+// attempt marks the waiter as actively deploying a virtual
+// machine, or waiting, if false.
+func (q *queue) attempt(w *waiter, busy bool) {
+	q.Lock()
+	w.busy = busy
+	q.Unlock()
+}
+
+// wait returns a channel that is closed the next time the
+// queue is notified.
+func (q *queue) wait() <-chan struct{} {
+	q.Lock()
+	defer q.Unlock()
+	if q.wake == nil {
+		q.wake = make(chan struct{})
+	}
+	return q.wake
+}
+
+// notify wakes all waiters, for example, when a virtual
+// machine is destroyed and capacity may be available.
+func (q *queue) notify() {
+	q.Lock()
+	defer q.Unlock()
+	if q.wake != nil {
+		close(q.wake)
+		q.wake = nil
+	}
+}
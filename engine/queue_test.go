@@ -0,0 +1,87 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestQueue(t *testing.T) {
+	q := new(queue)
+	normal := q.push(PriorityNormal, "")
+	low := q.push(PriorityLow, "")
+	if !q.front(normal) {
+		t.Errorf("Expect normal priority at the front of the queue")
+	}
+	high := q.push(PriorityHigh, "")
+	if !q.front(high) {
+		t.Errorf("Expect high priority at the front of the queue")
+	}
+	if q.front(normal) || q.front(low) {
+		t.Errorf("Expect high priority ahead of other priorities")
+	}
+	next := q.push(PriorityHigh, "")
+	if q.front(next) {
+		t.Errorf("Expect equal priorities in order of arrival")
+	}
+	if got, want := q.position(low), 3; got != want {
+		t.Errorf("Want queue position %d, got %d", want, got)
+	}
+	q.attempt(high, true)
+	if !q.front(next) {
+		t.Errorf("Expect deploying pipelines to be skipped")
+	}
+	q.attempt(high, false)
+	if q.front(next) {
+		t.Errorf("Expect waiting pipelines to be ordered")
+	}
+	q.remove(high)
+	q.remove(next)
+	if !q.front(normal) {
+		t.Errorf("Expect normal priority at the front of the queue")
+	}
+}
+
+func TestQueue_Fair(t *testing.T) {
+	q := &queue{fair: true}
+	a1 := q.push(PriorityNormal, "octocat/hello-world")
+	a2 := q.push(PriorityNormal, "octocat/hello-world")
+	a3 := q.push(PriorityNormal, "octocat/hello-world")
+	b1 := q.push(PriorityNormal, "octocat/spoon-knife")
+	if !q.front(a1) {
+		t.Errorf("Expect the first pipeline at the front of the queue")
+	}
+	if got, want := q.position(b1), 1; got != want {
+		t.Errorf("Want queue position %d, got %d", want, got)
+	}
+	if got, want := q.position(a2), 2; got != want {
+		t.Errorf("Want queue position %d, got %d", want, got)
+	}
+	if got, want := q.position(a3), 3; got != want {
+		t.Errorf("Want queue position %d, got %d", want, got)
+	}
+	q.remove(a1)
+	if !q.front(b1) {
+		t.Errorf("Expect other repositories scheduled round-robin")
+	}
+	high := q.push(PriorityHigh, "octocat/hello-world")
+	if !q.front(high) {
+		t.Errorf("Expect priority ahead of fairness")
+	}
+}
+
+func TestQueue_Notify(t *testing.T) {
+	q := new(queue)
+	wake := q.wait()
+	select {
+	case <-wake:
+		t.Errorf("Expect wait channel open")
+	default:
+	}
+	q.notify()
+	select {
+	case <-wake:
+	default:
+		t.Errorf("Expect wait channel closed after notify")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/artifact"
+
+	"github.com/drone/runner-go/logger"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Defines the supported log formatters.
+const (
+	FormatterXcbeautify = "xcbeautify"
+	FormatterXcpretty   = "xcpretty"
+)
+
+// helper function fetches the unformatted step log, masks
+// secrets, and uploads the log to the artifact destination.
+// Errors are
+// logged and written to the output, and do not fail the
+// step.
+func (e *Engine) collectRawLog(ctx context.Context, client *ssh.Client, step *Step, path string, output io.Writer) {
+	log := logger.FromContext(ctx).WithField("step", step.Name)
+
+	if e.opts.Artifacts == nil {
+		return
+	}
+
+	clientftp, err := newSFTP(client, e.sftpOptions()...)
+	if err != nil {
+		log.WithError(err).Debug("cannot create sftp client to fetch the raw log")
+		fmt.Fprintf(output, "\ncannot fetch the raw log: %s\n", err)
+		return
+	}
+	defer clientftp.Close()
+
+	f, err := clientftp.Open(path)
+	if err != nil {
+		log.WithError(err).WithField("path", path).Debug("cannot open the raw log")
+		return
+	}
+	defer f.Close()
+
+	name := coverageName(step, "raw.log")
+	if err := streamMasked(ctx, e.opts.Artifacts, name, f, step.Secrets); err != nil {
+		log.WithError(err).WithField("path", path).Warn("cannot upload the raw log")
+		fmt.Fprintf(output, "\ncannot upload the raw log: %s\n", err)
+		return
+	}
+	fmt.Fprintf(output, "\nuploaded the raw log %s\n", name)
+}
+
+// helper function masks secrets in the artifact and uploads
+// the artifact. The artifact is masked to a temporary file,
+// since the size of the masked artifact must be known in
+// advance to stream the upload, and the artifact may be too
+// large to buffer in memory.
+func streamMasked(ctx context.Context, u artifact.Uploader, name string, r io.Reader, secrets []*Secret) error {
+	tmp, err := ioutil.TempFile("", "drone-artifact-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := copyMasked(tmp, r, secrets)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return u.Stream(ctx, name, tmp, size)
+}
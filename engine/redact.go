@@ -0,0 +1,109 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces secret values in a redacted pipeline
+// specification.
+const Redacted = "********"
+
+// Redact returns a copy of the pipeline specification with
+// secrets, credentials and the netrc password replaced, so
+// that the specification can be shared to reproduce a
+// problem without the repository. The copy can be executed,
+// however, steps that depend on secret values are expected
+// to fail.
+func Redact(spec *Spec) *Spec {
+	out := new(Spec)
+	raw, _ := json.Marshal(spec)
+	json.Unmarshal(raw, out)
+
+	// secret values are also replaced where they are embedded
+	// in environment variables and files, longest first, so
+	// that a secret containing another secret is replaced as
+	// a whole.
+	values := secretValues(out)
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	var oldnew []string
+	for _, v := range values {
+		oldnew = append(oldnew, v, Redacted)
+	}
+	redact(out, strings.NewReplacer(oldnew...))
+	return out
+}
+
+// helper function returns the secret values of the pipeline
+// specification and its groups.
+func secretValues(spec *Spec) []string {
+	var values []string
+	add := func(s string) {
+		if s != "" {
+			values = append(values, s)
+		}
+	}
+	add(spec.Settings.Password)
+	add(spec.Settings.PrivateKey)
+	if n := spec.Notary; n != nil {
+		add(n.Password)
+		add(n.Key)
+	}
+	for _, file := range spec.Files {
+		if file.Path == spec.AppStoreKey {
+			add(string(file.Data))
+		}
+	}
+	for _, step := range spec.Steps {
+		for _, secret := range step.Secrets {
+			add(string(secret.Data))
+		}
+		add(step.Envs["DRONE_NETRC_PASSWORD"])
+	}
+	for _, group := range spec.Groups {
+		values = append(values, secretValues(group)...)
+	}
+	return values
+}
+
+// helper function replaces the secret values of the pipeline
+// specification and its groups.
+func redact(spec *Spec, r *strings.Replacer) {
+	spec.Settings.Password = ""
+	spec.Settings.PrivateKey = ""
+	if n := spec.Notary; n != nil {
+		n.Password = r.Replace(n.Password)
+		n.Key = r.Replace(n.Key)
+	}
+	redactFiles(spec.Files, r)
+	for _, step := range spec.Steps {
+		for k, v := range step.Envs {
+			step.Envs[k] = r.Replace(v)
+		}
+		for _, secret := range step.Secrets {
+			if len(secret.Data) != 0 {
+				secret.Data = []byte(Redacted)
+			}
+		}
+		redactFiles(step.Files, r)
+	}
+	for _, group := range spec.Groups {
+		redact(group, r)
+	}
+}
+
+// helper function replaces secret values embedded in files.
+func redactFiles(files []*File, r *strings.Replacer) {
+	for _, file := range files {
+		if len(file.Data) != 0 {
+			file.Data = []byte(r.Replace(string(file.Data)))
+		}
+	}
+}
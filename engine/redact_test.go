@@ -0,0 +1,64 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+func TestRedact(t *testing.T) {
+	spec := &Spec{
+		Name: "drone-abc123",
+		Settings: Settings{
+			Username: "admin",
+			Password: "hunter2",
+		},
+		Steps: []*Step{
+			{
+				Name:      "build",
+				RunPolicy: runtime.RunOnFailure,
+				Envs: map[string]string{
+					"DRONE_NETRC_PASSWORD": "correct-horse",
+					"DRONE_NETRC_FILE":     "machine github.com login octocat password correct-horse",
+					"GOPATH":               "/Users/admin/go",
+				},
+				Secrets: []*Secret{
+					{Name: "token", Env: "TOKEN", Data: []byte("s3cr3t")},
+				},
+				Files: []*File{
+					{Path: "/tmp/build", Data: []byte("echo s3cr3t")},
+				},
+			},
+		},
+	}
+
+	out := Redact(spec)
+	if got := out.Settings.Password; got != "" {
+		t.Errorf("Want password removed, got %q", got)
+	}
+	step := out.Steps[0]
+	if got, want := step.Envs["DRONE_NETRC_FILE"], "machine github.com login octocat password "+Redacted; got != want {
+		t.Errorf("Want netrc %q, got %q", want, got)
+	}
+	if got, want := step.Envs["GOPATH"], "/Users/admin/go"; got != want {
+		t.Errorf("Want environment %q, got %q", want, got)
+	}
+	if got, want := string(step.Secrets[0].Data), Redacted; got != want {
+		t.Errorf("Want secret %q, got %q", want, got)
+	}
+	if got, want := string(step.Files[0].Data), "echo "+Redacted; got != want {
+		t.Errorf("Want file %q, got %q", want, got)
+	}
+	if got, want := step.RunPolicy, runtime.RunOnFailure; got != want {
+		t.Errorf("Want run policy %v, got %v", want, got)
+	}
+
+	// the original specification is not modified.
+	if got, want := string(spec.Steps[0].Secrets[0].Data), "s3cr3t"; got != want {
+		t.Errorf("Want original secret %q, got %q", want, got)
+	}
+}
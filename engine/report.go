@@ -0,0 +1,152 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/junit"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// reportTimeout limits the duration of publishing the test
+// report summary.
+const reportTimeout = time.Second * 30
+
+// reportPayload is the test report summary sent to the
+// report endpoint.
+type reportPayload struct {
+	Repo   string        `json:"repo"`
+	Build  string        `json:"build"`
+	Stage  string        `json:"stage"`
+	Step   string        `json:"step"`
+	Report *junit.Report `json:"report"`
+}
+
+// helper function fetches the junit test reports that match
+// the step report patterns, and writes the test summary to
+// the step output. Errors are logged and written to the
+// output, and do not fail the step.
+func (e *Engine) collectReports(ctx context.Context, client *ssh.Client, step *Step, output io.Writer) {
+	log := logger.FromContext(ctx).WithField("step", step.Name)
+
+	clientftp, err := newSFTP(client, e.sftpOptions()...)
+	if err != nil {
+		log.WithError(err).Debug("cannot create sftp client to fetch reports")
+		fmt.Fprintf(output, "\ncannot fetch test reports: %s\n", err)
+		return
+	}
+	defer clientftp.Close()
+
+	report := new(junit.Report)
+	var found int
+	for _, path := range glob(ctx, clientftp, step.Reports) {
+		f, err := clientftp.Open(path)
+		if err != nil {
+			log.WithError(err).WithField("path", path).Debug("cannot open report")
+			continue
+		}
+		res, err := junit.Parse(f)
+		f.Close()
+		if err != nil {
+			log.WithError(err).WithField("path", path).Debug("cannot parse report")
+			fmt.Fprintf(output, "\ncannot parse test report %s: %s\n", path, err)
+			continue
+		}
+		report.Merge(res)
+		found++
+	}
+	if found == 0 {
+		fmt.Fprintln(output, "\nno test reports found")
+		return
+	}
+
+	writeReport(output, report)
+
+	if e.opts.ReportEndpoint != "" {
+		err := e.publishReport(ctx, step, report)
+		if err != nil {
+			log.WithError(err).Warn("cannot publish test report")
+		}
+	}
+}
+
+// helper function returns the remote file paths that match
+// the patterns. Invalid patterns are logged and ignored.
+func glob(ctx context.Context, client *sftp.Client, patterns []string) []string {
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := client.Glob(pattern)
+		if err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("pattern", pattern).
+				Debug("invalid file pattern")
+			continue
+		}
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+// helper function writes the test report summary to the
+// output.
+func writeReport(w io.Writer, report *junit.Report) {
+	fmt.Fprintf(w, "\ntest results: %s\n", report)
+	for _, c := range report.Failed {
+		if c.Message == "" {
+			fmt.Fprintf(w, "FAIL %s/%s\n", c.Suite, c.Name)
+		} else {
+			fmt.Fprintf(w, "FAIL %s/%s: %s\n", c.Suite, c.Name, c.Message)
+		}
+	}
+}
+
+// helper function posts the test report summary to the
+// report endpoint.
+func (e *Engine) publishReport(ctx context.Context, step *Step, report *junit.Report) error {
+	ctx, cancel := context.WithTimeout(ctx, reportTimeout)
+	defer cancel()
+
+	payload := &reportPayload{
+		Repo:   step.Envs["DRONE_REPO"],
+		Build:  step.Envs["DRONE_BUILD_NUMBER"],
+		Stage:  step.Envs["DRONE_STAGE_NAME"],
+		Step:   step.Name,
+		Report: report,
+	}
+	buf := new(bytes.Buffer)
+	if err := json.NewEncoder(buf).Encode(payload); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.opts.ReportEndpoint, buf)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if e.opts.ReportToken != "" {
+		req.Header.Set("Authorization", "Bearer "+e.opts.ReportToken)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return fmt.Errorf("report endpoint returned status %d", res.StatusCode)
+	}
+	return nil
+}
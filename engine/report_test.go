@@ -0,0 +1,72 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/junit"
+
+	"github.com/h2non/gock"
+)
+
+func TestWriteReport(t *testing.T) {
+	report := &junit.Report{
+		Tests:    3,
+		Failures: 1,
+		Failed: []*junit.Case{
+			{Suite: "LoginTests", Name: "testLogout", Message: "assertion failed"},
+		},
+	}
+	buf := new(bytes.Buffer)
+	writeReport(buf, report)
+	want := "\ntest results: 3 tests, 1 failures, 0 errors, 0 skipped\nFAIL LoginTests/testLogout: assertion failed\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want report %q, got %q", want, got)
+	}
+}
+
+func TestPublishReport(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("https://reports.company.com").
+		Post("/junit").
+		MatchHeader("Authorization", "Bearer secret").
+		JSON(map[string]interface{}{
+			"repo":  "octocat/hello-world",
+			"build": "42",
+			"stage": "default",
+			"step":  "test",
+			"report": map[string]interface{}{
+				"tests":    2,
+				"failures": 0,
+				"errors":   0,
+				"skipped":  0,
+			},
+		}).
+		Reply(200)
+
+	e := &Engine{opts: Opts{
+		ReportEndpoint: "https://reports.company.com/junit",
+		ReportToken:    "secret",
+	}}
+	step := &Step{
+		Name: "test",
+		Envs: map[string]string{
+			"DRONE_REPO":         "octocat/hello-world",
+			"DRONE_BUILD_NUMBER": "42",
+			"DRONE_STAGE_NAME":   "default",
+		},
+	}
+	err := e.publishReport(context.Background(), step, &junit.Report{Tests: 2})
+	if err != nil {
+		t.Error(err)
+	}
+	if gock.IsPending() {
+		t.Errorf("Unfinished requests")
+	}
+}
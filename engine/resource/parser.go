@@ -31,8 +31,13 @@ func parse(r *manifest.RawResource) (manifest.Resource, bool, error) {
 }
 
 // match returns true if the resource matches the kind and type.
+// Pipelines written for the vm runner and the exec runner are
+// also matched, and are only dispatched to the runner in
+// compatibility mode.
 func match(r *manifest.RawResource) bool {
 	return (r.Kind == Kind && r.Type == Type) ||
+		(r.Kind == Kind && r.Type == TypeVM) ||
+		(r.Kind == Kind && r.Type == TypeExec) ||
 		(r.Kind == Kind && r.Type == "")
 }
 
@@ -35,8 +35,8 @@ func TestParse(t *testing.T) {
 			Type:    "macstadium",
 			Name:    "default",
 			Version: "1",
-			Environment: map[string]string{
-				"NODE_ENV": "development",
+			Environment: map[string]*manifest.Variable{
+				"NODE_ENV": {Value: "development"},
 			},
 			Workspace: Workspace{
 				Path: "/drone/src",
@@ -67,9 +67,11 @@ func TestParse(t *testing.T) {
 						"GOARCH": {Value: "arm64"},
 					},
 					Failure: "ignore",
-					When: manifest.Conditions{
-						Event: manifest.Condition{
-							Include: []string{"push"},
+					When: Conditions{
+						Conditions: manifest.Conditions{
+							Event: manifest.Condition{
+								Include: []string{"push"},
+							},
 						},
 					},
 				},
@@ -107,7 +109,7 @@ func TestParseLintNilStep(t *testing.T) {
 }
 
 func TestParseNoMatch(t *testing.T) {
-	r := &manifest.RawResource{Kind: "pipeline", Type: "exec"}
+	r := &manifest.RawResource{Kind: "pipeline", Type: "docker"}
 	_, match, _ := parse(r)
 	if match {
 		t.Errorf("Expect no match")
@@ -131,6 +133,22 @@ func TestMatch(t *testing.T) {
 		t.Errorf("Expect kind mismatch, got true")
 	}
 
+	r = &manifest.RawResource{
+		Kind: "pipeline",
+		Type: "vm",
+	}
+	if match(r) == false {
+		t.Errorf("Expect vm runner type match, got false")
+	}
+
+	r = &manifest.RawResource{
+		Kind: "pipeline",
+		Type: "exec",
+	}
+	if match(r) == false {
+		t.Errorf("Expect exec runner type match, got false")
+	}
+
 	r = &manifest.RawResource{
 		Kind: "pipeline",
 		Type: "dummy",
@@ -4,7 +4,11 @@
 
 package resource
 
-import "github.com/drone/runner-go/manifest"
+import (
+	"time"
+
+	"github.com/drone/runner-go/manifest"
+)
 
 var (
 	_ manifest.Resource          = (*Pipeline)(nil)
@@ -17,6 +21,12 @@ var (
 const (
 	Kind = "pipeline"
 	Type = "macstadium"
+
+	// TypeVM and TypeExec are the resource types of
+	// pipelines written for the vm runner and the exec
+	// runner, which are accepted in compatibility mode.
+	TypeVM   = "vm"
+	TypeExec = "exec"
 )
 
 // Pipeline is a pipeline resource that executes pipelines
@@ -26,18 +36,23 @@ type Pipeline struct {
 	Kind    string   `json:"kind,omitempty"`
 	Type    string   `json:"type,omitempty"`
 	Name    string   `json:"name,omitempty"`
-	Deps    []string `json:"depends_on,omitempty"`
-
-	Clone       manifest.Clone       `json:"clone,omitempty"`
-	Concurrency manifest.Concurrency `json:"concurrency,omitempty"`
-	Node        map[string]string    `json:"node,omitempty"`
-	Platform    manifest.Platform    `json:"platform,omitempty"`
-	Trigger     manifest.Conditions  `json:"conditions,omitempty"`
-
-	Settings    Settings          `json:"settings,omitempty"`
-	Environment map[string]string `json:"environment,omitempty"`
-	Steps       []*Step           `json:"steps,omitempty"`
-	Workspace   Workspace         `json:"workspace,omitempty"`
+	Deps    []string `json:"depends_on,omitempty" yaml:"depends_on"`
+
+	Clone       manifest.Clone      `json:"clone,omitempty"`
+	Concurrency Concurrency         `json:"concurrency,omitempty"`
+	Node        map[string]string   `json:"node,omitempty"`
+	Platform    manifest.Platform   `json:"platform,omitempty"`
+	Pool        Pool                `json:"pool,omitempty"`
+	Trigger     manifest.Conditions `json:"conditions,omitempty"`
+	Priority    string              `json:"priority,omitempty"`
+
+	Settings    Settings                      `json:"settings,omitempty"`
+	Environment map[string]*manifest.Variable `json:"environment,omitempty"`
+	Steps       []*Step                       `json:"steps,omitempty"`
+	Sync        Sync                          `json:"sync,omitempty"`
+	Volumes     []*Volume                     `json:"volumes,omitempty"`
+	VMs         []*VM                         `json:"vms,omitempty"`
+	Workspace   Workspace                     `json:"workspace,omitempty"`
 }
 
 // GetVersion returns the resource version.
@@ -65,7 +80,7 @@ func (p *Pipeline) GetNodes() map[string]string { return p.Node }
 func (p *Pipeline) GetPlatform() manifest.Platform { return p.Platform }
 
 // GetConcurrency returns the resource concurrency limits.
-func (p *Pipeline) GetConcurrency() manifest.Concurrency { return p.Concurrency }
+func (p *Pipeline) GetConcurrency() manifest.Concurrency { return p.Concurrency.Concurrency }
 
 // GetStep returns the named step. If no step exists with the
 // given name, a nil value is returned.
@@ -82,16 +97,141 @@ type (
 	// Step defines a Pipeline step.
 	Step struct {
 		Commands    []string                      `json:"commands,omitempty"`
+		Coverage    []string                      `json:"coverage,omitempty"`
 		Detach      bool                          `json:"detach,omitempty"`
 		DependsOn   []string                      `json:"depends_on,omitempty" yaml:"depends_on"`
 		Environment map[string]*manifest.Variable `json:"environment,omitempty"`
 		Failure     string                        `json:"failure,omitempty"`
+		Formatter   string                        `json:"formatter,omitempty"`
 		Name        string                        `json:"name,omitempty"`
+		Parallel    string                        `json:"parallel,omitempty"`
+		Reports     []string                      `json:"reports,omitempty"`
+		Secrets     []string                      `json:"secrets,omitempty"`
+		SecretFiles []*SecretFile                 `json:"secret_files,omitempty" yaml:"secret_files"`
 		Shell       string                        `json:"shell,omitempty"`
-		When        manifest.Conditions           `json:"when,omitempty"`
+		Trace       *bool                         `json:"trace,omitempty"`
+		VM          string                        `json:"vm,omitempty"`
+		When        Conditions                    `json:"when,omitempty"`
 		WorkingDir  string                        `json:"working_dir,omitempty" yaml:"working_dir"`
 	}
 
+	// SecretFile defines a secret that is written to a file,
+	// optionally base64 decoded, instead of being exported as
+	// an environment variable. Relative paths are relative to
+	// the workspace.
+	SecretFile struct {
+		Secret string `json:"secret,omitempty"`
+		Path   string `json:"path,omitempty"`
+		Base64 bool   `json:"base64,omitempty"`
+	}
+
+	// AppStoreConnect defines the App Store Connect API key.
+	// The values may be sourced from secrets.
+	AppStoreConnect struct {
+		KeyID    *manifest.Variable `json:"key_id,omitempty" yaml:"key_id"`
+		IssuerID *manifest.Variable `json:"issuer_id,omitempty" yaml:"issuer_id"`
+		Key      *manifest.Variable `json:"key,omitempty"`
+	}
+
+	// Fastlane defines the fastlane environment. The
+	// credentials may be sourced from secrets.
+	Fastlane struct {
+		User                       *manifest.Variable `json:"user,omitempty"`
+		Password                   *manifest.Variable `json:"password,omitempty"`
+		Session                    *manifest.Variable `json:"session,omitempty"`
+		MatchPassword              *manifest.Variable `json:"match_password,omitempty" yaml:"match_password"`
+		MatchGitBasicAuthorization *manifest.Variable `json:"match_git_basic_authorization,omitempty" yaml:"match_git_basic_authorization"`
+
+		// Bundle installs the gems with bundler before the
+		// pipeline steps are executed.
+		Bundle bool `json:"bundle,omitempty"`
+
+		// BundleCache optionally defines a folder on the
+		// runner host in which the installed gems are cached
+		// between pipelines.
+		BundleCache string `json:"bundle_cache,omitempty" yaml:"bundle_cache"`
+	}
+
+	// Notarization defines the notarization credentials,
+	// either an Apple ID and app-specific password, or an App
+	// Store Connect API key. The values may be sourced from
+	// secrets.
+	Notarization struct {
+		Profile   string             `json:"profile,omitempty"`
+		AppleID   *manifest.Variable `json:"apple_id,omitempty" yaml:"apple_id"`
+		Password  *manifest.Variable `json:"password,omitempty"`
+		TeamID    *manifest.Variable `json:"team_id,omitempty" yaml:"team_id"`
+		APIKey    *manifest.Variable `json:"api_key,omitempty" yaml:"api_key"`
+		APIKeyID  *manifest.Variable `json:"api_key_id,omitempty" yaml:"api_key_id"`
+		APIIssuer *manifest.Variable `json:"api_issuer,omitempty" yaml:"api_issuer"`
+	}
+
+	// Concurrency extends the concurrency limits with an
+	// optional key. Pipelines with the same key share the
+	// limit, which is enforced by the runner.
+	Concurrency struct {
+		manifest.Concurrency `yaml:",inline"`
+
+		Key string `json:"key,omitempty"`
+	}
+
+	// Pool defines the vm runner pool. In compatibility mode
+	// the pool name is used as the image name, and may be
+	// mapped to an image with an image alias.
+	Pool struct {
+		Use string `json:"use,omitempty"`
+	}
+
+	// Sync defines folders that are copied between the
+	// runner host and the virtual machine.
+	Sync struct {
+		Push []*SyncPath `json:"push,omitempty"`
+		Pull []*SyncPath `json:"pull,omitempty"`
+	}
+
+	// SyncPath defines a folder that is copied from the
+	// source to the target. Paths on the virtual machine
+	// are relative to the workspace.
+	SyncPath struct {
+		Source  string   `json:"source,omitempty"`
+		Target  string   `json:"target,omitempty"`
+		Include []string `json:"include,omitempty"`
+		Exclude []string `json:"exclude,omitempty"`
+	}
+
+	// Volume defines a volume that is created on the virtual
+	// machine and mounted at the path before the pipeline
+	// steps are executed.
+	Volume struct {
+		Name string      `json:"name,omitempty"`
+		Path string      `json:"path,omitempty"`
+		Temp *VolumeTemp `json:"temp,omitempty"`
+	}
+
+	// VolumeTemp defines a temporary volume backed by a ram
+	// disk. The size defaults to the runner default.
+	VolumeTemp struct {
+		Size string `json:"size,omitempty"`
+	}
+
+	// Conditions extends the step conditions with an optional
+	// boolean expression, which must also evaluate to true for
+	// the step to execute.
+	Conditions struct {
+		manifest.Conditions `yaml:",inline"`
+
+		Expr string `json:"expr,omitempty"`
+	}
+
+	// VM defines an additional virtual machine, with separate
+	// settings, on which steps may be executed. The image and
+	// cpu default to the pipeline settings.
+	VM struct {
+		Name    string `json:"name,omitempty"`
+		Image   string `json:"image,omitempty"`
+		Compute int    `json:"cpu,omitempty" yaml:"cpu"`
+	}
+
 	// Workspace represents the pipeline workspace configuration.
 	Workspace struct {
 		Path string `json:"path,omitempty"`
@@ -99,7 +239,58 @@ type (
 
 	// Settings provides virtual machine settings.
 	Settings struct {
-		Image   string `json:"image,omitempty"`
-		Compute int    `json:"cpu,omitempty" yaml:"cpu"`
+		Image       string        `json:"image,omitempty"`
+		Compute     int           `json:"cpu,omitempty" yaml:"cpu"`
+		Bake        string        `json:"bake,omitempty"`
+		Shell       string        `json:"shell,omitempty"`
+		LoginShell  bool          `json:"login_shell,omitempty" yaml:"login_shell"`
+		Pipefail    *bool         `json:"pipefail,omitempty"`
+		Nounset     *bool         `json:"nounset,omitempty"`
+		Trace       *bool         `json:"trace,omitempty"`
+		IdleTimeout time.Duration `json:"idle_timeout,omitempty" yaml:"idle_timeout"`
+		Timestamps  string        `json:"timestamps,omitempty"`
+		SystemLogs  time.Duration `json:"system_logs,omitempty" yaml:"system_logs"`
+		Debug       string        `json:"debug,omitempty"`
+		Netrc       *bool         `json:"netrc,omitempty"`
+		Simulators  []string      `json:"simulators,omitempty"`
+
+		// KeychainUnlock unlocks the login keychain and
+		// disables the keychain auto-lock for the duration of
+		// the pipeline, so that long signing steps do not
+		// fail when the keychain locks.
+		KeychainUnlock bool `json:"keychain_unlock,omitempty" yaml:"keychain_unlock"`
+
+		// KeepFailed retains the vm for the duration after a
+		// failed pipeline completes, so that the vm can be
+		// inspected. The duration is limited by the runner.
+		KeepFailed time.Duration `json:"keep_failed,omitempty" yaml:"keep_failed"`
+
+		// Notarization optionally defines the notarization
+		// credentials stored in a notarytool keychain profile.
+		Notarization *Notarization `json:"notarization,omitempty"`
+
+		// Fastlane optionally configures the environment for
+		// fastlane pipelines.
+		Fastlane *Fastlane `json:"fastlane,omitempty"`
+
+		// AppStoreConnect optionally defines the App Store
+		// Connect API key used by fastlane and altool.
+		AppStoreConnect *AppStoreConnect `json:"app_store_connect,omitempty" yaml:"app_store_connect"`
+
+		// Username, Password and SSHKey optionally override
+		// the runner credentials used to connect to the vm,
+		// for images with a different bootstrap account. The
+		// values may be sourced from secrets.
+		Username *manifest.Variable `json:"username,omitempty"`
+		Password *manifest.Variable `json:"password,omitempty"`
+		SSHKey   *manifest.Variable `json:"ssh_key,omitempty" yaml:"ssh_key"`
+
+		// Images optionally defines an image per architecture.
+		// The image for the platform architecture is preferred,
+		// and the image for the alternate architecture is used
+		// if the preferred image cannot be deployed before the
+		// fallback timeout.
+		Images          map[string]string `json:"images,omitempty"`
+		FallbackTimeout time.Duration     `json:"fallback_timeout,omitempty" yaml:"fallback_timeout"`
 	}
 )
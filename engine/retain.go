@@ -0,0 +1,156 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+)
+
+// retained holds the vms of failed pipelines that are kept
+// for inspection, by vm name.
+type retained struct {
+	sync.Mutex
+	vms map[string]*retainedVM
+}
+
+// retainedVM is a vm that is deleted when the timer fires.
+type retainedVM struct {
+	spec  *Spec
+	timer *time.Timer
+}
+
+// helper function retains the vm if the pipeline failed and
+// the pipeline requested failed vms are retained. The vm is
+// deleted when retention expires. It returns false if the vm
+// is not retained, including when the maximum number of vms
+// are already retained.
+func (e *Engine) retain(ctx context.Context, spec *Spec) bool {
+	keep := spec.Settings.KeepFailed
+	if keep <= 0 || !spec.ready || !spec.failed() {
+		return false
+	}
+
+	e.retained.Lock()
+	defer e.retained.Unlock()
+	if max := e.opts.MaxRetained; max > 0 && len(e.retained.vms) >= max {
+		logger.FromContext(ctx).
+			WithField("id", spec.Name).
+			WithField("max", max).
+			Warn("cannot retain the failed vm, the maximum number of vms are retained")
+		return false
+	}
+
+	logger.FromContext(ctx).
+		WithField("ip", spec.ip).
+		WithField("id", spec.Name).
+		WithField("duration", keep).
+		Info("retaining the failed vm for inspection")
+
+	if e.retained.vms == nil {
+		e.retained.vms = map[string]*retainedVM{}
+	}
+	e.retained.vms[spec.Name] = &retainedVM{
+		spec: spec,
+		timer: time.AfterFunc(keep, func() {
+			e.expire(spec.Name)
+		}),
+	}
+	return true
+}
+
+// helper function deletes the retained vm when retention
+// expires.
+func (e *Engine) expire(name string) {
+	e.retained.Lock()
+	vm, ok := e.retained.vms[name]
+	delete(e.retained.vms, name)
+	e.retained.Unlock()
+	if !ok {
+		return
+	}
+	spec := vm.spec
+
+	logger.FromContext(noContext).
+		WithField("ip", spec.ip).
+		WithField("id", spec.Name).
+		Debug("retention expired, deleting the failed vm")
+
+	if err := e.setups.acquire(noContext); err != nil {
+		return
+	}
+	defer e.setups.release()
+	if err := e.purge(noContext, spec); err != nil {
+		logger.FromContext(noContext).
+			WithError(err).
+			WithField("id", spec.Name).
+			Warn("cannot delete the retained vm")
+	}
+}
+
+// PurgeRetained deletes the retained vms of failed pipelines
+// without waiting for retention to expire, for example, when
+// the runner shuts down.
+func (e *Engine) PurgeRetained() {
+	e.retained.Lock()
+	var names []string
+	for name, vm := range e.retained.vms {
+		vm.timer.Stop()
+		names = append(names, name)
+	}
+	e.retained.Unlock()
+
+	for _, name := range names {
+		e.expire(name)
+	}
+}
+
+// WaitRetained blocks until the retained vms of failed
+// pipelines are deleted, or until the context is canceled,
+// in which case the retained vms are deleted immediately.
+func (e *Engine) WaitRetained(ctx context.Context) {
+	for {
+		e.retained.Lock()
+		n := len(e.retained.vms)
+		e.retained.Unlock()
+		if n == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			e.PurgeRetained()
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// helper function writes the retention period and the ssh
+// command to connect to the retained vm. The command assumes
+// the vm is directly reachable with the credentials of the
+// base image, which is not the case if the runner rotates
+// the password, authenticates with ephemeral keys, or
+// connects through a bastion host.
+func writeRetainNotice(w io.Writer, spec *Spec) {
+	host, port, err := net.SplitHostPort(spec.ip)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "the vm is retained for %s after the pipeline completes: ssh -p %s %s@%s\n",
+		spec.Settings.KeepFailed,
+		port,
+		spec.Settings.Username,
+		host,
+	)
+	fmt.Fprintln(w, "the vm may not be retained if the runner limit of retained vms is reached. "+
+		"the ssh command does not work if the runner rotates the vm password, authenticates "+
+		"with ephemeral keys or certificates, or connects to the vm through a bastion host or proxy.")
+}
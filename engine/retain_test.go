@@ -0,0 +1,133 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka/orkatest"
+)
+
+func TestDestroy_KeepFailed(t *testing.T) {
+	server := orkatest.NewServer()
+	defer server.Close()
+
+	e, err := New(server.Client(), Opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	passed := deployRetained(t, e, "drone-abc123", time.Hour)
+	failed := deployRetained(t, e, "drone-def456", time.Hour)
+	failed.fail()
+
+	for _, spec := range []*Spec{passed, failed} {
+		if err := e.Destroy(noContext, spec); err != nil {
+			t.Error(err)
+		}
+	}
+	if server.Deployed(passed.Name) {
+		t.Errorf("Expect the vm of the passing pipeline deleted")
+	}
+	if !server.Deployed(failed.Name) {
+		t.Errorf("Expect the vm of the failed pipeline retained")
+	}
+
+	e.PurgeRetained()
+	if server.Deployed(failed.Name) {
+		t.Errorf("Expect the retained vm deleted")
+	}
+}
+
+func TestDestroy_KeepFailedLimit(t *testing.T) {
+	server := orkatest.NewServer()
+	defer server.Close()
+
+	e, err := New(server.Client(), Opts{MaxRetained: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := deployRetained(t, e, "drone-abc123", time.Hour)
+	first.fail()
+	second := deployRetained(t, e, "drone-def456", time.Hour)
+	second.fail()
+
+	for _, spec := range []*Spec{first, second} {
+		if err := e.Destroy(noContext, spec); err != nil {
+			t.Error(err)
+		}
+	}
+	if !server.Deployed(first.Name) {
+		t.Errorf("Expect the vm of the first failed pipeline retained")
+	}
+	if server.Deployed(second.Name) {
+		t.Errorf("Expect the vm deleted when the retention limit is reached")
+	}
+	e.PurgeRetained()
+}
+
+func TestDestroy_KeepFailedExpired(t *testing.T) {
+	server := orkatest.NewServer()
+	defer server.Close()
+
+	e, err := New(server.Client(), Opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := deployRetained(t, e, "drone-abc123", time.Millisecond*10)
+	spec.fail()
+	if err := e.Destroy(noContext, spec); err != nil {
+		t.Error(err)
+	}
+	e.WaitRetained(noContext)
+	if server.Deployed(spec.Name) {
+		t.Errorf("Expect the retained vm deleted when retention expires")
+	}
+}
+
+func TestWriteRetainNotice(t *testing.T) {
+	spec := &Spec{
+		ip: "[fd00::1]:8822",
+		Settings: Settings{
+			Username:   "admin",
+			KeepFailed: time.Minute * 30,
+		},
+	}
+	buf := new(bytes.Buffer)
+	writeRetainNotice(buf, spec)
+	want := "the vm is retained for 30m0s after the pipeline completes: ssh -p 8822 admin@fd00::1\n"
+	if got := buf.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("Want notice %q, got %q", want, got)
+	}
+	if got := buf.String(); !strings.Contains(got, "bastion host") {
+		t.Errorf("Want notice to warn the ssh command may not work, got %q", got)
+	}
+}
+
+// helper function deploys the vm and returns a ready
+// pipeline specification that retains the vm if failed.
+func deployRetained(t *testing.T, e *Engine, name string, keep time.Duration) *Spec {
+	if _, err := e.client.Create(noContext, &orka.Config{Name: name, CPU: 4}); err != nil {
+		t.Fatal(err)
+	}
+	deploy, err := e.client.Deploy(noContext, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := deployAddress(deploy.IP, deploy.SSHPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Spec{
+		Name:     name,
+		ip:       ip,
+		created:  true,
+		ready:    true,
+		Settings: Settings{Compute: 4, KeepFailed: keep},
+	}
+}
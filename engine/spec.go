@@ -5,44 +5,166 @@
 package engine
 
 import (
+	"context"
+	"sync/atomic"
+	"time"
+
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/pipeline/runtime"
+
+	"golang.org/x/crypto/ssh"
 )
 
+// DefaultVolumeSize is the size of a ram disk volume, in
+// bytes, if the pipeline does not specify the size.
+const DefaultVolumeSize = 4 << 30
+
 type (
 
 	// Spec provides the pipeline spec. This provides the
 	// required instructions for reproducible pipeline
 	// execution.
 	Spec struct {
-		ip string
+		ip       string
+		signer   ssh.Signer
+		password string
+		hostKey  ssh.PublicKey
+		ready    bool
+		failures int32
+		arch     string
+		deployed time.Time
+		created  bool
+		locked   bool
+
+		Name     string    `json:"name,omitempty"`
+		Settings Settings  `json:"settings,omitempty"`
+		Files    []*File   `json:"files,omitempty"`
+		Steps    []*Step   `json:"steps,omitempty"`
+		Sync     Sync      `json:"sync,omitempty"`
+		Volumes  []*Volume `json:"volumes,omitempty"`
+
+		// Repo and Build are the repository slug and build
+		// number, used to account the vm usage.
+		Repo  string `json:"repo,omitempty"`
+		Build int64  `json:"build,omitempty"`
+
+		// Stage is the pipeline stage name, attached to the
+		// vm metadata.
+		Stage string `json:"stage,omitempty"`
+
+		// Notary optionally defines notarization credentials
+		// that are stored in a notarytool keychain profile
+		// before the pipeline steps are executed, and removed
+		// when the pipeline completes.
+		Notary *Notary `json:"notary,omitempty"`
+
+		// AppStoreKey optionally defines the path of the App
+		// Store Connect API key file, which is linked into the
+		// private keys directory searched by altool.
+		AppStoreKey string `json:"app_store_key,omitempty"`
+
+		// Simulators defines ios simulators that are created,
+		// if required, and booted before the pipeline steps
+		// are executed.
+		Simulators []string `json:"simulators,omitempty"`
+
+		// Groups defines additional vms, with separate
+		// settings, on which steps may be executed.
+		Groups []*Spec `json:"groups,omitempty"`
 
-		Name     string   `json:"name,omitempty"`
-		Settings Settings `json:"settings,omitempty"`
-		Files    []*File  `json:"files,omitempty"`
-		Steps    []*Step  `json:"steps,omitempty"`
+		// Group is the name of the vm group, which is
+		// referenced by the step.
+		Group string `json:"group,omitempty"`
+
+		// Error records an error encountered when compiling
+		// the pipeline. If set, the pipeline fails without
+		// provisioning a virtual machine.
+		Error string `json:"error,omitempty"`
 	}
 
 	// Settings provides pipeline settings.
 	Settings struct {
-		Compute  int    `json:"compute,omitempty"`
-		Image    string `json:"image,omitempty"`
-		Username string `json:"username,omitempty"`
-		Password string `json:"password,omitempty"`
+		Compute        int    `json:"compute,omitempty"`
+		Image          string `json:"image,omitempty"`
+		Username       string `json:"username,omitempty"`
+		Password       string `json:"password,omitempty"`
+		PrivateKey     string `json:"private_key,omitempty"`
+		EphemeralKey   bool   `json:"ephemeral_key,omitempty"`
+		RotatePassword bool   `json:"rotate_password,omitempty"`
+		Priority       int    `json:"priority,omitempty"`
+
+		// FallbackImage is deployed in place of the image if
+		// the image cannot be deployed before the timeout.
+		FallbackImage   string        `json:"fallback_image,omitempty"`
+		FallbackTimeout time.Duration `json:"fallback_timeout,omitempty"`
+
+		// Tag optionally restricts the vm to nodes with the
+		// tag, and FallbackTag replaces the tag when the
+		// fallback image is deployed.
+		Tag         string `json:"tag,omitempty"`
+		FallbackTag string `json:"fallback_tag,omitempty"`
+
+		// IdleTimeout terminates a step that produces no
+		// output for the duration of the timeout.
+		IdleTimeout time.Duration `json:"idle_timeout,omitempty"`
+
+		// Timestamps optionally prefixes each line of output
+		// with the elapsed or wall-clock time.
+		Timestamps string `json:"timestamps,omitempty"`
+
+		// Sysdiagnose collects a sysdiagnose bundle from the
+		// virtual machine if the pipeline fails.
+		Sysdiagnose bool `json:"sysdiagnose,omitempty"`
+
+		// KeychainUnlock unlocks the login keychain and
+		// disables the keychain auto-lock timeout.
+		KeychainUnlock bool `json:"keychain_unlock,omitempty"`
+
+		// DisableSpotlight, DisableUpdates and DisableSleep
+		// disable spotlight indexing, automatic software
+		// updates, and the screen saver and sleep on the
+		// virtual machine, which otherwise compete with the
+		// pipeline for cpu or interrupt long running steps.
+		DisableSpotlight bool `json:"disable_spotlight,omitempty"`
+		DisableUpdates   bool `json:"disable_updates,omitempty"`
+		DisableSleep     bool `json:"disable_sleep,omitempty"`
+
+		// ConcurrencyKey and ConcurrencyLimit limit the number
+		// of pipelines with the same key that execute on the
+		// runner concurrently. Pipelines that exceed the limit
+		// wait before the vm is provisioned.
+		ConcurrencyKey   string `json:"concurrency_key,omitempty"`
+		ConcurrencyLimit int    `json:"concurrency_limit,omitempty"`
+
+		// ConcurrencyTimeout limits how long the pipeline
+		// waits for the concurrency key. If zero, the pipeline
+		// waits until it is canceled.
+		ConcurrencyTimeout time.Duration `json:"concurrency_timeout,omitempty"`
+
+		// KeepFailed retains the vm for the duration after
+		// the pipeline completes if the pipeline failed, so
+		// that the vm can be inspected.
+		KeepFailed time.Duration `json:"keep_failed,omitempty"`
 	}
 
 	// Step defines a pipeline step.
 	Step struct {
 		Args       []string          `json:"args,omitempty"`
+		Bake       string            `json:"bake,omitempty"`
+		BakeAlias  string            `json:"bake_alias,omitempty"`
 		Command    string            `json:"command,omitempty"`
+		Coverage   []string          `json:"coverage,omitempty"`
 		Detach     bool              `json:"detach,omitempty"`
 		DependsOn  []string          `json:"depends_on,omitempty"`
 		ErrPolicy  runtime.ErrPolicy `json:"err_policy,omitempty"`
 		Envs       map[string]string `json:"environment,omitempty"`
 		Files      []*File           `json:"files,omitempty"`
+		Formatter  string            `json:"formatter,omitempty"`
 		Name       string            `json:"name,omitempt"`
+		Reports    []string          `json:"reports,omitempty"`
 		RunPolicy  runtime.RunPolicy `json:"run_policy,omitempty"`
 		Secrets    []*Secret         `json:"secrets,omitempty"`
+		VM         string            `json:"vm,omitempty"`
 		WorkingDir string            `json:"working_dir,omitempty"`
 	}
 
@@ -52,6 +174,55 @@ type (
 		Env  string `json:"env,omitempty"`
 		Data []byte `json:"data,omitempty"`
 		Mask bool   `json:"mask,omitempty"`
+
+		// Path optionally defines the path of a file on the
+		// virtual machine. The secret is written to the file
+		// instead of being exported as an environment
+		// variable, and is optionally base64 decoded so that
+		// binary secrets are delivered intact.
+		Path   string `json:"path,omitempty"`
+		Base64 bool   `json:"base64,omitempty"`
+
+		// Resolve optionally resolves the secret value when
+		// the step runs, instead of when the pipeline is
+		// compiled, so that short-lived credentials do not
+		// expire before they are used. The value is empty
+		// until the step runs, and is masked by the engine.
+		// The function should cache the resolved value.
+		Resolve func(ctx context.Context) ([]byte, error) `json:"-"`
+	}
+
+	// Sync defines folders that are copied from the runner
+	// host to the virtual machine before the pipeline
+	// starts, and from the virtual machine to the runner
+	// host after the pipeline completes.
+	Sync struct {
+		Push []*SyncPath `json:"push,omitempty"`
+		Pull []*SyncPath `json:"pull,omitempty"`
+	}
+
+	// SyncPath defines a folder that is copied from the
+	// source to the target, with optional include and
+	// exclude patterns.
+	SyncPath struct {
+		Source  string   `json:"source,omitempty"`
+		Target  string   `json:"target,omitempty"`
+		Include []string `json:"include,omitempty"`
+		Exclude []string `json:"exclude,omitempty"`
+
+		// Optional skips the push if the source folder does
+		// not exist on the runner host, for example, a cache
+		// folder that is created by the first pipeline.
+		Optional bool `json:"optional,omitempty"`
+	}
+
+	// Volume defines a ram disk that is created on the
+	// virtual machine, of the size in bytes, and linked
+	// to the path.
+	Volume struct {
+		Name string `json:"name,omitempty"`
+		Path string `json:"path,omitempty"`
+		Size int64  `json:"size,omitempty"`
 	}
 
 	// File defines a file that should be uploaded or
@@ -62,9 +233,32 @@ type (
 		Mode  uint32 `json:"mode,omitempty"`
 		Data  []byte `json:"data,omitempty"`
 		IsDir bool   `json:"is_dir,omitempty"`
+
+		// Source optionally defines the path to a file on
+		// the runner host that is streamed to the virtual
+		// machine in place of the data, so that large files
+		// are not held in memory.
+		Source string `json:"source,omitempty"`
 	}
 )
 
+// fail records a pipeline failure.
+func (s *Spec) fail() { atomic.AddInt32(&s.failures, 1) }
+
+// failed returns true if a pipeline step failed.
+func (s *Spec) failed() bool { return atomic.LoadInt32(&s.failures) != 0 }
+
+// group returns the named vm group, or nil if the group
+// does not exist.
+func (s *Spec) group(name string) *Spec {
+	for _, group := range s.Groups {
+		if group.Group == name {
+			return group
+		}
+	}
+	return nil
+}
+
 //
 // implements the Spec interface
 //
@@ -80,6 +274,17 @@ func (s *Secret) GetName() string  { return s.Name }
 func (s *Secret) GetValue() string { return string(s.Data) }
 func (s *Secret) IsMasked() bool   { return s.Mask }
 
+// load resolves the secret value, if the secret is resolved
+// when the step runs.
+func (s *Secret) load(ctx context.Context) error {
+	if s.Resolve == nil {
+		return nil
+	}
+	data, err := s.Resolve(ctx)
+	s.Data = data
+	return err
+}
+
 //
 // implements the Step interface
 //
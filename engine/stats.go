@@ -0,0 +1,151 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// runtime statistics for open ssh connections and sftp
+// sessions, published to the expvar endpoint.
+var (
+	activeSSH  = new(expvar.Int)
+	activeSFTP = new(expvar.Int)
+)
+
+// provisioning phases.
+const (
+	phaseCreate = "create"
+	phaseDeploy = "deploy"
+	phaseSSH    = "ssh"
+	phaseUpload = "upload"
+)
+
+// provisioning duration histograms, by image and by phase,
+// published to the expvar endpoint.
+var provisioning = new(expvar.Map).Init()
+
+// histogram buckets, in seconds.
+var buckets = []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600}
+
+func init() {
+	stats := expvar.NewMap("engine")
+	stats.Set("ssh_connections", activeSSH)
+	stats.Set("sftp_sessions", activeSFTP)
+	stats.Set("provisioning", provisioning)
+	stats.Set("usage", usage)
+	stats.Set("blacklisted_nodes", blacklisted)
+	stats.Set("nodes_total", nodesTotal)
+	stats.Set("nodes_ready", nodesReady)
+}
+
+// helper function tracks the ssh connection until it is
+// closed.
+func trackSSH(client *ssh.Client) {
+	activeSSH.Add(1)
+	go func() {
+		client.Wait()
+		activeSSH.Add(-1)
+	}()
+}
+
+// helper function creates an sftp session and tracks the
+// session until it is closed.
+func newSFTP(client *ssh.Client, opts ...sftp.ClientOption) (*sftp.Client, error) {
+	clientftp, err := sftp.NewClient(client, opts...)
+	if err != nil {
+		return nil, err
+	}
+	activeSFTP.Add(1)
+	go func() {
+		clientftp.Wait()
+		activeSFTP.Add(-1)
+	}()
+	return clientftp, nil
+}
+
+// histogram is a cumulative histogram of durations that
+// implements the expvar.Var interface.
+type histogram struct {
+	sync.Mutex
+	count  int64
+	sum    float64
+	counts []int64
+}
+
+// observe records the duration.
+func (h *histogram) observe(d time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	if h.counts == nil {
+		h.counts = make([]int64, len(buckets))
+	}
+	v := d.Seconds()
+	h.count++
+	h.sum += v
+	for i, le := range buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+}
+
+// String returns the json encoded histogram.
+func (h *histogram) String() string {
+	h.Lock()
+	defer h.Unlock()
+	out := struct {
+		Count   int64            `json:"count"`
+		Sum     float64          `json:"sum"`
+		Buckets map[string]int64 `json:"buckets"`
+	}{
+		Count:   h.count,
+		Sum:     h.sum,
+		Buckets: map[string]int64{"+Inf": h.count},
+	}
+	for i, le := range buckets {
+		var n int64
+		if h.counts != nil {
+			n = h.counts[i]
+		}
+		out.Buckets[strconv.FormatFloat(le, 'f', -1, 64)] = n
+	}
+	b, _ := json.Marshal(out)
+	return string(b)
+}
+
+// helper function records the duration of the provisioning
+// phase for the image, measured from the start time.
+func observe(image, phase string, start time.Time) {
+	lookup(image, phase).observe(time.Since(start))
+}
+
+// provisioningMu serializes the creation of histograms.
+var provisioningMu sync.Mutex
+
+// helper function returns the histogram for the image and
+// provisioning phase, creating the histogram if not found.
+func lookup(image, phase string) *histogram {
+	provisioningMu.Lock()
+	defer provisioningMu.Unlock()
+	phases, ok := provisioning.Get(image).(*expvar.Map)
+	if !ok {
+		phases = new(expvar.Map).Init()
+		provisioning.Set(image, phases)
+	}
+	h, ok := phases.Get(phase).(*histogram)
+	if !ok {
+		h = new(histogram)
+		phases.Set(phase, h)
+	}
+	return h
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := new(histogram)
+	h.observe(time.Millisecond * 500)
+	h.observe(time.Second * 4)
+	h.observe(time.Minute * 20)
+
+	out := struct {
+		Count   int64
+		Sum     float64
+		Buckets map[string]int64
+	}{}
+	if err := json.Unmarshal([]byte(h.String()), &out); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := out.Count, int64(3); got != want {
+		t.Errorf("Want count %d, got %d", want, got)
+	}
+	if got, want := out.Sum, 1204.5; got != want {
+		t.Errorf("Want sum %v, got %v", want, got)
+	}
+	tests := map[string]int64{
+		"1":    1,
+		"2":    1,
+		"5":    2,
+		"600":  2,
+		"+Inf": 3,
+	}
+	for le, want := range tests {
+		if got := out.Buckets[le]; got != want {
+			t.Errorf("Want %d observations in bucket %s, got %d", want, le, got)
+		}
+	}
+}
+
+func TestObserve(t *testing.T) {
+	observe("test.img", phaseDeploy, time.Now())
+	observe("test.img", phaseDeploy, time.Now())
+	if got, want := lookup("test.img", phaseDeploy).count, int64(2); got != want {
+		t.Errorf("Want %d observations, got %d", want, got)
+	}
+}
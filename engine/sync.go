@@ -0,0 +1,256 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/drone/runner-go/logger"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// helper function copies the folders on the virtual machine
+// to the runner host. Errors are logged and ignored, since
+// they should not prevent the virtual machine from being
+// deleted.
+func (e *Engine) pullAll(ctx context.Context, spec *Spec) {
+	client, err := e.dial(ctx, spec)
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("id", spec.Name).
+			Error("cannot dial the vm to pull folders")
+		return
+	}
+	defer client.Close()
+	for _, p := range spec.Sync.Pull {
+		if err := pull(ctx, client, p); err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("source", p.Source).
+				Error("cannot pull folder")
+		}
+	}
+}
+
+// helper function copies the folder on the runner host to the
+// virtual machine. The folder is streamed to the virtual
+// machine as a single tar archive, which is significantly
+// faster than uploading thousands of small files one at a
+// time.
+func push(ctx context.Context, client *ssh.Client, p *SyncPath) error {
+	logger.FromContext(ctx).
+		WithField("source", p.Source).
+		WithField("target", p.Target).
+		Debug("push the folder to the vm")
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, p.Source, newFilter(p)))
+	}()
+	session.Stdin = pr
+	out, err := session.CombinedOutput(
+		fmt.Sprintf("mkdir -p %s && tar -xf - -C %s", quote(p.Target), quote(p.Target)),
+	)
+	pr.Close()
+	if err != nil {
+		return fmt.Errorf("cannot push %s: %s: %s", p.Source, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// helper function copies the folder on the virtual machine
+// to the runner host.
+func pull(ctx context.Context, client *ssh.Client, p *SyncPath) error {
+	logger.FromContext(ctx).
+		WithField("source", p.Source).
+		WithField("target", p.Target).
+		Debug("pull the folder from the vm")
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = session.Start(fmt.Sprintf("tar -cf - -C %s .", quote(p.Source)))
+	if err != nil {
+		return err
+	}
+	if err := readTar(stdout, p.Target, newFilter(p)); err != nil {
+		return fmt.Errorf("cannot pull %s: %s", p.Source, err)
+	}
+	return session.Wait()
+}
+
+// filter selects the files that are synchronized using
+// include and exclude patterns. Patterns are matched against
+// the slash-separated path relative to the source folder, and
+// against the base name.
+type filter struct {
+	include []string
+	exclude []string
+}
+
+func newFilter(p *SyncPath) *filter {
+	return &filter{include: p.Include, exclude: p.Exclude}
+}
+
+// excluded returns true if the path, or a parent folder of
+// the path, matches an exclude pattern.
+func (f *filter) excluded(name string) bool {
+	for ; name != "."; name = path.Dir(name) {
+		if matchAny(f.exclude, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// included returns true if the file path is not excluded,
+// and matches an include pattern, if include patterns are
+// defined.
+func (f *filter) included(name string) bool {
+	if f.excluded(name) {
+		return false
+	}
+	return len(f.include) == 0 || matchAny(f.include, name)
+}
+
+// helper function returns true if the path, or the path base
+// name, matches any of the patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function writes the folder contents to the tar
+// archive.
+func writeTar(w io.Writer, root string, f *filter) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == "." {
+			return nil
+		}
+		if info.IsDir() {
+			if f.excluded(name) {
+				return filepath.SkipDir
+			}
+		} else if !f.included(name) {
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// helper function extracts the tar archive to the folder.
+// Only folders and regular files are extracted, and paths
+// that resolve outside of the folder are rejected.
+func readTar(r io.Reader, root string, f *filter) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if name == "." {
+			continue
+		}
+		if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		target := filepath.Join(root, filepath.FromSlash(name))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if !f.excluded(name) {
+				if err := os.MkdirAll(target, 0755); err != nil {
+					return err
+				}
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if !f.included(name) {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			dst, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode)&os.ModePerm)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(dst, tr)
+			dst.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
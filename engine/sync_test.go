@@ -0,0 +1,113 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFilter(t *testing.T) {
+	f := &filter{
+		include: []string{"*.xcresult", "reports/*"},
+		exclude: []string{"tmp", "*.log"},
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"test.xcresult", true},
+		{"nested/test.xcresult", true},
+		{"reports/junit.xml", true},
+		{"reports/build.log", false},
+		{"tmp/test.xcresult", false},
+		{"nested/tmp/test.xcresult", false},
+		{"README.md", false},
+	}
+	for _, test := range tests {
+		if got := f.included(test.name); got != test.want {
+			t.Errorf("Want included %v for %s, got %v", test.want, test.name, got)
+		}
+	}
+}
+
+func TestTar(t *testing.T) {
+	src, err := ioutil.TempDir("", "drone-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "drone-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	files := map[string]string{
+		"main.go":             "package main",
+		"vendor/lib/lib.go":   "package lib",
+		"build/output.log":    "log",
+		"node_modules/index":  "module",
+		"nested/deep/file.md": "readme",
+	}
+	for name, data := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(data), 0644)
+	}
+
+	buf := new(bytes.Buffer)
+	f := &filter{exclude: []string{"node_modules", "*.log"}}
+	if err := writeTar(buf, src, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := readTar(buf, dst, new(filter)); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	filepath.Walk(dst, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dst, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(got)
+	want := []string{"main.go", "nested/deep/file.md", "vendor/lib/lib.go"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Unexpected files")
+		t.Log(diff)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dst, "vendor", "lib", "lib.go"))
+	if got, want := string(data), "package lib"; got != want {
+		t.Errorf("Want file contents %q, got %q", want, got)
+	}
+}
+
+func TestTar_InvalidPath(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{
+		Name:     "../escape",
+		Mode:     0644,
+		Size:     0,
+		Typeflag: tar.TypeReg,
+	})
+	tw.Close()
+
+	err := readTar(buf, os.TempDir(), new(filter))
+	if err == nil || !strings.Contains(err.Error(), "invalid path") {
+		t.Errorf("Expect invalid path error, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Supported timestamp formats.
+const (
+	TimestampsNone    = ""
+	TimestampsElapsed = "elapsed"
+	TimestampsClock   = "clock"
+)
+
+// timestampWriter prefixes each line written to the
+// underlying writer with a timestamp, either the time
+// elapsed since the step started or the wall-clock time.
+// The writer is safe for concurrent use, since the step
+// stdout and stderr are written concurrently.
+type timestampWriter struct {
+	w      io.Writer
+	format string
+	start  time.Time
+	now    func() time.Time
+
+	mu sync.Mutex
+	// bol is true if the next write begins a new line.
+	bol bool
+}
+
+// newTimestampWriter returns a new timestamp writer.
+func newTimestampWriter(w io.Writer, format string) *timestampWriter {
+	return &timestampWriter{
+		w:      w,
+		format: format,
+		start:  time.Now(),
+		now:    time.Now,
+		bol:    true,
+	}
+}
+
+// Write writes to the underlying writer, prefixing each new
+// line with the timestamp.
+func (w *timestampWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	buf := new(bytes.Buffer)
+	for rest := p; len(rest) != 0; {
+		if w.bol {
+			buf.WriteString(w.prefix())
+			w.bol = false
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i == -1 {
+			buf.Write(rest)
+			break
+		}
+		buf.Write(rest[:i+1])
+		rest = rest[i+1:]
+		w.bol = true
+	}
+	if _, err := w.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// prefix returns the timestamp prefix.
+func (w *timestampWriter) prefix() string {
+	now := w.now()
+	if w.format == TimestampsClock {
+		return now.Format("[15:04:05] ")
+	}
+	d := now.Sub(w.start)
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
+	s := int(d.Seconds()) % 60
+	return fmt.Sprintf("[%02d:%02d:%02d] ", h, m, s)
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimestampWriter_Elapsed(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := newTimestampWriter(buf, TimestampsElapsed)
+	start := time.Date(2020, 5, 4, 15, 30, 0, 0, time.UTC)
+	now := start
+	w.start = start
+	w.now = func() time.Time { return now }
+
+	w.Write([]byte("line one\nline "))
+	now = now.Add(time.Minute*61 + time.Second*5)
+	w.Write([]byte("two\nline three\n"))
+
+	want := "[00:00:00] line one\n[00:00:00] line two\n[01:01:05] line three\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+}
+
+func TestTimestampWriter_Clock(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := newTimestampWriter(buf, TimestampsClock)
+	w.now = func() time.Time {
+		return time.Date(2020, 5, 4, 15, 30, 12, 0, time.UTC)
+	}
+	n, _ := w.Write([]byte("hello\n"))
+	if got, want := n, 6; got != want {
+		t.Errorf("Want %d bytes written, got %d", want, got)
+	}
+	if got, want := buf.String(), "[15:30:12] hello\n"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+}
+
+func TestTimestampWriter_Concurrent(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := newTimestampWriter(buf, TimestampsClock)
+	w.now = func() time.Time {
+		return time.Date(2020, 5, 4, 15, 30, 12, 0, time.UTC)
+	}
+
+	// stdout and stderr are written concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				w.Write([]byte("hello\n"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := strings.Repeat("[15:30:12] hello\n", 200)
+	if got := buf.String(); got != want {
+		t.Errorf("Want each line prefixed with the timestamp")
+	}
+}
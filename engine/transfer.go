@@ -0,0 +1,214 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Supported file transfer methods.
+const (
+	TransferAuto  = ""
+	TransferSFTP  = "sftp"
+	TransferShell = "shell"
+)
+
+// fileSystem writes files and folders to the remote server.
+type fileSystem interface {
+	// mkdir creates the folder, and any parent folders,
+	// and then configures the folder permissions.
+	mkdir(path string, mode uint32) error
+
+	// upload streams the file contents from the reader and
+	// then configures the file permissions.
+	upload(path string, r io.Reader, mode uint32) error
+
+	// Close closes the file system.
+	Close() error
+}
+
+// helper function returns the file system used to transfer
+// files to the remote server. If the transfer method is not
+// configured, sftp is used when the sftp subsystem is
+// available, falling back to shell commands otherwise.
+func (e *Engine) newFileSystem(ctx context.Context, client *ssh.Client) (fileSystem, error) {
+	// compressed files are decompressed by a shell command
+	// on the remote server, and cannot be written with sftp.
+	if e.opts.Compress {
+		return &shellFS{client: client, compress: true}, nil
+	}
+	if e.opts.Transfer == TransferShell {
+		return &shellFS{client: client}, nil
+	}
+	clientftp, err := newSFTP(client, e.sftpOptions()...)
+	if err == nil {
+		return &sftpFS{clientftp}, nil
+	}
+	if e.opts.Transfer == TransferSFTP || ctx.Err() != nil {
+		return nil, err
+	}
+	logger.FromContext(ctx).
+		WithError(err).
+		Debug("sftp unavailable, falling back to shell file transfer")
+	return &shellFS{client: client}, nil
+}
+
+// helper function returns the sftp client options.
+func (e *Engine) sftpOptions() []sftp.ClientOption {
+	var opts []sftp.ClientOption
+	if e.opts.SFTPMaxPacket > 0 {
+		opts = append(opts, sftp.MaxPacketUnchecked(e.opts.SFTPMaxPacket))
+	}
+	if e.opts.SFTPConcurrency > 0 {
+		opts = append(opts, sftp.MaxConcurrentRequestsPerFile(e.opts.SFTPConcurrency))
+	}
+	return opts
+}
+
+// helper function uploads the file to the remote server. If
+// the file source is set, the file is streamed from the
+// runner host.
+func uploadFile(fs fileSystem, file *File) error {
+	if file.Source == "" {
+		return fs.upload(file.Path, bytes.NewReader(file.Data), file.Mode)
+	}
+	f, err := os.Open(file.Source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fs.upload(file.Path, f, file.Mode)
+}
+
+// validateTransfer returns an error if the file transfer
+// method is not supported.
+func validateTransfer(transfer string) error {
+	switch transfer {
+	case TransferAuto, TransferSFTP, TransferShell:
+		return nil
+	default:
+		return fmt.Errorf("unsupported file transfer method: %s", transfer)
+	}
+}
+
+// sftpFS writes files using the sftp subsystem.
+type sftpFS struct {
+	client *sftp.Client
+}
+
+func (fs *sftpFS) mkdir(path string, mode uint32) error {
+	err := fs.client.MkdirAll(path)
+	if err != nil {
+		return err
+	}
+	return fs.client.Chmod(path, os.FileMode(mode))
+}
+
+// upload streams the file to the remote server. The sftp
+// client writes the file in chunks, with multiple concurrent
+// requests in flight, so that large files are not buffered
+// in memory.
+func (fs *sftpFS) upload(path string, r io.Reader, mode uint32) error {
+	f, err := fs.client.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.ReadFrom(r); err != nil {
+		return err
+	}
+	return f.Chmod(os.FileMode(mode))
+}
+
+func (fs *sftpFS) Close() error {
+	return fs.client.Close()
+}
+
+// shellFS writes files using shell commands executed over
+// an ssh session, for images that disable the sftp
+// subsystem. File contents are base64 encoded so that binary
+// data is transferred intact, or are optionally compressed
+// with gzip to reduce the transfer size.
+type shellFS struct {
+	client   *ssh.Client
+	compress bool
+}
+
+func (fs *shellFS) mkdir(path string, mode uint32) error {
+	return fs.run(mkdirCommand(path, mode), nil)
+}
+
+// upload streams the base64 encoded, or gzip compressed,
+// file to the remote server.
+func (fs *shellFS) upload(path string, r io.Reader, mode uint32) error {
+	if fs.compress {
+		return fs.uploadCompressed(path, r, mode)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		enc := base64.NewEncoder(base64.StdEncoding, pw)
+		_, err := io.Copy(enc, r)
+		if err == nil {
+			err = enc.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+	return fs.run(uploadCommand(path, mode), pr)
+}
+
+// uploadCompressed streams the gzip compressed file to the
+// remote server, where it is decompressed.
+func (fs *shellFS) uploadCompressed(path string, r io.Reader, mode uint32) error {
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, r)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+	return fs.run(gunzipCommand(path, mode), pr)
+}
+
+func (fs *shellFS) Close() error {
+	return nil
+}
+
+// run executes the command with the reader, if not nil,
+// attached to standard input.
+func (fs *shellFS) run(cmd string, in io.Reader) error {
+	session, err := fs.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	out := new(bytes.Buffer)
+	if in != nil {
+		session.Stdin = in
+	}
+	session.Stdout = out
+	session.Stderr = out
+	if err := session.Run(cmd); err != nil {
+		if out.Len() != 0 {
+			return fmt.Errorf("%s: %s", err, bytes.TrimSpace(out.Bytes()))
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// memFS is a file system that records uploaded files in
+// memory, for testing.
+type memFS map[string]string
+
+func (fs memFS) mkdir(path string, mode uint32) error { return nil }
+func (fs memFS) Close() error                         { return nil }
+func (fs memFS) upload(path string, r io.Reader, mode uint32) error {
+	data, err := ioutil.ReadAll(r)
+	fs[path] = string(data)
+	return err
+}
+
+func TestUploadFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "drone-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("streamed")
+	f.Close()
+
+	fs := memFS{}
+	uploadFile(fs, &File{Path: "/tmp/data", Data: []byte("inline")})
+	uploadFile(fs, &File{Path: "/tmp/source", Source: f.Name()})
+	if got, want := fs["/tmp/data"], "inline"; got != want {
+		t.Errorf("Want file data %q, got %q", want, got)
+	}
+	if got, want := fs["/tmp/source"], "streamed"; got != want {
+		t.Errorf("Want file data %q, got %q", want, got)
+	}
+
+	err = uploadFile(fs, &File{Path: "/tmp/missing", Source: "/path/to/missing"})
+	if err == nil {
+		t.Errorf("Expect error when the source file does not exist")
+	}
+}
+
+func TestValidateTransfer(t *testing.T) {
+	for _, transfer := range []string{TransferAuto, TransferSFTP, TransferShell} {
+		if err := validateTransfer(transfer); err != nil {
+			t.Errorf("Expect transfer method %q valid, got %s", transfer, err)
+		}
+	}
+	if err := validateTransfer("rsync"); err == nil {
+		t.Errorf("Expect unsupported transfer method error")
+	}
+}
+
+func TestSFTPOptions(t *testing.T) {
+	e := &Engine{}
+	if got := len(e.sftpOptions()); got != 0 {
+		t.Errorf("Want no sftp options by default, got %d", got)
+	}
+	e.opts.SFTPMaxPacket = 256 << 10
+	e.opts.SFTPConcurrency = 128
+	if got := len(e.sftpOptions()); got != 2 {
+		t.Errorf("Want 2 sftp options, got %d", got)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+// Update changes the options that are safe to change while
+// pipelines are running: the retry schedule used when the
+// cluster has insufficient capacity, the maximum number of
+// vms that are concurrently provisioned or deleted, and
+// whether pipelines fail fast. Pipelines that are waiting
+// for capacity use the new options on the next attempt.
+func (e *Engine) Update(backoff Backoff, maxSetup int, failFast bool) {
+	e.tuning.Lock()
+	e.opts.Backoff = backoff
+	e.opts.MaxSetup = maxSetup
+	e.opts.FailFast = failFast
+	e.tuning.Unlock()
+	e.setups.resize(maxSetup)
+}
+
+// helper function returns the retry schedule.
+func (e *Engine) backoff() Backoff {
+	e.tuning.RLock()
+	defer e.tuning.RUnlock()
+	return e.opts.Backoff
+}
+
+// helper function returns true if pipelines fail fast when
+// the cluster has insufficient capacity.
+func (e *Engine) failFast() bool {
+	e.tuning.RLock()
+	defer e.tuning.RUnlock()
+	return e.opts.FailFast
+}
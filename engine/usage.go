@@ -0,0 +1,120 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/accounting"
+)
+
+// maximum number of usage records retained until the records
+// are exported. The oldest records are discarded first.
+const maxUsageRecords = 10000
+
+// vm usage by repository, published to the expvar endpoint.
+var usage = new(expvar.Map).Init()
+
+// Usage provides the vm usage of a repository.
+type Usage struct {
+	VMs         int64   `json:"vms"`
+	CoreMinutes float64 `json:"core_minutes"`
+}
+
+// usageVar records the vm usage of a repository, and
+// implements the expvar.Var interface.
+type usageVar struct {
+	sync.Mutex
+	Usage
+}
+
+// String returns the json encoded usage.
+func (u *usageVar) String() string {
+	b, _ := json.Marshal(u.snapshot())
+	return string(b)
+}
+
+func (u *usageVar) snapshot() Usage {
+	u.Lock()
+	defer u.Unlock()
+	return u.Usage
+}
+
+// usageMu serializes the creation of usage records, and
+// access to the pending records.
+var usageMu sync.Mutex
+
+// usage records pending export.
+var pending []*accounting.Record
+
+// helper function records the usage of a vm, and accounts
+// the core-minutes consumed by the vm to the repository.
+func recordUsage(record *accounting.Record) {
+	repo := record.Repo
+	if repo == "" {
+		repo = "unknown"
+	}
+	usageMu.Lock()
+	u, ok := usage.Get(repo).(*usageVar)
+	if !ok {
+		u = new(usageVar)
+		usage.Set(repo, u)
+	}
+	pending = trimUsage(append(pending, record))
+	usageMu.Unlock()
+
+	u.Lock()
+	u.VMs++
+	u.CoreMinutes += record.Duration.Minutes() * float64(record.CPU)
+	u.Unlock()
+}
+
+// PendingUsage returns the usage records pending export.
+func PendingUsage() []*accounting.Record {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	return append([]*accounting.Record(nil), pending...)
+}
+
+// DrainUsage returns and removes the usage records pending
+// export.
+func DrainUsage() []*accounting.Record {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	records := pending
+	pending = nil
+	return records
+}
+
+// RequeueUsage returns usage records that could not be
+// exported to the records pending export.
+func RequeueUsage(records []*accounting.Record) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	pending = trimUsage(append(records, pending...))
+}
+
+// helper function discards the oldest records that exceed
+// the maximum number of retained records.
+func trimUsage(records []*accounting.Record) []*accounting.Record {
+	if n := len(records) - maxUsageRecords; n > 0 {
+		return records[n:]
+	}
+	return records
+}
+
+// UsageByRepo returns the vm usage of each repository since
+// the runner started.
+func UsageByRepo() map[string]Usage {
+	out := map[string]Usage{}
+	usage.Do(func(kv expvar.KeyValue) {
+		if u, ok := kv.Value.(*usageVar); ok {
+			out[kv.Key] = u.snapshot()
+		}
+	})
+	return out
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/accounting"
+)
+
+func TestRecordUsage(t *testing.T) {
+	DrainUsage()
+	recordUsage(&accounting.Record{Repo: "octocat/hello-world", CPU: 6, Duration: 10 * time.Minute})
+	recordUsage(&accounting.Record{Repo: "octocat/hello-world", CPU: 12, Duration: 5 * time.Minute})
+
+	got := UsageByRepo()["octocat/hello-world"]
+	if got.VMs != 2 {
+		t.Errorf("Want 2 vms, got %d", got.VMs)
+	}
+	if got.CoreMinutes != 120 {
+		t.Errorf("Want 120 core-minutes, got %v", got.CoreMinutes)
+	}
+
+	recordUsage(&accounting.Record{CPU: 6, Duration: time.Minute})
+	if got := UsageByRepo()["unknown"]; got.CoreMinutes != 6 {
+		t.Errorf("Want 6 core-minutes for unknown repos, got %v", got.CoreMinutes)
+	}
+
+	records := DrainUsage()
+	if got, want := len(records), 3; got != want {
+		t.Errorf("Want %d pending records, got %d", want, got)
+	}
+	if got := len(PendingUsage()); got != 0 {
+		t.Errorf("Want no pending records after drain, got %d", got)
+	}
+	RequeueUsage(records[:1])
+	if got := len(PendingUsage()); got != 1 {
+		t.Errorf("Want requeued records pending, got %d", got)
+	}
+}
@@ -5,13 +5,38 @@
 package engine
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
+	"path"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/ssh"
 )
 
+// helper function returns the orka vm configuration for the
+// pipeline. The same configuration is used when the vm is
+// provisioned, pre-provisioned for the warm pool, verified
+// by a dry run, or re-created for the fallback image.
+func vmConfig(spec *Spec) *orka.Config {
+	return &orka.Config{
+		Name:  spec.Name,
+		Image: spec.Settings.Image,
+		CPU:   spec.Settings.Compute,
+		VCPU:  spec.Settings.Compute,
+	}
+}
+
 // helper function calculates and returns the md5 fingerprint
 // of the public ssh key.
 func calcFingerprint(b []byte) (string, error) {
@@ -22,6 +47,381 @@ func calcFingerprint(b []byte) (string, error) {
 	return ssh.FingerprintLegacyMD5(key), nil
 }
 
+// helper function generates an ed25519 key pair and returns
+// the private key as an ssh signer.
+func generateKey() (ssh.Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// helper function generates a key pair and returns a signer
+// for the private key and a certificate, signed by the
+// certificate authority, that is valid for the principal
+// for the duration of the ttl.
+func signCertificate(authority ssh.Signer, id, principal string, ttl time.Duration) (ssh.Signer, error) {
+	signer, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	if ttl == 0 {
+		ttl = time.Hour * 24
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           id,
+		ValidPrincipals: []string{principal},
+		// allow for a small amount of clock skew between
+		// the runner and the virtual machine.
+		ValidAfter:  uint64(now.Add(-time.Minute * 5).Unix()),
+		ValidBefore: uint64(now.Add(ttl).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty": "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, authority); err != nil {
+		return nil, err
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// helper function returns a shell command that appends the
+// public key to the authorized keys file of the current user.
+func authorizeCommand(key ssh.PublicKey) string {
+	authorized := strings.TrimSpace(
+		string(ssh.MarshalAuthorizedKey(key)),
+	)
+	return fmt.Sprintf(
+		"mkdir -p ~/.ssh && chmod 700 ~/.ssh && echo %q >> ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys",
+		authorized,
+	)
+}
+
+// helper function returns a shell command that removes the
+// public key from the authorized keys.
+func revokeCommand(key ssh.PublicKey) string {
+	authorized := strings.TrimSpace(
+		string(ssh.MarshalAuthorizedKey(key)),
+	)
+	return fmt.Sprintf(
+		"touch ~/.ssh/authorized_keys && (grep -vxF %s ~/.ssh/authorized_keys > ~/.ssh/authorized_keys.tmp; mv ~/.ssh/authorized_keys.tmp ~/.ssh/authorized_keys) && chmod 600 ~/.ssh/authorized_keys",
+		quote(authorized),
+	)
+}
+
+// helper function returns the shell commands that revert the
+// rotated password and the ephemeral key before the vm image
+// is saved, and the shell commands that re-apply them once
+// the image is saved.
+func bakeCommands(spec *Spec) (restore, reapply []string) {
+	if spec.password != "" {
+		restore = append(restore, passwordCommand(spec.Settings.Username, spec.password, spec.Settings.Password))
+		reapply = append(reapply, passwordCommand(spec.Settings.Username, spec.Settings.Password, spec.password))
+	}
+	if spec.Settings.EphemeralKey && spec.signer != nil {
+		restore = append(restore, revokeCommand(spec.signer.PublicKey()))
+		reapply = append(reapply, authorizeCommand(spec.signer.PublicKey()))
+	}
+	return restore, reapply
+}
+
+// helper function generates a random password.
+func generatePassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// helper function returns a shell command that changes the
+// account password, and updates the login keychain password
+// so that it remains in sync with the account.
+func passwordCommand(username, oldpass, newpass string) string {
+	return fmt.Sprintf(
+		"dscl . -passwd /Users/%s %s %s && (security set-keychain-password -o %s -p %s ~/Library/Keychains/login.keychain-db >/dev/null 2>&1 || true)",
+		quote(username),
+		quote(oldpass),
+		quote(newpass),
+		quote(oldpass),
+		quote(newpass),
+	)
+}
+
+// helper function quotes the string for safe use as a posix
+// shell argument.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// helper function returns a shell command that creates the
+// folder, and any parent folders, and then configures the
+// folder permissions.
+func mkdirCommand(path string, mode uint32) string {
+	return fmt.Sprintf("mkdir -p %s && chmod %o %s", quote(path), mode, quote(path))
+}
+
+// helper function returns a shell command that writes the
+// base64 encoded standard input to the file, and then
+// configures the file permissions.
+func uploadCommand(path string, mode uint32) string {
+	return fmt.Sprintf("base64 --decode > %s && chmod %o %s", quote(path), mode, quote(path))
+}
+
+// helper function returns a shell command that decompresses
+// the gzip compressed standard input to the file, and then
+// configures the file permissions.
+func gunzipCommand(path string, mode uint32) string {
+	return fmt.Sprintf("gunzip -c > %s && chmod %o %s", quote(path), mode, quote(path))
+}
+
+// helper function returns a shell command that executes the
+// command and copies the combined output to the log file on
+// the remote server. The exit code of the command is
+// preserved.
+func teeCommand(cmd, path string) string {
+	exit := quote(path + ".exit")
+	return fmt.Sprintf("{ %s 2>&1; echo $? > %s; } | tee %s; exit $(cat %s)",
+		cmd, exit, quote(path), exit)
+}
+
+// helper function returns a shell command that collects a
+// sysdiagnose bundle, without user interaction, and writes
+// the archive to the folder.
+func sysdiagnoseCommand(dir, name string) string {
+	return fmt.Sprintf("mkdir -p %s && sudo -n sysdiagnose -u -f %s -A %s",
+		quote(dir), quote(dir), quote(name))
+}
+
+// helper function returns the shell commands that disable
+// spotlight indexing, automatic software updates, and the
+// screen saver and sleep, as enabled by the settings.
+func tuneCommands(settings Settings) []string {
+	var cmds []string
+	if settings.DisableSpotlight {
+		cmds = append(cmds, "sudo -n mdutil -a -i off")
+	}
+	if settings.DisableUpdates {
+		cmds = append(cmds,
+			"sudo -n softwareupdate --schedule off",
+			"sudo -n defaults write /Library/Preferences/com.apple.SoftwareUpdate AutomaticDownload -bool false",
+		)
+	}
+	if settings.DisableSleep {
+		cmds = append(cmds,
+			"sudo -n pmset -a sleep 0 displaysleep 0 disksleep 0",
+			"defaults -currentHost write com.apple.screensaver idleTime 0",
+		)
+	}
+	return cmds
+}
+
+// helper function returns the go architecture name for the
+// machine hardware name reported by uname.
+func normalizeArch(machine string) string {
+	switch machine = strings.TrimSpace(machine); machine {
+	case "x86_64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	default:
+		return machine
+	}
+}
+
+// helper function returns the stage platform environment
+// variables for the virtual machine architecture. The
+// architecture is omitted if unknown.
+func platformEnviron(arch string) map[string]string {
+	envs := map[string]string{
+		"DRONE_STAGE_OS": "darwin",
+	}
+	if arch != "" {
+		envs["DRONE_STAGE_ARCH"] = arch
+	}
+	return envs
+}
+
+// helper function returns a shell command that unlocks the
+// login keychain, and then removes the keychain auto-lock
+// timeout and the lock on sleep. The keychain is not unlocked
+// if the password is empty.
+func keychainCommand(password string) string {
+	keychain := `"$HOME/Library/Keychains/login.keychain-db"`
+	if password == "" {
+		return fmt.Sprintf("security set-keychain-settings %s", keychain)
+	}
+	return fmt.Sprintf("security unlock-keychain -p %s %s && security set-keychain-settings %s",
+		quote(password), keychain, keychain)
+}
+
+// helper function returns a shell command that links the app
+// store connect api key into the private keys directory that
+// altool searches for the key matching the key id.
+func appStoreKeyCommand(p string) string {
+	return fmt.Sprintf(`mkdir -p "$HOME/.appstoreconnect/private_keys" && ln -sf %s "$HOME/.appstoreconnect/private_keys/"%s`,
+		quote(p), quote(path.Base(p)))
+}
+
+// helper function returns a shell command that removes the
+// app store connect api key and the private keys link.
+func appStoreKeyRemoveCommand(p string) string {
+	return fmt.Sprintf(`rm -f %s "$HOME/.appstoreconnect/private_keys/"%s`, quote(p), quote(path.Base(p)))
+}
+
+// helper function returns a shell command that pipes the
+// command output through the log formatter, and copies the
+// unformatted output to the log file. The output is written
+// unformatted if the formatter is not installed. The exit code
+// of the command is preserved.
+func formatCommand(cmd, formatter, path string) string {
+	exit := quote(path + ".exit")
+	return fmt.Sprintf("{ %s 2>&1; echo $? > %s; } | tee %s | { command -v %s >/dev/null && %s || cat; }; exit $(cat %s)",
+		cmd, exit, quote(path), formatter, formatter, exit)
+}
+
+// helper function returns a shell command that creates the
+// named simulator, if a simulator with the name does not
+// exist, and then boots the simulator and waits until the
+// simulator has finished booting. The name is used as the
+// device type when the simulator is created, with the latest
+// available runtime.
+func simulatorCommand(name string) string {
+	return fmt.Sprintf("{ xcrun simctl list devices available | grep -qF %s || xcrun simctl create %s %s >/dev/null; } && xcrun simctl bootstatus %s -b",
+		quote("    "+name+" ("), quote(name), quote(name), quote(name))
+}
+
+// helper function returns a shell command that creates an
+// apfs ram disk of the size in bytes, and replaces the path
+// with a link to the ram disk mount point.
+func ramdiskCommand(name, target string, size int64) string {
+	mount := "/Volumes/" + name
+	return fmt.Sprintf(
+		`dev=$(hdiutil attach -nomount ram://%d | tr -d '[:space:]') && diskutil erasevolume APFS %s "$dev" >/dev/null && mkdir -p %s && rm -rf %s && ln -s %s %s`,
+		size/512,
+		quote(name),
+		quote(path.Dir(target)),
+		quote(target),
+		quote(mount),
+		quote(target),
+	)
+}
+
+// helper function returns a shell command that kills the
+// processes executing the script, and all descendant
+// processes. The script is the last argument of the shell
+// executing the script, and the match is anchored, so that
+// processes with the script path in a different position of
+// the command line, or with a path that shares the script
+// path as a prefix, are not killed. The shell executing the
+// command is excluded.
+func killCommand(script string) string {
+	return `k() { for c in $(pgrep -P "$1"); do k "$c"; done; kill -KILL "$1" 2>/dev/null; }; ` +
+		`for p in $(pgrep -f ` + quote(killPattern(script)) + `); do [ "$p" != "$$" ] && k "$p"; done`
+}
+
+// helper function returns the extended regular expression
+// that matches the command line of the shell executing the
+// script.
+func killPattern(script string) string {
+	return "(^| )" + regexp.QuoteMeta(script) + "$"
+}
+
+// supported ssh ciphers.
+var supportedCiphers = []string{
+	"aes128-gcm@openssh.com",
+	"chacha20-poly1305@openssh.com",
+	"aes128-ctr",
+	"aes192-ctr",
+	"aes256-ctr",
+	"aes128-cbc",
+	"3des-cbc",
+	"arcfour256",
+	"arcfour128",
+	"arcfour",
+}
+
+// supported ssh message authentication codes.
+var supportedMACs = []string{
+	"hmac-sha2-256-etm@openssh.com",
+	"hmac-sha2-256",
+	"hmac-sha1",
+	"hmac-sha1-96",
+}
+
+// supported ssh key exchange algorithms.
+var supportedKeyExchanges = []string{
+	"curve25519-sha256@libssh.org",
+	"ecdh-sha2-nistp256",
+	"ecdh-sha2-nistp384",
+	"ecdh-sha2-nistp521",
+	"diffie-hellman-group14-sha1",
+	"diffie-hellman-group1-sha1",
+	"diffie-hellman-group-exchange-sha256",
+	"diffie-hellman-group-exchange-sha1",
+}
+
+// helper function returns an error if the engine options
+// include an unsupported ssh algorithm.
+func validateAlgorithms(opts Opts) error {
+	if err := validateAlgorithm("cipher", opts.Ciphers, supportedCiphers); err != nil {
+		return err
+	}
+	if err := validateAlgorithm("mac", opts.MACs, supportedMACs); err != nil {
+		return err
+	}
+	return validateAlgorithm("key exchange", opts.KeyExchanges, supportedKeyExchanges)
+}
+
+func validateAlgorithm(kind string, algos, supported []string) error {
+L:
+	for _, algo := range algos {
+		for _, s := range supported {
+			if algo == s {
+				continue L
+			}
+		}
+		return fmt.Errorf("unsupported ssh %s: %s", kind, algo)
+	}
+	return nil
+}
+
+// helper function returns the ssh address of the vm from
+// the ip address and port reported by orka. The address is
+// normalized and validated, and ipv6 addresses are enclosed
+// in brackets.
+func deployAddress(ip, port string) (string, error) {
+	host := normalizeHost(ip)
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid vm ip address: %q", ip)
+	}
+	port = strings.TrimSpace(port)
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid vm ssh port: %q", port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// helper function returns the ip address in canonical form,
+// so that the same address reported by different endpoints
+// compares equal. Brackets enclosing an ipv6 address are
+// removed. Values that are not ip addresses are returned
+// without surrounding whitespace.
+func normalizeHost(ip string) string {
+	ip = strings.TrimSpace(ip)
+	ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
 // helper function writes a shell command to the io.Writer that
 // changes the current working directory.
 func writeWorkdir(w io.Writer, path string) {
@@ -30,13 +430,40 @@ func writeWorkdir(w io.Writer, path string) {
 }
 
 // helper function writes a shell command to the io.Writer that
-// exports all secrets as environment variables.
+// exports all secrets as environment variables. Secrets that
+// are written to files are not exported.
 func writeSecrets(w io.Writer, os string, secrets []*Secret) {
 	for _, s := range secrets {
+		if s.Path != "" {
+			continue
+		}
 		writeEnv(w, os, s.Env, string(s.Data))
 	}
 }
 
+// helper function writes a shell command to the io.Writer that
+// reads and evaluates the secrets from standard input, so that
+// secrets are exported without being written to the script.
+func writeStdinSecrets(w io.Writer) {
+	fmt.Fprintln(w, `eval "$(cat)"`)
+}
+
+// helper function returns the secret file contents, decoding
+// the base64 encoded secret if required. Whitespace is ignored
+// so that wrapped base64 output can be stored as a secret.
+func secretData(s *Secret) ([]byte, error) {
+	if !s.Base64 {
+		return s.Data, nil
+	}
+	data := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, string(s.Data))
+	return base64.StdEncoding.DecodeString(data)
+}
+
 // helper function writes a shell command to the io.Writer that
 // exports the key value pairs as environment variables.
 func writeEnviron(w io.Writer, os string, envs map[string]string) {
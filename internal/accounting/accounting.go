@@ -0,0 +1,181 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package accounting exports vm usage records for capacity
+// planning and charge back.
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Supported export formats.
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// Record provides the usage of a single vm.
+type Record struct {
+	Repo     string        `json:"repo"`
+	Build    int64         `json:"build"`
+	Image    string        `json:"image"`
+	CPU      int           `json:"cpu"`
+	Node     string        `json:"node"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+}
+
+// header of the csv encoded records.
+var header = []string{"repo", "build", "image", "cpu", "node", "started", "duration"}
+
+// Encode writes the records to the writer in the format.
+// Json records are written as newline delimited json, and
+// csv records are written with a header row.
+func Encode(w io.Writer, format string, records []*Record) error {
+	switch format {
+	case FormatJSON, "":
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		return encodeCSV(w, records, true)
+	default:
+		return fmt.Errorf("accounting: unsupported format: %s", format)
+	}
+}
+
+func encodeCSV(w io.Writer, records []*Record, withHeader bool) error {
+	cw := csv.NewWriter(w)
+	if withHeader {
+		cw.Write(header)
+	}
+	for _, r := range records {
+		cw.Write([]string{
+			r.Repo,
+			strconv.FormatInt(r.Build, 10),
+			r.Image,
+			strconv.Itoa(r.CPU),
+			r.Node,
+			r.Started.UTC().Format(time.RFC3339),
+			strconv.FormatFloat(r.Duration.Seconds(), 'f', 0, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Exporter exports usage records.
+type Exporter interface {
+	// Export exports the usage records.
+	Export(ctx context.Context, records []*Record) error
+}
+
+// New returns an exporter for the destination. The
+// destination is either an http endpoint that receives the
+// records in the request body, or a file path to which the
+// records are appended.
+func New(destination, token, format string) (Exporter, error) {
+	switch format {
+	case FormatJSON, FormatCSV:
+	case "":
+		format = FormatJSON
+	default:
+		return nil, fmt.Errorf("accounting: unsupported format: %s", format)
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &webhook{
+			endpoint: destination,
+			token:    token,
+			format:   format,
+		}, nil
+	case "":
+		return &file{
+			path:   destination,
+			format: format,
+		}, nil
+	default:
+		return nil, fmt.Errorf("accounting: unsupported destination: %s", destination)
+	}
+}
+
+// file appends records to a file. The csv header is only
+// written when the file is created.
+type file struct {
+	path   string
+	format string
+}
+
+func (f *file) Export(ctx context.Context, records []*Record) error {
+	_, err := os.Stat(f.path)
+	created := os.IsNotExist(err)
+	out, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if f.format == FormatCSV {
+		err = encodeCSV(out, records, created)
+	} else {
+		err = Encode(out, f.format, records)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// webhook posts records to an http endpoint.
+type webhook struct {
+	endpoint string
+	token    string
+	format   string
+}
+
+func (w *webhook) Export(ctx context.Context, records []*Record) error {
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, w.format, records); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.endpoint, buf)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if w.format == FormatCSV {
+		req.Header.Set("Content-Type", "text/csv")
+	} else {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return fmt.Errorf("accounting: endpoint returned status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/h2non/gock"
+)
+
+var noContext = context.Background()
+
+var testRecords = []*Record{
+	{
+		Repo:     "octocat/hello-world",
+		Build:    42,
+		Image:    "ventura-xcode-14.img",
+		CPU:      6,
+		Node:     "10.221.188.11",
+		Started:  time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+		Duration: 90 * time.Second,
+	},
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		destination string
+		format      string
+		invalid     bool
+	}{
+		{destination: "https://usage.company.com/upload"},
+		{destination: "/var/log/drone/usage.csv", format: "csv"},
+		{destination: "/var/log/drone/usage.xml", format: "xml", invalid: true},
+		{destination: "ftp://usage.company.com", invalid: true},
+	}
+	for _, test := range tests {
+		_, err := New(test.destination, "", test.format)
+		if test.invalid && err == nil {
+			t.Errorf("Expect error for destination %s", test.destination)
+		}
+		if !test.invalid && err != nil {
+			t.Errorf("Expect destination %s valid, got %s", test.destination, err)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, FormatCSV, testRecords); err != nil {
+		t.Error(err)
+		return
+	}
+	want := "repo,build,image,cpu,node,started,duration\n" +
+		"octocat/hello-world,42,ventura-xcode-14.img,6,10.221.188.11,2020-01-01T12:00:00Z,90\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want csv %q, got %q", want, got)
+	}
+
+	buf.Reset()
+	if err := Encode(buf, FormatJSON, testRecords); err != nil {
+		t.Error(err)
+		return
+	}
+	want = `{"repo":"octocat/hello-world","build":42,"image":"ventura-xcode-14.img","cpu":6,` +
+		`"node":"10.221.188.11","started":"2020-01-01T12:00:00Z","duration":90000000000}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want json %q, got %q", want, got)
+	}
+}
+
+func TestExport_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accounting")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "usage.csv")
+	exporter, _ := New(path, "", FormatCSV)
+	for i := 0; i < 2; i++ {
+		if err := exporter.Export(noContext, testRecords); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	raw, _ := ioutil.ReadFile(path)
+	row := "octocat/hello-world,42,ventura-xcode-14.img,6,10.221.188.11,2020-01-01T12:00:00Z,90\n"
+	want := "repo,build,image,cpu,node,started,duration\n" + row + row
+	if got := string(raw); got != want {
+		t.Errorf("Want the header written once, got %q", got)
+	}
+}
+
+func TestExport_Webhook(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("https://usage.company.com").
+		Post("/upload").
+		MatchHeader("Authorization", "Bearer token").
+		MatchHeader("Content-Type", "text/csv").
+		Reply(200)
+
+	exporter, _ := New("https://usage.company.com/upload", "token", FormatCSV)
+	if err := exporter.Export(noContext, testRecords); err != nil {
+		t.Error(err)
+	}
+	if !gock.IsDone() {
+		t.Errorf("Expect the records posted to the endpoint")
+	}
+}
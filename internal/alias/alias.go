@@ -0,0 +1,141 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package alias resolves semantic image aliases to the
+// newest matching base image.
+package alias
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/drone/runner-go/logger"
+)
+
+// Resolver resolves image aliases. Each alias maps to a glob
+// pattern, and resolves to the matching image name that sorts
+// last. Image names should therefore embed a sortable date or
+// version, for example:
+//
+//	xcode15: 90GVentura-Xcode15-*.img
+//
+// Images baked by the runner are suffixed with the creation
+// time, and an alias can be used to resolve the latest
+// baked image. When a bake pipeline completes, the alias of
+// the same name is updated to point at the baked image until
+// the runner restarts; to keep resolving the baked image
+// after a restart, configure the alias with a matching
+// pattern, for example:
+//
+//	xcode15: xcode15-*.img
+type Resolver struct {
+	client *orka.Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	aliases map[string]string
+	pinned  map[string]string
+	images  []string
+	expires time.Time
+}
+
+// New returns a new alias resolver. The image list is cached
+// for the duration of the ttl.
+func New(client *orka.Client, aliases map[string]string, ttl time.Duration) *Resolver {
+	return &Resolver{
+		client:  client,
+		aliases: aliases,
+		pinned:  map[string]string{},
+		ttl:     ttl,
+	}
+}
+
+// Configure replaces the configured aliases. Aliases updated
+// to point at a baked image are retained.
+func (r *Resolver) Configure(aliases map[string]string) {
+	r.mu.Lock()
+	r.aliases = aliases
+	r.mu.Unlock()
+}
+
+// Update points the alias at the named image, taking
+// precedence over the configured pattern. Only configured
+// aliases are updated, so that a pipeline cannot redirect
+// image names that are not aliases. It reports whether the
+// alias was updated.
+func (r *Resolver) Update(name, image string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.aliases[name]; !ok {
+		return false
+	}
+	r.pinned[name] = image
+	r.expires = time.Time{}
+	return true
+}
+
+// Resolve returns the baked image the alias was updated to
+// point at, or else the newest image that matches the alias.
+// Updates to aliases that are no longer configured are
+// ignored.
+// If the name is not an alias, or if no image matches the
+// alias, the name is returned unchanged.
+func (r *Resolver) Resolve(ctx context.Context, name string) string {
+	r.mu.Lock()
+	pattern, ok := r.aliases[name]
+	pinned, isPinned := r.pinned[name]
+	r.mu.Unlock()
+	if !ok {
+		return name
+	}
+	if isPinned {
+		logger.FromContext(ctx).
+			WithField("alias", name).
+			WithField("image", pinned).
+			Debug("resolved the image alias to the baked image")
+		return pinned
+	}
+	log := logger.FromContext(ctx).
+		WithField("alias", name).
+		WithField("pattern", pattern)
+
+	images, err := r.list(ctx)
+	if err != nil {
+		log.WithError(err).Warn("cannot list images to resolve the alias")
+		return name
+	}
+	var latest string
+	for _, image := range images {
+		if ok, _ := path.Match(pattern, image); ok && image > latest {
+			latest = image
+		}
+	}
+	if latest == "" {
+		log.Warn("no image matches the alias")
+		return name
+	}
+	log.WithField("image", latest).Debug("resolved the image alias")
+	return latest
+}
+
+// helper function returns the cached image list, refreshing
+// the cache when expired.
+func (r *Resolver) list(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.images != nil && time.Now().Before(r.expires) {
+		return r.images, nil
+	}
+	res, err := r.client.Images(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.images = res.Images
+	r.expires = time.Now().Add(r.ttl)
+	return r.images, nil
+}
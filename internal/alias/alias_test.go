@@ -0,0 +1,100 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package alias
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+
+	"github.com/h2non/gock"
+)
+
+func TestResolve(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://10.221.188.100").
+		Get("resources/image/list").
+		Times(1).
+		Reply(200).
+		Type("application/json").
+		File("testdata/images.json")
+
+	client := &orka.Client{
+		Endpoint: "http://10.221.188.100",
+		Token:    "token",
+	}
+	resolver := New(client, map[string]string{
+		"xcode15": "90GVentura-Xcode15-*.img",
+		"xcode16": "90GSonoma-Xcode16-*.img",
+	}, time.Minute)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"xcode15", "90GVentura-Xcode15-2024-05-01.img"},
+		{"xcode16", "xcode16"},
+		{"Drone.img", "Drone.img"},
+	}
+	for _, test := range tests {
+		if got := resolver.Resolve(context.Background(), test.name); got != test.want {
+			t.Errorf("Want image %q for %q, got %q", test.want, test.name, got)
+		}
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestResolve_Update(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://10.221.188.100").
+		Get("resources/image/list").
+		Times(1).
+		Reply(200).
+		Type("application/json").
+		File("testdata/images.json")
+
+	client := &orka.Client{
+		Endpoint: "http://10.221.188.100",
+		Token:    "token",
+	}
+	resolver := New(client, map[string]string{
+		"xcode15": "90GVentura-Xcode15-*.img",
+	}, time.Minute)
+	if !resolver.Update("xcode15", "xcode15-20240601120000.img") {
+		t.Errorf("Expect the configured alias updated")
+	}
+	if resolver.Update("xcode16", "xcode16-20240601120000.img") {
+		t.Errorf("Expect the image name that is not an alias ignored")
+	}
+	resolver.Configure(map[string]string{
+		"xcode15": "90GVentura-Xcode15-*.img",
+		"sonoma":  "90GVentura-Xcode15-*.img",
+	})
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"xcode15", "xcode15-20240601120000.img"},
+		{"xcode16", "xcode16"},
+		{"sonoma", "90GVentura-Xcode15-2024-05-01.img"},
+	}
+	for _, test := range tests {
+		if got := resolver.Resolve(context.Background(), test.name); got != test.want {
+			t.Errorf("Want image %q for %q, got %q", test.want, test.name, got)
+		}
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package artifact uploads build artifacts collected from
+// the virtual machine before it is destroyed.
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/aws"
+)
+
+// Uploader uploads artifacts.
+type Uploader interface {
+	// Upload uploads the named artifact.
+	Upload(ctx context.Context, name string, data []byte) error
+
+	// Stream uploads the named artifact from the reader,
+	// without buffering the artifact in memory. The size
+	// of the artifact must be known in advance.
+	Stream(ctx context.Context, name string, r io.Reader, size int64) error
+}
+
+// New returns an uploader for the destination. The
+// destination is either an s3 url, in the format
+// s3://bucket/prefix, or an http endpoint that receives
+// each artifact in the request body.
+func New(destination, token string, client *aws.Client) (Uploader, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "s3":
+		if client == nil {
+			return nil, errors.New("artifact: s3 uploads require an aws region")
+		}
+		return &bucket{
+			client: client,
+			bucket: u.Host,
+			prefix: strings.TrimPrefix(u.Path, "/"),
+		}, nil
+	case "http", "https":
+		return &webhook{
+			endpoint: destination,
+			token:    token,
+		}, nil
+	default:
+		return nil, fmt.Errorf("artifact: unsupported destination: %s", destination)
+	}
+}
+
+// bucket uploads artifacts to an s3 bucket.
+type bucket struct {
+	client *aws.Client
+	bucket string
+	prefix string
+}
+
+func (b *bucket) Upload(ctx context.Context, name string, data []byte) error {
+	return b.client.PutObject(ctx, b.bucket, path.Join(b.prefix, name), data)
+}
+
+func (b *bucket) Stream(ctx context.Context, name string, r io.Reader, size int64) error {
+	return b.client.PutObjectStream(ctx, b.bucket, path.Join(b.prefix, name), r, size)
+}
+
+// webhook posts artifacts to an http endpoint. The artifact
+// name is sent in the X-Artifact-Name header.
+type webhook struct {
+	endpoint string
+	token    string
+}
+
+func (w *webhook) Upload(ctx context.Context, name string, data []byte) error {
+	return w.Stream(ctx, name, bytes.NewReader(data), int64(len(data)))
+}
+
+func (w *webhook) Stream(ctx context.Context, name string, r io.Reader, size int64) error {
+	req, err := http.NewRequest("POST", w.endpoint, ioutil.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Artifact-Name", name)
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return fmt.Errorf("artifact: endpoint returned status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package artifact
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/aws"
+
+	"github.com/h2non/gock"
+)
+
+var noContext = context.Background()
+
+func TestNew(t *testing.T) {
+	client := &aws.Client{Region: "us-east-1"}
+	tests := []struct {
+		destination string
+		client      *aws.Client
+		invalid     bool
+	}{
+		{destination: "s3://coverage/drone", client: client},
+		{destination: "s3://coverage/drone", client: nil, invalid: true},
+		{destination: "https://coverage.company.com/upload"},
+		{destination: "ftp://coverage.company.com", invalid: true},
+	}
+	for _, test := range tests {
+		_, err := New(test.destination, "", test.client)
+		if test.invalid && err == nil {
+			t.Errorf("Expect error for destination %s", test.destination)
+		}
+		if !test.invalid && err != nil {
+			t.Errorf("Expect destination %s valid, got %s", test.destination, err)
+		}
+	}
+}
+
+func TestUpload_S3(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("https://coverage.s3.us-east-1.amazonaws.com").
+		Put("/drone/octocat/hello-world/42/lcov.info").
+		MatchHeader("X-Amz-Content-Sha256", ".+").
+		MatchHeader("Authorization", "^AWS4-HMAC-SHA256").
+		BodyString("TN:").
+		Reply(200)
+
+	client := &aws.Client{
+		Region:      "us-east-1",
+		Credentials: aws.Static("AKIDEXAMPLE", "secret", ""),
+	}
+	uploader, err := New("s3://coverage/drone", "", client)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = uploader.Upload(noContext, "octocat/hello-world/42/lcov.info", []byte("TN:"))
+	if err != nil {
+		t.Error(err)
+	}
+	if gock.IsPending() {
+		t.Errorf("Unfinished requests")
+	}
+}
+
+func TestStream_S3(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("https://diagnostics.s3.us-east-1.amazonaws.com").
+		Put("/drone/octocat/hello-world/42/raw.log").
+		MatchHeader("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD").
+		MatchHeader("Authorization", "^AWS4-HMAC-SHA256").
+		BodyString("hello world").
+		Reply(200)
+
+	client := &aws.Client{
+		Region:      "us-east-1",
+		Credentials: aws.Static("AKIDEXAMPLE", "secret", ""),
+	}
+	uploader, err := New("s3://diagnostics/drone", "", client)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r := strings.NewReader("hello world")
+	err = uploader.Stream(noContext, "octocat/hello-world/42/raw.log", r, r.Size())
+	if err != nil {
+		t.Error(err)
+	}
+	if gock.IsPending() {
+		t.Errorf("Unfinished requests")
+	}
+}
+
+func TestUpload_Webhook(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("https://coverage.company.com").
+		Post("/upload").
+		MatchHeader("X-Artifact-Name", "octocat/hello-world/42/lcov.info").
+		MatchHeader("Authorization", "Bearer secret").
+		MatchHeader("Content-Type", "application/octet-stream").
+		Reply(200)
+
+	uploader, err := New("https://coverage.company.com/upload", "secret", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = uploader.Upload(noContext, "octocat/hello-world/42/lcov.info", []byte("TN:"))
+	if err != nil {
+		t.Error(err)
+	}
+	if gock.IsPending() {
+		t.Errorf("Unfinished requests")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package aws provides a minimal client for the AWS Secrets
+// Manager, Systems Manager Parameter Store and S3 APIs.
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+)
+
+// ErrNotFound is returned when the secret or parameter does
+// not exist.
+var ErrNotFound = errors.New("aws: secret not found")
+
+// Client provides an aws client.
+type Client struct {
+	Client      *http.Client
+	Dumper      logger.Dumper
+	Region      string
+	Endpoint    string
+	Credentials Credentials
+}
+
+// GetSecretValue returns the secret value from the secrets
+// manager service.
+func (c *Client) GetSecretValue(ctx context.Context, id string) ([]byte, error) {
+	in := map[string]string{"SecretId": id}
+	out := new(secretValue)
+	err := c.do(ctx, "secretsmanager", "secretsmanager.GetSecretValue", &in, out)
+	if err != nil {
+		return nil, err
+	}
+	if out.SecretString != "" {
+		return []byte(out.SecretString), nil
+	}
+	return base64.StdEncoding.DecodeString(out.SecretBinary)
+}
+
+// GetParameter returns the decrypted parameter value from
+// the parameter store service.
+func (c *Client) GetParameter(ctx context.Context, name string) ([]byte, error) {
+	in := map[string]interface{}{
+		"Name":           name,
+		"WithDecryption": true,
+	}
+	out := new(parameterValue)
+	err := c.do(ctx, "ssm", "AmazonSSM.GetParameter", &in, out)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out.Parameter.Value), nil
+}
+
+// do makes a signed json rpc request to the target service.
+func (c *Client) do(ctx context.Context, service, target string, in, out interface{}) error {
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, c.Region)
+	}
+
+	body, _ := json.Marshal(in)
+	req, err := http.NewRequest("POST", endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	sign(req, body, creds, c.Region, service, time.Now())
+
+	if c.Dumper != nil {
+		c.Dumper.DumpRequest(req)
+	}
+
+	res, err := c.client().Do(req)
+	if res != nil && res.Body != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.Dumper != nil {
+		c.Dumper.DumpResponse(res)
+	}
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode > 299 {
+		apierr := new(Error)
+		json.Unmarshal(raw, apierr)
+		switch apierr.Type() {
+		case "ResourceNotFoundException", "ParameterNotFound":
+			return ErrNotFound
+		}
+		if apierr.Message == "" {
+			apierr.Message = http.StatusText(res.StatusCode)
+		}
+		return apierr
+	}
+	return json.Unmarshal(raw, out)
+}
+
+func (c *Client) client() *http.Client {
+	if c.Client == nil {
+		return http.DefaultClient
+	}
+	return c.Client
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package aws
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/secret"
+	"github.com/h2non/gock"
+)
+
+// This test verifies the request signature using the
+// get-vanilla example from the aws signature v4 test suite.
+func TestSign(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds := &Value{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	sign(req, nil, creds, "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Want authorization %q, got %q", want, got)
+	}
+}
+
+func TestProvider_SecretsManager(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("https://secretsmanager.us-east-1.amazonaws.com").
+		Post("/").
+		MatchHeader("X-Amz-Target", "secretsmanager.GetSecretValue").
+		Reply(200).
+		Type("application/x-amz-json-1.1").
+		File("testdata/secret.json")
+
+	client := &Client{
+		Region:      "us-east-1",
+		Credentials: Static("AKIDEXAMPLE", "secret", ""),
+	}
+	conf := &manifest.Manifest{
+		Resources: []manifest.Resource{
+			&manifest.Secret{
+				Kind: "secret",
+				Name: "password",
+				Get: manifest.SecretGet{
+					Path: "drone/signing",
+					Name: "password",
+				},
+			},
+		},
+	}
+	provider := NewProvider(client, ServiceSecretsManager, "")
+	got, err := provider.Find(context.Background(), &secret.Request{
+		Name:  "password",
+		Conf:  conf,
+		Build: &drone.Build{Event: drone.EventPush},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got == nil || got.Data != "correct-horse-battery-staple" {
+		t.Errorf("Unexpected secret value")
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestProvider_ParameterStore(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("https://ssm.us-east-1.amazonaws.com").
+		Post("/").
+		MatchHeader("X-Amz-Target", "AmazonSSM.GetParameter").
+		AddMatcher(func(req *http.Request, _ *gock.Request) (bool, error) {
+			return strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256"), nil
+		}).
+		Reply(200).
+		Type("application/x-amz-json-1.1").
+		File("testdata/parameter.json")
+
+	client := &Client{
+		Region:      "us-east-1",
+		Credentials: Static("AKIDEXAMPLE", "secret", ""),
+	}
+	provider := NewProvider(client, ServiceParameterStore, "/drone/")
+	got, err := provider.Find(context.Background(), &secret.Request{
+		Name:  "token",
+		Build: &drone.Build{Event: drone.EventPush},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got == nil || got.Data != "3DA541559918A808C2402BBA5012F6C60B27661C" {
+		t.Errorf("Unexpected parameter value")
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestProvider_NotFound(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("https://ssm.us-east-1.amazonaws.com").
+		Post("/").
+		Reply(400).
+		Type("application/x-amz-json-1.1").
+		BodyString(`{"__type":"ParameterNotFound","message":""}`)
+
+	client := &Client{
+		Region:      "us-east-1",
+		Credentials: Static("AKIDEXAMPLE", "secret", ""),
+	}
+	provider := NewProvider(client, ServiceParameterStore, "/drone/")
+	got, err := provider.Find(context.Background(), &secret.Request{
+		Name:  "token",
+		Build: &drone.Build{Event: drone.EventPush},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if got != nil {
+		t.Errorf("Expect nil secret")
+	}
+}
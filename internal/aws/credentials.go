@@ -0,0 +1,176 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default instance metadata service endpoint.
+const metadataEndpoint = "http://169.254.169.254"
+
+// errNoCredentials is returned when credentials cannot be
+// sourced from any provider.
+var errNoCredentials = errors.New("aws: no credentials found")
+
+// Value provides aws credentials.
+type Value struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// Credentials is the interface that must be implemented by
+// a credentials provider.
+type Credentials interface {
+	Retrieve(context.Context) (*Value, error)
+}
+
+// Chain returns a credentials provider that returns the
+// credentials from the first provider that succeeds.
+func Chain(providers ...Credentials) Credentials {
+	return chain(providers)
+}
+
+type chain []Credentials
+
+func (c chain) Retrieve(ctx context.Context) (*Value, error) {
+	for _, provider := range c {
+		value, err := provider.Retrieve(ctx)
+		if err == nil {
+			return value, nil
+		}
+	}
+	return nil, errNoCredentials
+}
+
+// Static returns a static credentials provider. If the
+// access key is empty the provider returns an error.
+func Static(key, secret, token string) Credentials {
+	return &static{
+		AccessKeyID:     key,
+		SecretAccessKey: secret,
+		SessionToken:    token,
+	}
+}
+
+type static Value
+
+func (s *static) Retrieve(context.Context) (*Value, error) {
+	if s.AccessKeyID == "" {
+		return nil, errNoCredentials
+	}
+	v := Value(*s)
+	return &v, nil
+}
+
+// Environ returns a credentials provider that sources the
+// credentials from the standard environment variables.
+func Environ() Credentials {
+	return Static(
+		os.Getenv("AWS_ACCESS_KEY_ID"),
+		os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		os.Getenv("AWS_SESSION_TOKEN"),
+	)
+}
+
+// InstanceRole returns a credentials provider that sources
+// temporary credentials for the instance role from the ec2
+// instance metadata service.
+func InstanceRole() Credentials {
+	return &instanceRole{endpoint: metadataEndpoint}
+}
+
+type instanceRole struct {
+	endpoint string
+	client   *http.Client
+
+	mu     sync.Mutex
+	cached *Value
+}
+
+func (r *instanceRole) Retrieve(ctx context.Context) (*Value, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// re-use cached credentials until five minutes before
+	// the expiration time.
+	if r.cached != nil && time.Now().Add(time.Minute*5).Before(r.cached.Expiration) {
+		return r.cached, nil
+	}
+
+	token, err := r.get(ctx, "PUT", "/latest/api/token", "")
+	if err != nil {
+		return nil, err
+	}
+	role, err := r.get(ctx, "GET", "/latest/meta-data/iam/security-credentials/", token)
+	if err != nil {
+		return nil, err
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+	raw, err := r.get(ctx, "GET", "/latest/meta-data/iam/security-credentials/"+role, token)
+	if err != nil {
+		return nil, err
+	}
+
+	out := struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}{}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, err
+	}
+	r.cached = &Value{
+		AccessKeyID:     out.AccessKeyID,
+		SecretAccessKey: out.SecretAccessKey,
+		SessionToken:    out.Token,
+		Expiration:      out.Expiration,
+	}
+	return r.cached, nil
+}
+
+// get makes a request to the instance metadata service
+// using the session-oriented (v2) protocol.
+func (r *instanceRole) get(ctx context.Context, method, path, token string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	req, err := http.NewRequest(method, r.endpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	if token == "" {
+		req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	} else {
+		req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+	}
+
+	client := r.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return "", errNoCredentials
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	return string(body), err
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/secret"
+)
+
+// Supported secret services.
+const (
+	ServiceSecretsManager = "secretsmanager"
+	ServiceParameterStore = "ssm"
+)
+
+// NewProvider returns a new aws secret provider. Secrets
+// referenced in the yaml by a named secret resource with a
+// get path are read from that secret id or parameter name,
+// and the optional get name selects a key from a json
+// encoded secret. Otherwise the secret name is appended to
+// the prefix, if set.
+func NewProvider(client *Client, service, prefix string) secret.Provider {
+	return &provider{
+		client:  client,
+		service: service,
+		prefix:  prefix,
+	}
+}
+
+type provider struct {
+	client  *Client
+	service string
+	prefix  string
+}
+
+func (p *provider) Find(ctx context.Context, in *secret.Request) (*drone.Secret, error) {
+	if p.client == nil {
+		return nil, nil
+	}
+
+	logger := logger.FromContext(ctx).
+		WithField("name", in.Name).
+		WithField("kind", "secret")
+
+	path, key, ok := p.lookup(in.Conf, in.Name)
+	if !ok {
+		logger.Trace("secret: aws: no matching secret")
+		return nil, nil
+	}
+
+	// secrets are restricted from pull requests, consistent
+	// with the behavior of the static secret provider.
+	if in.Build != nil && in.Build.Event == drone.EventPullRequest {
+		logger.Trace("secret: aws: restricted from pull requests")
+		return nil, nil
+	}
+
+	var data []byte
+	var err error
+	switch p.service {
+	case ServiceParameterStore:
+		data, err = p.client.GetParameter(ctx, path)
+	default:
+		data, err = p.client.GetSecretValue(ctx, path)
+	}
+	if err == ErrNotFound {
+		logger.Trace("secret: aws: no matching path")
+		return nil, nil
+	}
+	if err != nil {
+		logger.WithError(err).Debug("secret: aws: cannot get secret")
+		return nil, err
+	}
+
+	// if a key is specified, the secret is expected to be
+	// a json object and the key value is extracted.
+	if key != "" {
+		values := map[string]interface{}{}
+		if err := json.Unmarshal(data, &values); err != nil {
+			logger.WithError(err).Debug("secret: aws: cannot parse secret")
+			return nil, nil
+		}
+		switch v := values[key].(type) {
+		case nil:
+			data = nil
+		case string:
+			data = []byte(v)
+		default:
+			data = []byte(fmt.Sprint(v))
+		}
+	}
+
+	if len(data) == 0 {
+		logger.Trace("secret: aws: secret is empty")
+		return nil, nil
+	}
+
+	logger.Trace("secret: aws: found matching secret")
+	return &drone.Secret{
+		Name: in.Name,
+		Data: string(data),
+	}, nil
+}
+
+// lookup returns the secret id and optional key for the
+// named secret.
+func (p *provider) lookup(conf *manifest.Manifest, name string) (path, key string, ok bool) {
+	if conf != nil {
+		for _, resource := range conf.Resources {
+			secret, ok := resource.(*manifest.Secret)
+			if !ok {
+				continue
+			}
+			if secret.Name != name {
+				continue
+			}
+			if secret.Get.Path == "" {
+				continue
+			}
+			return secret.Get.Path, secret.Get.Name, true
+		}
+	}
+	if p.prefix == "" {
+		return
+	}
+	return p.prefix + name, "", true
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package aws
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// unsignedPayload is the payload hash of requests with a
+// streamed body, which is not included in the signature.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// PutObject uploads the object to the s3 bucket.
+func (c *Client) PutObject(ctx context.Context, bucket, key string, data []byte) error {
+	return c.putObject(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), hashHex(data))
+}
+
+// PutObjectStream uploads the object to the s3 bucket,
+// streaming the object from the reader. The size of the
+// object must be known in advance.
+func (c *Client) PutObjectStream(ctx context.Context, bucket, key string, r io.Reader, size int64) error {
+	return c.putObject(ctx, bucket, key, r, size, unsignedPayload)
+}
+
+func (c *Client) putObject(ctx context.Context, bucket, key string, body io.Reader, size int64, payload string) error {
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the object key is escaped, with the exception of the
+	// path separator.
+	path := (&url.URL{Path: strings.TrimPrefix(key, "/")}).EscapedPath()
+
+	endpoint := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, c.Region, path)
+	if c.Endpoint != "" {
+		endpoint = fmt.Sprintf("%s/%s/%s", c.Endpoint, bucket, path)
+	}
+
+	// the request body is wrapped to prevent the http client
+	// from closing the reader.
+	req, err := http.NewRequest("PUT", endpoint, ioutil.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	signPayload(req, payload, creds, c.Region, "s3", time.Now())
+
+	if c.Dumper != nil {
+		c.Dumper.DumpRequest(req)
+	}
+
+	res, err := c.client().Do(req)
+	if res != nil && res.Body != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.Dumper != nil {
+		c.Dumper.DumpResponse(res)
+	}
+
+	if res.StatusCode > 299 {
+		return &Error{Message: http.StatusText(res.StatusCode)}
+	}
+	return nil
+}
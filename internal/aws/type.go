@@ -0,0 +1,47 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package aws
+
+import "strings"
+
+type (
+	// secretValue provides the GetSecretValue API response.
+	secretValue struct {
+		Name         string `json:"Name"`
+		SecretString string `json:"SecretString"`
+		SecretBinary string `json:"SecretBinary"`
+	}
+
+	// parameterValue provides the GetParameter API response.
+	parameterValue struct {
+		Parameter struct {
+			Name  string `json:"Name"`
+			Type  string `json:"Type"`
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+)
+
+// Error represents an API error.
+type Error struct {
+	Code    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// Type returns the error type without the service namespace.
+func (e *Error) Type() string {
+	if i := strings.LastIndex(e.Code, "#"); i != -1 {
+		return e.Code[i+1:]
+	}
+	return e.Code
+}
+
+// Error returns the error message.
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return "aws: " + e.Message
+	}
+	return "aws: " + e.Type() + ": " + e.Message
+}
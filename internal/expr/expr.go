@@ -0,0 +1,359 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package expr evaluates boolean expressions used in step
+// conditions.
+//
+// An expression compares variables and string literals with
+// the == and != operators, and the =~ and !~ regular
+// expression operators. Comparisons are combined with the &&,
+// || and ! operators, and grouped with parentheses. A variable
+// without a comparison is true if the value is not empty and
+// not equal to false.
+//
+//	event == "push" && branch =~ "^release/"
+//	message =~ "\[deploy\]" || params.DEPLOY
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Expr is a parsed expression.
+type Expr struct {
+	root node
+}
+
+// Parse parses the expression.
+func Parse(s string) (*Expr, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("expr: unexpected %q", tok.text)
+	}
+	return &Expr{root: root}, nil
+}
+
+// Eval evaluates the expression against the variables.
+// Undefined variables evaluate to an empty string.
+func (e *Expr) Eval(vars map[string]string) bool {
+	return e.root.eval(vars)
+}
+
+// Eval parses and evaluates the expression against the
+// variables.
+func Eval(s string, vars map[string]string) (bool, error) {
+	e, err := Parse(s)
+	if err != nil {
+		return false, err
+	}
+	return e.Eval(vars), nil
+}
+
+//
+// syntax tree
+//
+
+type node interface {
+	eval(vars map[string]string) bool
+}
+
+type (
+	orNode  struct{ left, right node }
+	andNode struct{ left, right node }
+	notNode struct{ expr node }
+
+	// truthNode evaluates the operand as a boolean.
+	truthNode struct{ operand operand }
+
+	// compareNode compares two operands for equality.
+	compareNode struct {
+		left, right operand
+		negate      bool
+	}
+
+	// matchNode matches the operand against a regular
+	// expression, compiled when the expression is parsed.
+	matchNode struct {
+		left   operand
+		re     *regexp.Regexp
+		negate bool
+	}
+)
+
+func (n *orNode) eval(vars map[string]string) bool {
+	return n.left.eval(vars) || n.right.eval(vars)
+}
+
+func (n *andNode) eval(vars map[string]string) bool {
+	return n.left.eval(vars) && n.right.eval(vars)
+}
+
+func (n *notNode) eval(vars map[string]string) bool {
+	return !n.expr.eval(vars)
+}
+
+func (n *truthNode) eval(vars map[string]string) bool {
+	v := n.operand.value(vars)
+	return v != "" && v != "false"
+}
+
+func (n *compareNode) eval(vars map[string]string) bool {
+	return (n.left.value(vars) == n.right.value(vars)) != n.negate
+}
+
+func (n *matchNode) eval(vars map[string]string) bool {
+	return n.re.MatchString(n.left.value(vars)) != n.negate
+}
+
+// operand is a variable or a string literal.
+type operand struct {
+	name    string
+	literal string
+	isVar   bool
+}
+
+func (o operand) value(vars map[string]string) string {
+	if o.isVar {
+		return vars[o.name]
+	}
+	return o.literal
+}
+
+//
+// parser
+//
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	switch p.peek().kind {
+	case tokenNot:
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{expr}, nil
+	case tokenLParen:
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.kind != tokenRParen {
+			return nil, fmt.Errorf("expr: expected ) but found %q", tok.text)
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	switch op.kind {
+	case tokenEq, tokenNeq:
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &compareNode{left, right, op.kind == tokenNeq}, nil
+	case tokenMatch, tokenNotMatch:
+		p.next()
+		right := p.next()
+		if right.kind != tokenString {
+			return nil, fmt.Errorf("expr: expected regular expression but found %q", right.text)
+		}
+		re, err := regexp.Compile(right.text)
+		if err != nil {
+			return nil, fmt.Errorf("expr: %s", err)
+		}
+		return &matchNode{left, re, op.kind == tokenNotMatch}, nil
+	}
+	return &truthNode{left}, nil
+}
+
+func (p *parser) parseOperand() (operand, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenIdent:
+		switch tok.text {
+		case "true", "false":
+			return operand{literal: tok.text}, nil
+		}
+		return operand{name: tok.text, isVar: true}, nil
+	case tokenString:
+		return operand{literal: tok.text}, nil
+	case tokenEOF:
+		return operand{}, fmt.Errorf("expr: unexpected end of expression")
+	}
+	return operand{}, fmt.Errorf("expr: unexpected %q", tok.text)
+}
+
+//
+// lexer
+//
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenEq
+	tokenNeq
+	tokenMatch
+	tokenNotMatch
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// two character operators.
+var operators = map[string]tokenKind{
+	"&&": tokenAnd,
+	"||": tokenOr,
+	"==": tokenEq,
+	"!=": tokenNeq,
+	"=~": tokenMatch,
+	"!~": tokenNotMatch,
+}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case i+1 < len(s) && operators[s[i:i+2]] != 0:
+			tokens = append(tokens, token{operators[s[i:i+2]], s[i : i+2]})
+			i += 2
+		case c == '!':
+			tokens = append(tokens, token{tokenNot, "!"})
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")"})
+			i++
+		case c == '"' || c == '\'':
+			text, n, err := lexString(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{tokenString, text})
+			i += n
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(s) && isIdent(s[j]) {
+				j++
+			}
+			tokens = append(tokens, token{tokenIdent, s[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("expr: unexpected character %q", c)
+		}
+	}
+	return append(tokens, token{tokenEOF, ""}), nil
+}
+
+// helper function returns the unquoted string literal at the
+// start of the string, and the number of bytes consumed. The
+// quote character and backslash may be escaped with a
+// backslash. Other escape sequences are preserved, so that
+// regular expression escapes do not need to be doubled.
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(s) && (s[i+1] == quote || s[i+1] == '\\'):
+			b.WriteByte(s[i+1])
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("expr: unterminated string")
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdent(c byte) bool {
+	return isIdentStart(c) || c == '.' || c == '-' || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package expr
+
+import "testing"
+
+func TestEval(t *testing.T) {
+	vars := map[string]string{
+		"event":         "push",
+		"branch":        "release/1.2",
+		"message":       "bump version [deploy]",
+		"params.DEPLOY": "true",
+		"params.DRY":    "false",
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`event == "push"`, true},
+		{`event != "push"`, false},
+		{`event == 'tag'`, false},
+		{`branch =~ "^release/"`, true},
+		{`branch !~ "^release/"`, false},
+		{`message =~ "\[deploy\]"`, true},
+		{`message =~ "\[skip deploy\]"`, false},
+		{`params.DEPLOY`, true},
+		{`params.DRY`, false},
+		{`params.MISSING`, false},
+		{`!params.MISSING`, true},
+		{`event == "push" && branch =~ "^release/"`, true},
+		{`event == "tag" || branch == "release/1.2"`, true},
+		{`event == "tag" || event == "push" && params.DRY`, false},
+		{`(event == "tag" || event == "push") && !params.DRY`, true},
+		{`true`, true},
+		{`false || event == "push"`, true},
+		{`"it's" == 'it\'s'`, true},
+	}
+	for _, test := range tests {
+		got, err := Eval(test.expr, vars)
+		if err != nil {
+			t.Errorf("Unexpected error evaluating %s: %s", test.expr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Want %v for %s, got %v", test.want, test.expr, got)
+		}
+	}
+}
+
+func TestParse_Error(t *testing.T) {
+	tests := []string{
+		``,
+		`event ==`,
+		`event == "push`,
+		`(event == "push"`,
+		`event == "push")`,
+		`branch =~ "["`,
+		`branch =~ release`,
+		`event = "push"`,
+		`event && || branch`,
+	}
+	for _, expr := range tests {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Want error parsing %s", expr)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package junit parses JUnit XML test reports.
+package junit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Report summarizes one or more test reports.
+type Report struct {
+	Tests    int     `json:"tests"`
+	Failures int     `json:"failures"`
+	Errors   int     `json:"errors"`
+	Skipped  int     `json:"skipped"`
+	Failed   []*Case `json:"failed,omitempty"`
+}
+
+// Case represents a failed test case.
+type Case struct {
+	Suite   string `json:"suite,omitempty"`
+	Name    string `json:"name"`
+	Message string `json:"message,omitempty"`
+}
+
+// Parse parses the JUnit XML test report. The root element
+// may be a testsuites element or a single testsuite element.
+func Parse(r io.Reader) (*Report, error) {
+	root := new(suite)
+	if err := xml.NewDecoder(r).Decode(root); err != nil {
+		return nil, err
+	}
+	report := new(Report)
+	report.add(root, "")
+	return report, nil
+}
+
+// Merge adds the results of the other report.
+func (r *Report) Merge(other *Report) {
+	r.Tests += other.Tests
+	r.Failures += other.Failures
+	r.Errors += other.Errors
+	r.Skipped += other.Skipped
+	r.Failed = append(r.Failed, other.Failed...)
+}
+
+// String returns a summary of the test results.
+func (r *Report) String() string {
+	return fmt.Sprintf("%d tests, %d failures, %d errors, %d skipped",
+		r.Tests, r.Failures, r.Errors, r.Skipped)
+}
+
+// add adds the test cases in the suite, and nested suites,
+// to the report.
+func (r *Report) add(s *suite, parent string) {
+	name := s.Name
+	if name == "" {
+		name = parent
+	}
+	for _, c := range s.Cases {
+		r.Tests++
+		suite := c.Classname
+		if suite == "" {
+			suite = name
+		}
+		switch {
+		case c.Failure != nil:
+			r.Failures++
+			r.Failed = append(r.Failed, c.failed(suite, c.Failure))
+		case c.Error != nil:
+			r.Errors++
+			r.Failed = append(r.Failed, c.failed(suite, c.Error))
+		case c.Skipped != nil:
+			r.Skipped++
+		}
+	}
+	for _, child := range s.Suites {
+		r.add(child, name)
+	}
+}
+
+type suite struct {
+	Name   string      `xml:"name,attr"`
+	Suites []*suite    `xml:"testsuite"`
+	Cases  []*testcase `xml:"testcase"`
+}
+
+type testcase struct {
+	Name      string  `xml:"name,attr"`
+	Classname string  `xml:"classname,attr"`
+	Failure   *result `xml:"failure"`
+	Error     *result `xml:"error"`
+	Skipped   *result `xml:"skipped"`
+}
+
+type result struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// failed returns the failed test case.
+func (c *testcase) failed(suite string, res *result) *Case {
+	message := res.Message
+	if message == "" {
+		message = strings.TrimSpace(res.Text)
+	}
+	// multi-line messages are truncated to the first line
+	// to keep the summary readable.
+	if i := strings.IndexByte(message, '\n'); i != -1 {
+		message = message[:i]
+	}
+	return &Case{
+		Suite:   suite,
+		Name:    c.Name,
+		Message: message,
+	}
+}
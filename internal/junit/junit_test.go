@@ -0,0 +1,84 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package junit
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	f, err := os.Open("testdata/testsuites.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err := Parse(f)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := &Report{
+		Tests:    5,
+		Failures: 1,
+		Errors:   1,
+		Skipped:  1,
+		Failed: []*Case{
+			{
+				Suite:   "AppTests.LoginTests",
+				Name:    "testLogout",
+				Message: `XCTAssertEqual failed: ("1") is not equal to ("2")`,
+			},
+			{
+				Suite:   "AppTests.NetworkTests",
+				Name:    "testFetch",
+				Message: "Crash: EXC_BAD_ACCESS",
+			},
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Unexpected report")
+		t.Log(diff)
+	}
+}
+
+func TestParse_Suite(t *testing.T) {
+	f, err := os.Open("testdata/testsuite.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err := Parse(f)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := got.String(), "2 tests, 0 failures, 0 errors, 0 skipped"; got != want {
+		t.Errorf("Want summary %q, got %q", want, got)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	_, err := Parse(strings.NewReader("not xml"))
+	if err == nil {
+		t.Errorf("Expect error parsing invalid xml")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	r := &Report{Tests: 2, Failures: 1, Failed: []*Case{{Name: "a"}}}
+	r.Merge(&Report{Tests: 3, Errors: 1, Skipped: 1, Failed: []*Case{{Name: "b"}}})
+	if got, want := r.String(), "5 tests, 1 failures, 1 errors, 1 skipped"; got != want {
+		t.Errorf("Want summary %q, got %q", want, got)
+	}
+	if got, want := len(r.Failed), 2; got != want {
+		t.Errorf("Want %d failed cases, got %d", want, got)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package naming generates and parses virtual machine names.
+//
+// Names are composed of a prefix, the creation time encoded
+// as a fixed-width base36 unix timestamp, and a random suffix.
+// Encoding the creation time in the name allows the runner to
+// calculate the age of a virtual machine configuration, even
+// when it has never been deployed.
+package naming
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+// DefaultPrefix is the default virtual machine name prefix.
+const DefaultPrefix = "drone"
+
+// WarmPrefix is the name prefix of warm virtual machines,
+// which are provisioned ahead of time. Warm virtual machines
+// use a separate prefix so that garbage collection applies a
+// longer ttl, and does not purge them while idle.
+const WarmPrefix = "dronewarm"
+
+const (
+	timeLen   = 7
+	randomLen = 13
+)
+
+// names created before the creation time was encoded in the
+// name have the same length and charset, with a random time
+// part. Times that are earlier than the encoding was
+// introduced, or that are in the future, are rejected so that
+// the age of these names is not misreported. A small
+// fraction of legacy names decode to a plausible time, and
+// cannot be distinguished.
+var (
+	minCreated = time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	maxSkew    = time.Hour * 24
+)
+
+var chars = []byte("abcdefghijklmnopqrstuvwxyz0123456789")
+
+// New returns a new virtual machine name with the prefix.
+func New(prefix string) string {
+	return NewAt(prefix, time.Now())
+}
+
+// NewAt returns a new virtual machine name with the prefix
+// and the given creation time.
+func NewAt(prefix string, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 36)
+	if len(ts) < timeLen {
+		ts = strings.Repeat("0", timeLen-len(ts)) + ts
+	}
+	return prefix + ts + uniuri.NewLenChars(randomLen, chars)
+}
+
+// Match returns true if the name was generated with the
+// prefix.
+func Match(prefix, name string) bool {
+	_, ok := Created(prefix, name)
+	return ok
+}
+
+// Created returns the creation time encoded in the name. It
+// returns false if the name was not generated with the
+// prefix, or if the name does not encode a valid time.
+func Created(prefix, name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return time.Time{}, false
+	}
+	rest := name[len(prefix):]
+	if len(rest) != timeLen+randomLen || !validChars(rest) {
+		return time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(rest[:timeLen], 36, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	created := time.Unix(sec, 0)
+	if created.Before(minCreated) || created.After(time.Now().Add(maxSkew)) {
+		return time.Time{}, false
+	}
+	return created, true
+}
+
+// helper function returns true if the string only contains
+// characters used to generate names.
+func validChars(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package naming
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNaming(t *testing.T) {
+	now := time.Unix(1588352553, 0)
+	name := NewAt(DefaultPrefix, now)
+	if got, want := len(name), 25; got != want {
+		t.Errorf("Want name length %d, got %d", want, got)
+	}
+	created, ok := Created(DefaultPrefix, name)
+	if !ok {
+		t.Errorf("Expect name %q matches prefix", name)
+	}
+	if !created.Equal(now) {
+		t.Errorf("Want created %s, got %s", now, created)
+	}
+}
+
+func TestNaming_NoMatch(t *testing.T) {
+	tests := []string{
+		"myorkavm",
+		"dronexyz",
+		"drone-doctor-abcdefgh",
+		"drone!!!!!!!abcdefghijklm",
+		"drone+0qa1b2abcdefghijklm",
+		"drone0QA1B2Cabcdefghijklm",
+		"drone0qa1b2cABCDEFGHIJKLM",
+	}
+	for _, name := range tests {
+		if Match(DefaultPrefix, name) {
+			t.Errorf("Expect name %q does not match", name)
+		}
+	}
+}
+
+func TestNaming_Legacy(t *testing.T) {
+	// legacy names have the same length and charset, with a
+	// random time part that decodes to an implausible time.
+	tests := []string{
+		"dronezk3x9qa7b2mpl0c4rtyu",
+		"drone1b2mpl0abcdefghijklm",
+		"drone0000000abcdefghijklm",
+		"drone0pzzzzzabcdefghijklm",
+	}
+	for _, name := range tests {
+		if _, ok := Created(DefaultPrefix, name); ok {
+			t.Errorf("Expect legacy name %q has no creation time", name)
+		}
+	}
+}
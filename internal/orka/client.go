@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 
 	"github.com/drone/runner-go/logger"
 
@@ -41,7 +42,7 @@ func (c *Client) Create(ctx context.Context, config *Config) (*Response, error)
 	}
 	uri := fmt.Sprintf("%s/resources/vm/create", c.Endpoint)
 	out := new(Response)
-	err := c.do("POST", uri, &in, out)
+	err := c.do(ctx, "POST", uri, &in, out)
 	if err != nil {
 		return nil, err
 	}
@@ -50,10 +51,42 @@ func (c *Client) Create(ctx context.Context, config *Config) (*Response, error)
 
 // Deploy deploys a virtual machine.
 func (c *Client) Deploy(ctx context.Context, name string) (*DeployResponse, error) {
-	in := map[string]string{"orka_vm_name": name}
+	return c.DeployTag(ctx, name, "")
+}
+
+// DeployTag deploys a virtual machine to a node with the
+// tag. If the tag is empty the virtual machine is deployed
+// to any available node.
+func (c *Client) DeployTag(ctx context.Context, name, tag string) (*DeployResponse, error) {
+	return c.DeployNode(ctx, name, tag, "")
+}
+
+// DeployNode deploys a virtual machine to the named node.
+// If the node is empty the virtual machine is deployed to
+// any available node with the tag.
+func (c *Client) DeployNode(ctx context.Context, name, tag, node string) (*DeployResponse, error) {
+	return c.DeployMetadata(ctx, name, tag, node, nil)
+}
+
+// DeployMetadata deploys a virtual machine to the named node
+// with the custom metadata, which is displayed with the
+// virtual machine status. Metadata requires an orka version
+// that supports virtual machine metadata.
+func (c *Client) DeployMetadata(ctx context.Context, name, tag, node string, metadata map[string]string) (*DeployResponse, error) {
+	in := map[string]interface{}{"orka_vm_name": name}
+	if len(metadata) != 0 {
+		in["vm_metadata"] = NewMetadata(metadata)
+	}
+	if tag != "" {
+		in["tag"] = tag
+		in["tag_required"] = true
+	}
+	if node != "" {
+		in["orka_node_name"] = node
+	}
 	uri := fmt.Sprintf("%s/resources/vm/deploy", c.Endpoint)
 	out := new(DeployResponse)
-	err := c.do("POST", uri, &in, out)
+	err := c.do(ctx, "POST", uri, &in, out)
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +98,22 @@ func (c *Client) Delete(ctx context.Context, name string) (*Response, error) {
 	in := map[string]string{"orka_vm_name": name}
 	uri := fmt.Sprintf("%s/resources/vm/purge", c.Endpoint)
 	out := new(Response)
-	err := c.do("DELETE", uri, &in, out)
+	err := c.do(ctx, "DELETE", uri, &in, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, getErrors(*out)
+}
+
+// Save saves the virtual machine disk as a new base image.
+func (c *Client) Save(ctx context.Context, name, image string) (*Response, error) {
+	in := map[string]string{
+		"orka_vm_name": name,
+		"new_name":     image,
+	}
+	uri := fmt.Sprintf("%s/resources/image/save", c.Endpoint)
+	out := new(Response)
+	err := c.do(ctx, "POST", uri, &in, out)
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +124,41 @@ func (c *Client) Delete(ctx context.Context, name string) (*Response, error) {
 func (c *Client) Check(ctx context.Context, name string) (*StatusResponse, error) {
 	uri := fmt.Sprintf("%s/resources/vm/status/%s", c.Endpoint, name)
 	out := new(StatusResponse)
-	err := c.do("GET", uri, nil, out)
+	err := c.do(ctx, "GET", uri, nil, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, getErrors(out.Response)
+}
+
+// List returns the list of virtual machines, including
+// virtual machine configurations that are not deployed.
+func (c *Client) List(ctx context.Context) (*ListResponse, error) {
+	uri := fmt.Sprintf("%s/resources/vm/list", c.Endpoint)
+	out := new(ListResponse)
+	err := c.do(ctx, "GET", uri, nil, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, getErrors(out.Response)
+}
+
+// Images returns the list of base images.
+func (c *Client) Images(ctx context.Context) (*ImagesResponse, error) {
+	uri := fmt.Sprintf("%s/resources/image/list", c.Endpoint)
+	out := new(ImagesResponse)
+	err := c.do(ctx, "GET", uri, nil, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, getErrors(out.Response)
+}
+
+// Nodes returns the list of nodes and available resources.
+func (c *Client) Nodes(ctx context.Context) (*NodesResponse, error) {
+	uri := fmt.Sprintf("%s/resources/node/list", c.Endpoint)
+	out := new(NodesResponse)
+	err := c.do(ctx, "GET", uri, nil, out)
 	if err != nil {
 		return nil, err
 	}
@@ -87,19 +169,21 @@ func (c *Client) Check(ctx context.Context, name string) (*StatusResponse, error
 func (c *Client) CheckToken(ctx context.Context) (*TokenResponse, error) {
 	uri := fmt.Sprintf("%s/token", c.Endpoint)
 	out := new(TokenResponse)
-	err := c.do("GET", uri, nil, out)
+	err := c.do(ctx, "GET", uri, nil, out)
 	if err != nil {
 		return nil, err
 	}
 	return out, getErrors(out.Response)
 }
 
-// do makes an http.Request to the target endpoint.
-func (c *Client) do(method, endpoint string, in, out interface{}) error {
+// do makes an http.Request to the target endpoint. The
+// request is canceled when the context is canceled.
+func (c *Client) do(ctx context.Context, method, endpoint string, in, out interface{}) error {
 	req, err := http.NewRequest(method, endpoint, nil)
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 
 	if in != nil {
 		dec, _ := json.Marshal(in)
@@ -143,6 +227,18 @@ func (c *Client) client() *http.Client {
 	return c.Client
 }
 
+// NewMetadata returns the metadata items, sorted by key.
+func NewMetadata(metadata map[string]string) *Metadata {
+	out := new(Metadata)
+	for k, v := range metadata {
+		out.Items = append(out.Items, &MetadataItem{Key: k, Value: v})
+	}
+	sort.Slice(out.Items, func(i, j int) bool {
+		return out.Items[i].Key < out.Items[j].Key
+	})
+	return out
+}
+
 func getErrors(r Response) error {
 	var result error
 	for _, err := range r.Errors {
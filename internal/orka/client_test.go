@@ -8,8 +8,11 @@ import (
 	"context"
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/h2non/gock"
@@ -47,6 +50,96 @@ func TestDeploy(t *testing.T) {
 	}
 }
 
+func TestDeployTag(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://10.221.188.100").
+		Post("resources/vm/deploy").
+		JSON(map[string]interface{}{
+			"orka_vm_name": "test",
+			"tag":          "arm64",
+			"tag_required": true,
+		}).
+		Reply(200).
+		Type("application/json").
+		File("testdata/deploy.json")
+
+	client := &Client{
+		Endpoint: "http://10.221.188.100",
+		Token:    "token",
+	}
+	_, err := client.DeployTag(context.Background(), "test", "arm64")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestDeployNode(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://10.221.188.100").
+		Post("resources/vm/deploy").
+		JSON(map[string]interface{}{
+			"orka_vm_name":   "test",
+			"orka_node_name": "macpro-2",
+		}).
+		Reply(200).
+		Type("application/json").
+		File("testdata/deploy.json")
+
+	client := &Client{
+		Endpoint: "http://10.221.188.100",
+		Token:    "token",
+	}
+	_, err := client.DeployNode(context.Background(), "test", "", "macpro-2")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestDeployMetadata(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://10.221.188.100").
+		Post("resources/vm/deploy").
+		JSON(map[string]interface{}{
+			"orka_vm_name": "test",
+			"vm_metadata": map[string]interface{}{
+				"items": []map[string]string{
+					{"key": "drone_build", "value": "42"},
+					{"key": "drone_repo", "value": "octocat/hello-world"},
+				},
+			},
+		}).
+		Reply(200).
+		Type("application/json").
+		File("testdata/deploy.json")
+
+	client := &Client{
+		Endpoint: "http://10.221.188.100",
+		Token:    "token",
+	}
+	_, err := client.DeployMetadata(context.Background(), "test", "", "", map[string]string{
+		"drone_repo":  "octocat/hello-world",
+		"drone_build": "42",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
 func TestDeployError(t *testing.T) {
 	defer gock.Off()
 
@@ -75,3 +168,148 @@ func dump(v interface{}) {
 	enc.SetIndent("", "  ")
 	enc.Encode(v)
 }
+
+func TestImages(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://10.221.188.100").
+		Get("resources/image/list").
+		Reply(200).
+		Type("application/json").
+		File("testdata/images.json")
+
+	client := &Client{
+		Endpoint: "http://10.221.188.100",
+		Token:    "token",
+	}
+	got, err := client.Images(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := []string{"90GCatalinaSSH.img", "Drone.img"}
+	if diff := cmp.Diff(got.Images, want); diff != "" {
+		t.Errorf("Unexpected Results")
+		t.Log(diff)
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestList(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://10.221.188.100").
+		Get("resources/vm/list").
+		Reply(200).
+		Type("application/json").
+		File("testdata/list.json")
+
+	client := &Client{
+		Endpoint: "http://10.221.188.100",
+		Token:    "token",
+	}
+	got, err := client.List(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := len(got.VirtualMachineResources), 2; got != want {
+		t.Errorf("Want %d virtual machines, got %d", want, got)
+		return
+	}
+	if got, want := got.VirtualMachineResources[0].Status[0].NodeLocation, "macpro-1"; got != want {
+		t.Errorf("Want node %q, got %q", want, got)
+	}
+	if got, want := got.VirtualMachineResources[0].Status[0].Metadata.Value("drone_repo"), "octocat/hello-world"; got != want {
+		t.Errorf("Want metadata repo %q, got %q", want, got)
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestNodes(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://10.221.188.100").
+		Get("resources/node/list").
+		Reply(200).
+		Type("application/json").
+		File("testdata/nodes.json")
+
+	client := &Client{
+		Endpoint: "http://10.221.188.100",
+		Token:    "token",
+	}
+	got, err := client.Nodes(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := len(got.Nodes), 2; got != want {
+		t.Errorf("Want %d nodes, got %d", want, got)
+		return
+	}
+	if !got.Fits(12) {
+		t.Errorf("Want 12 cpu to fit on the cluster")
+	}
+	if got.Fits(13) {
+		t.Errorf("Want 13 cpu to exceed the available capacity")
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestSave(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://10.221.188.100").
+		Post("resources/image/save").
+		MatchType("json").
+		JSON(map[string]string{
+			"orka_vm_name": "test",
+			"new_name":     "Drone-20200504.img",
+		}).
+		Reply(200).
+		Type("application/json").
+		File("testdata/save.json")
+
+	client := &Client{
+		Endpoint: "http://10.221.188.100",
+		Token:    "token",
+	}
+	_, err := client.Save(context.Background(), "test", "Drone-20200504.img")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestDo_Canceled(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client := &Client{
+		Endpoint: server.URL,
+		Token:    "token",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if _, err := client.Check(ctx, "test"); err == nil {
+		t.Errorf("Expect error when the context is canceled")
+	}
+}
@@ -0,0 +1,438 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package orkatest provides an in-process http server that
+// emulates the orka api, for engine and runner integration
+// tests without a cluster.
+package orkatest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+)
+
+// Endpoint names used to configure latencies and failures.
+const (
+	EndpointToken  = "token"
+	EndpointCreate = "create"
+	EndpointDeploy = "deploy"
+	EndpointStatus = "status"
+	EndpointList   = "list"
+	EndpointPurge  = "purge"
+	EndpointImages = "images"
+	EndpointNodes  = "nodes"
+	EndpointSave   = "save"
+)
+
+// Server emulates the orka api. Virtual machines are not
+// provisioned. Deployments allocate the cpu of the node, and
+// report the ssh address of the server, which may point to
+// an ssh server started by the test.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	token     string
+	sshHost   string
+	sshPort   string
+	images    []string
+	nodes     []*orka.Node
+	vms       map[string]*vm
+	latencies map[string]time.Duration
+	failures  map[string][]string
+}
+
+// vm is an emulated virtual machine configuration and its
+// deployment.
+type vm struct {
+	config   orka.Config
+	node     *orka.Node
+	deployed time.Time
+	metadata map[string]string
+}
+
+// NewServer starts and returns a new server. The server has
+// a single ready node with 24 cpu and accepts any token.
+// The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		sshPort: "8822",
+		nodes: []*orka.Node{
+			{
+				Name:           "macpro-1",
+				HostIP:         "127.0.0.1",
+				AvailableCPU:   24,
+				AllocatableCPU: 24,
+				TotalCPU:       24,
+				State:          "READY",
+			},
+		},
+		vms:       map[string]*vm{},
+		latencies: map[string]time.Duration{},
+		failures:  map[string][]string{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Client returns a client configured for the server. The
+// client does not use the default http client, which may be
+// intercepted by http mocks in the same test binary.
+func (s *Server) Client() *orka.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &orka.Client{
+		Client:   s.Server.Client(),
+		Endpoint: s.URL,
+		Token:    s.token,
+	}
+}
+
+// SetToken restricts the server to the bearer token.
+func (s *Server) SetToken(token string) {
+	s.mu.Lock()
+	s.token = token
+	s.mu.Unlock()
+}
+
+// SetImages sets the base images. If no images are set,
+// virtual machines can be created with any image.
+func (s *Server) SetImages(images ...string) {
+	s.mu.Lock()
+	s.images = images
+	s.mu.Unlock()
+}
+
+// SetNodes replaces the cluster nodes.
+func (s *Server) SetNodes(nodes ...*orka.Node) {
+	s.mu.Lock()
+	s.nodes = nodes
+	s.mu.Unlock()
+}
+
+// SetSSHAddress sets the ssh address reported for deployed
+// virtual machines. If the host is empty, the node host ip
+// is reported.
+func (s *Server) SetSSHAddress(host, port string) {
+	s.mu.Lock()
+	s.sshHost = host
+	s.sshPort = port
+	s.mu.Unlock()
+}
+
+// SetLatency delays each response of the endpoint.
+func (s *Server) SetLatency(endpoint string, d time.Duration) {
+	s.mu.Lock()
+	s.latencies[endpoint] = d
+	s.mu.Unlock()
+}
+
+// Fail fails the next n requests to the endpoint with the
+// error message. Use orka.ErrInsufficientCPU to emulate a
+// cluster without capacity.
+func (s *Server) Fail(endpoint string, n int, message string) {
+	s.mu.Lock()
+	for i := 0; i < n; i++ {
+		s.failures[endpoint] = append(s.failures[endpoint], message)
+	}
+	s.mu.Unlock()
+}
+
+// VMs returns the names of the virtual machine configurations.
+func (s *Server) VMs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.vms {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Deployed returns true if the virtual machine is deployed.
+func (s *Server) Deployed(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.vms[name]
+	return ok && v.node != nil
+}
+
+// Metadata returns the custom metadata of the deployed
+// virtual machine.
+func (s *Server) Metadata(name string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.vms[name]; ok {
+		return v.metadata
+	}
+	return nil
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	var endpoint string
+	switch {
+	case r.Method == "GET" && path == "token":
+		endpoint = EndpointToken
+	case r.Method == "POST" && path == "resources/vm/create":
+		endpoint = EndpointCreate
+	case r.Method == "POST" && path == "resources/vm/deploy":
+		endpoint = EndpointDeploy
+	case r.Method == "GET" && strings.HasPrefix(path, "resources/vm/status/"):
+		endpoint = EndpointStatus
+	case r.Method == "GET" && path == "resources/vm/list":
+		endpoint = EndpointList
+	case r.Method == "DELETE" && path == "resources/vm/purge":
+		endpoint = EndpointPurge
+	case r.Method == "GET" && path == "resources/image/list":
+		endpoint = EndpointImages
+	case r.Method == "GET" && path == "resources/node/list":
+		endpoint = EndpointNodes
+	case r.Method == "POST" && path == "resources/image/save":
+		endpoint = EndpointSave
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	latency := s.latencies[endpoint]
+	s.mu.Unlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeError(w, errors.New("invalid signature"))
+		return
+	}
+	if failures := s.failures[endpoint]; len(failures) != 0 {
+		s.failures[endpoint] = failures[1:]
+		writeError(w, errors.New(failures[0]))
+		return
+	}
+
+	in := map[string]interface{}{}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&in)
+	}
+	name, _ := in["orka_vm_name"].(string)
+
+	switch endpoint {
+	case EndpointToken:
+		writeJSON(w, &orka.TokenResponse{
+			Authenticated: true,
+			Email:         "noreply@localhost",
+		})
+	case EndpointCreate:
+		if err := s.create(in); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, &orka.Response{Message: "Successfully created VM"})
+	case EndpointDeploy:
+		node, _ := in["orka_node_name"].(string)
+		res, err := s.deploy(name, node, metadata(in))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, res)
+	case EndpointStatus:
+		name = strings.TrimPrefix(path, "resources/vm/status/")
+		v, ok := s.vms[name]
+		if !ok {
+			writeError(w, errors.New("VM not found"))
+			return
+		}
+		writeJSON(w, &listResponse{
+			VirtualMachineResources: []*orka.VirtualMachineResource{s.resource(name, v)},
+		})
+	case EndpointList:
+		res := new(listResponse)
+		for name, v := range s.vms {
+			res.VirtualMachineResources = append(res.VirtualMachineResources, s.resource(name, v))
+		}
+		writeJSON(w, res)
+	case EndpointPurge:
+		v, ok := s.vms[name]
+		if !ok {
+			writeError(w, errors.New("VM not found"))
+			return
+		}
+		if v.node != nil {
+			v.node.AvailableCPU += v.config.CPU
+		}
+		delete(s.vms, name)
+		writeJSON(w, &orka.Response{Message: "Successfully purged VM"})
+	case EndpointImages:
+		writeJSON(w, &orka.ImagesResponse{Images: s.images})
+	case EndpointNodes:
+		writeJSON(w, &orka.NodesResponse{Nodes: s.nodes})
+	case EndpointSave:
+		image, _ := in["new_name"].(string)
+		if _, ok := s.vms[name]; !ok {
+			writeError(w, errors.New("VM not found"))
+			return
+		}
+		s.images = append(s.images, image)
+		writeJSON(w, &orka.Response{Message: "Successfully saved image"})
+	}
+}
+
+// helper function creates the virtual machine configuration.
+func (s *Server) create(in map[string]interface{}) error {
+	config := orka.Config{}
+	config.Name, _ = in["orka_vm_name"].(string)
+	config.Image, _ = in["orka_base_image"].(string)
+	cpu, _ := in["orka_cpu_core"].(float64)
+	config.CPU = int(cpu)
+
+	if config.Name == "" {
+		return errors.New("VM name is required")
+	}
+	if _, ok := s.vms[config.Name]; ok {
+		return errors.New("VM configuration already exists")
+	}
+	if len(s.images) != 0 && !contains(s.images, config.Image) {
+		return errors.New("Base image not found")
+	}
+	s.vms[config.Name] = &vm{config: config}
+	return nil
+}
+
+// helper function deploys the virtual machine to the named
+// node, or to the first ready node with available cpu.
+func (s *Server) deploy(name, nodeName string, metadata map[string]string) (*orka.DeployResponse, error) {
+	v, ok := s.vms[name]
+	if !ok {
+		return nil, errors.New("VM configuration not found")
+	}
+	if v.node != nil {
+		return nil, errors.New("VM is already deployed")
+	}
+	var node *orka.Node
+	for _, n := range s.nodes {
+		if nodeName != "" && n.Name != nodeName {
+			continue
+		}
+		if n.State == "READY" && n.AvailableCPU >= v.config.CPU {
+			node = n
+			break
+		}
+	}
+	if node == nil {
+		return nil, orka.ErrInsufficientCPU
+	}
+	node.AvailableCPU -= v.config.CPU
+	v.node = node
+	v.deployed = time.Now()
+	v.metadata = metadata
+
+	host := s.sshHost
+	if host == "" {
+		host = node.HostIP
+	}
+	return &orka.DeployResponse{
+		Response: orka.Response{Message: "Successfully deployed VM"},
+		IP:       host,
+		SSHPort:  s.sshPort,
+	}, nil
+}
+
+// helper function returns the virtual machine resource.
+func (s *Server) resource(name string, v *vm) *orka.VirtualMachineResource {
+	res := &orka.VirtualMachineResource{
+		VirtualMachineName: name,
+		VMDeploymentStatus: "Not Deployed",
+		CPU:                v.config.CPU,
+		BaseImage:          v.config.Image,
+		Image:              name,
+	}
+	if v.node != nil {
+		res.VMDeploymentStatus = "Deployed"
+		res.Status = []*orka.VirtualMachineStatus{
+			{
+				VirtualMachineName: name,
+				NodeLocation:       v.node.Name,
+				NodeStatus:         "UP",
+				VirtualMachineIP:   v.node.HostIP,
+				SSHPort:            s.sshPort,
+				CPU:                v.config.CPU,
+				Vcpu:               v.config.CPU,
+				BaseImage:          v.config.Image,
+				Image:              name,
+				VMStatus:           "running",
+				CreationTimestamp:  v.deployed.UTC().Format(time.RFC3339),
+			},
+		}
+		if len(v.metadata) != 0 {
+			res.Status[0].Metadata = orka.NewMetadata(v.metadata)
+		}
+	}
+	return res
+}
+
+// listResponse provides the virtual machine list and status
+// api responses, which share the same structure.
+type listResponse struct {
+	orka.Response
+	VirtualMachineResources []*orka.VirtualMachineResource `json:"virtual_machine_resources"`
+}
+
+// helper function writes the json response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// helper function writes the error response. The orka api
+// reports errors in the response body.
+func writeError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&orka.Response{
+		Errors: []*orka.Error{{Message: err.Error()}},
+	})
+}
+
+// helper function returns the custom metadata of the
+// deployment request.
+func metadata(in map[string]interface{}) map[string]string {
+	raw, _ := in["vm_metadata"].(map[string]interface{})
+	items, _ := raw["items"].([]interface{})
+	if len(items) == 0 {
+		return nil
+	}
+	out := map[string]string{}
+	for _, item := range items {
+		kv, _ := item.(map[string]interface{})
+		key, _ := kv["key"].(string)
+		value, _ := kv["value"].(string)
+		out[key] = value
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package orkatest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-macstadium/internal/orka"
+)
+
+var noContext = context.Background()
+
+func TestServer(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.SetImages("ventura-xcode-14.img")
+
+	client := server.Client()
+	_, err := client.Create(noContext, &orka.Config{
+		Name:  "drone-abc123",
+		Image: "ventura-xcode-14.img",
+		CPU:   12,
+		VCPU:  12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deploy, err := client.Deploy(noContext, "drone-abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := deploy.IP+":"+deploy.SSHPort, "127.0.0.1:8822"; got != want {
+		t.Errorf("Want ssh address %s, got %s", want, got)
+	}
+	if !server.Deployed("drone-abc123") {
+		t.Errorf("Expect vm deployed")
+	}
+
+	nodes, err := client.Nodes(noContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nodes.Nodes[0].AvailableCPU, 12; got != want {
+		t.Errorf("Want %d available cpu, got %d", want, got)
+	}
+
+	status, err := client.Check(noContext, "drone-abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status.VirtualMachineResources[0].Status[0].NodeLocation, "macpro-1"; got != want {
+		t.Errorf("Want node %s, got %s", want, got)
+	}
+
+	if _, err := client.Delete(noContext, "drone-abc123"); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.VMs()) != 0 {
+		t.Errorf("Expect vm purged")
+	}
+	nodes, _ = client.Nodes(noContext)
+	if got, want := nodes.Nodes[0].AvailableCPU, 24; got != want {
+		t.Errorf("Want %d available cpu, got %d", want, got)
+	}
+}
+
+func TestServer_Capacity(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	client := server.Client()
+	for _, name := range []string{"drone-abc123", "drone-def456"} {
+		_, err := client.Create(noContext, &orka.Config{Name: name, CPU: 24})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.Deploy(noContext, "drone-abc123"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Deploy(noContext, "drone-def456"); err != orka.ErrInsufficientCPU {
+		t.Errorf("Want insufficient cpu error, got %v", err)
+	}
+}
+
+func TestServer_Fail(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Fail(EndpointToken, 1, "invalid signature")
+
+	client := server.Client()
+	if _, err := client.CheckToken(noContext); err == nil {
+		t.Errorf("Expect injected token failure")
+	}
+	if _, err := client.CheckToken(noContext); err != nil {
+		t.Errorf("Expect failure injected once, got %s", err)
+	}
+}
+
+func TestServer_Token(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.SetToken("correct-horse")
+
+	client := server.Client()
+	if _, err := client.CheckToken(noContext); err != nil {
+		t.Error(err)
+	}
+	client.Token = "battery-staple"
+	if _, err := client.CheckToken(noContext); err == nil {
+		t.Errorf("Expect error for an invalid token")
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package orka
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// NewHTTPClient returns an http client with a dedicated
+// transport for the orka api. Requests are sent through the
+// proxy, if provided, otherwise through the proxy configured
+// with the standard HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables. The proxy is independent of the
+// proxy configured for pipeline steps.
+func NewHTTPClient(proxy string, skipVerify bool) (*http.Client, error) {
+	proxyFunc := http.ProxyFromEnvironment
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid orka proxy url: %s", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported orka proxy scheme: %q", u.Scheme)
+		}
+		proxyFunc = http.ProxyURL(u)
+	}
+	transport := &http.Transport{
+		Proxy: proxyFunc,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if skipVerify {
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
+		}
+	}
+	return &http.Client{Transport: transport}, nil
+}
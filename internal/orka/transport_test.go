@@ -0,0 +1,53 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package orka
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewHTTPClient_Proxy(t *testing.T) {
+	var host string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.URL.Host
+		json.NewEncoder(w).Encode(&TokenResponse{Authenticated: true})
+	}))
+	defer proxy.Close()
+
+	cli, err := NewHTTPClient(proxy.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{
+		Client:   cli,
+		Endpoint: "http://orka.company.com",
+		Token:    "token",
+	}
+	res, err := client.CheckToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Authenticated {
+		t.Errorf("Expect proxied response")
+	}
+	if got, want := host, "orka.company.com"; got != want {
+		t.Errorf("Want proxied request to %s, got %s", want, got)
+	}
+}
+
+func TestNewHTTPClient_InvalidProxy(t *testing.T) {
+	for _, proxy := range []string{
+		"ftp://proxy.company.com",
+		"http://%zz",
+	} {
+		if _, err := NewHTTPClient(proxy, false); err == nil {
+			t.Errorf("Expect proxy url %q invalid", proxy)
+		}
+	}
+}
@@ -4,6 +4,8 @@
 
 package orka
 
+import "time"
+
 // Config configures a virtual machine.
 type Config struct {
 	Name  string `json:"orka_vm_name"`
@@ -39,6 +41,26 @@ type (
 		VCPU  int    `json:"vcpu_count"`
 	}
 
+	// Metadata provides the custom virtual machine metadata
+	// of the deployment API request.
+	//
+	//     {
+	// 	    "items": [
+	// 	        { "key": "drone_repo", "value": "octocat/hello-world" }
+	// 	    ]
+	//     }
+	//
+	Metadata struct {
+		Items []*MetadataItem `json:"items"`
+	}
+
+	// MetadataItem provides a custom virtual machine metadata
+	// key and value.
+	MetadataItem struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+
 	// DeployResponse provides the deployment API response.
 	DeployResponse struct {
 		Response
@@ -85,6 +107,74 @@ type (
 		} `json:"virtual_machine_resources"`
 	}
 
+	// ListResponse provides the virtual machine list API
+	// response.
+	ListResponse struct {
+		Response
+		VirtualMachineResources []*VirtualMachineResource `json:"virtual_machine_resources"`
+	}
+
+	// VirtualMachineResource provides a virtual machine
+	// configuration and its deployments.
+	VirtualMachineResource struct {
+		VirtualMachineName string                  `json:"virtual_machine_name"`
+		VMDeploymentStatus string                  `json:"vm_deployment_status"`
+		Owner              string                  `json:"owner"`
+		CPU                int                     `json:"cpu"`
+		BaseImage          string                  `json:"base_image"`
+		Image              string                  `json:"image"`
+		Status             []*VirtualMachineStatus `json:"status"`
+	}
+
+	// VirtualMachineStatus provides the status of a deployed
+	// virtual machine.
+	VirtualMachineStatus struct {
+		Owner              string `json:"owner"`
+		VirtualMachineName string `json:"virtual_machine_name"`
+		VirtualMachineID   string `json:"virtual_machine_id"`
+		NodeLocation       string `json:"node_location"`
+		NodeStatus         string `json:"node_status"`
+		VirtualMachineIP   string `json:"virtual_machine_ip"`
+		SSHPort            string `json:"ssh_port"`
+		CPU                int    `json:"cpu"`
+		Vcpu               int    `json:"vcpu"`
+		RAM                string `json:"RAM"`
+		BaseImage          string `json:"base_image"`
+		Image              string `json:"image"`
+		VMStatus           string `json:"vm_status"`
+		CreationTimestamp  string `json:"creation_timestamp"`
+
+		// Metadata provides the custom metadata attached to
+		// the virtual machine when deployed.
+		Metadata *Metadata `json:"vm_metadata,omitempty"`
+	}
+
+	// ImagesResponse provides the image list API response.
+	ImagesResponse struct {
+		Response
+		Images []string `json:"images"`
+	}
+
+	// NodesResponse provides the node list API response.
+	NodesResponse struct {
+		Response
+		Nodes []*Node `json:"nodes"`
+	}
+
+	// Node provides the node details and available resources.
+	Node struct {
+		Name           string `json:"name"`
+		HostIP         string `json:"hostIP"`
+		AvailableCPU   int    `json:"available_cpu"`
+		AllocatableCPU int    `json:"allocatable_cpu"`
+		TotalCPU       int    `json:"total_cpu"`
+		State          string `json:"state"`
+
+		// Tags lists the node tags. Older orka versions do
+		// not report the node tags.
+		Tags []string `json:"orka_tags,omitempty"`
+	}
+
 	// TokenResponse provides the token API response.
 	TokenResponse struct {
 		Response
@@ -94,6 +184,66 @@ type (
 	}
 )
 
+// Created returns the earliest creation timestamp of the
+// virtual machine deployments. If the virtual machine is
+// not deployed, a zero value is returned.
+func (v *VirtualMachineResource) Created() (time.Time, bool) {
+	var created time.Time
+	for _, status := range v.Status {
+		t, err := time.Parse(time.RFC3339, status.CreationTimestamp)
+		if err != nil {
+			continue
+		}
+		if created.IsZero() || t.Before(created) {
+			created = t
+		}
+	}
+	return created, !created.IsZero()
+}
+
+// Value returns the value of the metadata key, or an empty
+// string if the key is not found.
+func (m *Metadata) Value(key string) string {
+	if m == nil {
+		return ""
+	}
+	for _, item := range m.Items {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return ""
+}
+
+// Fits returns true if a virtual machine with the requested
+// cpu count can be deployed to one or more ready nodes. A
+// virtual machine cannot span multiple nodes.
+func (r *NodesResponse) Fits(cpu int) bool {
+	for _, node := range r.Nodes {
+		if node.State != "READY" {
+			continue
+		}
+		if node.AvailableCPU >= cpu {
+			return true
+		}
+	}
+	return false
+}
+
+// Tagged returns the nodes with the tag.
+func (r *NodesResponse) Tagged(tag string) *NodesResponse {
+	out := new(NodesResponse)
+	for _, node := range r.Nodes {
+		for _, t := range node.Tags {
+			if t == tag {
+				out.Nodes = append(out.Nodes, node)
+				break
+			}
+		}
+	}
+	return out
+}
+
 // Error represents an API error.
 type Error struct {
 	Message string `json:"message"`
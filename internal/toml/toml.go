@@ -0,0 +1,412 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package toml decodes the subset of toml used by the runner
+// configuration file: tables, dotted keys, strings, integers,
+// floats, booleans, arrays and inline tables. Dates, times
+// and arrays of tables are not supported.
+package toml
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Unmarshal decodes the toml document to a map. Tables and
+// inline tables are decoded to maps, arrays to slices,
+// integers to int64 and floats to float64.
+func Unmarshal(data []byte) (map[string]interface{}, error) {
+	p := &parser{src: string(data), line: 1}
+	root := map[string]interface{}{}
+	if err := p.parse(root); err != nil {
+		return nil, fmt.Errorf("toml: line %d: %s", p.line, err)
+	}
+	return root, nil
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// parse parses the document into the root table.
+func (p *parser) parse(root map[string]interface{}) error {
+	table := root
+	for {
+		p.skip(true)
+		if p.eof() {
+			return nil
+		}
+		if p.peek() == '[' {
+			if strings.HasPrefix(p.src[p.pos:], "[[") {
+				return errors.New("arrays of tables are not supported")
+			}
+			p.pos++
+			p.skip(false)
+			keys, err := p.key()
+			if err != nil {
+				return err
+			}
+			p.skip(false)
+			if !p.consume(']') {
+				return errors.New("expected ] after table name")
+			}
+			if table, err = ensure(root, keys); err != nil {
+				return err
+			}
+		} else {
+			keys, err := p.key()
+			if err != nil {
+				return err
+			}
+			p.skip(false)
+			if !p.consume('=') {
+				return errors.New("expected = after key")
+			}
+			p.skip(false)
+			value, err := p.value()
+			if err != nil {
+				return err
+			}
+			if err := set(table, keys, value); err != nil {
+				return err
+			}
+		}
+		p.skip(false)
+		if !p.eof() && p.peek() != '\n' && p.peek() != '\r' {
+			return fmt.Errorf("unexpected character %q", p.peek())
+		}
+	}
+}
+
+// key parses a dotted key of bare or quoted keys.
+func (p *parser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skip(false)
+		if p.eof() {
+			return nil, errors.New("expected key")
+		}
+		var key string
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		default:
+			start := p.pos
+			for !p.eof() && isBare(p.peek()) {
+				p.pos++
+			}
+			key = p.src[start:p.pos]
+			if key == "" {
+				return nil, errors.New("expected key")
+			}
+		}
+		keys = append(keys, key)
+		p.skip(false)
+		if !p.consume('.') {
+			return keys, nil
+		}
+	}
+}
+
+// value parses a string, number, boolean, array or inline
+// table value.
+func (p *parser) value() (interface{}, error) {
+	if p.eof() {
+		return nil, errors.New("expected value")
+	}
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.table()
+	case strings.HasPrefix(p.src[p.pos:], "true"):
+		p.pos += 4
+		return true, nil
+	case strings.HasPrefix(p.src[p.pos:], "false"):
+		p.pos += 5
+		return false, nil
+	default:
+		return p.number()
+	}
+}
+
+// array parses an array, which may span multiple lines.
+func (p *parser) array() (interface{}, error) {
+	p.pos++
+	out := []interface{}{}
+	for {
+		p.skip(true)
+		if p.consume(']') {
+			return out, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+		p.skip(true)
+		if p.consume(']') {
+			return out, nil
+		}
+		if !p.consume(',') {
+			return nil, errors.New("expected , or ] in array")
+		}
+	}
+}
+
+// table parses an inline table, which must be defined on a
+// single line.
+func (p *parser) table() (interface{}, error) {
+	p.pos++
+	out := map[string]interface{}{}
+	p.skip(false)
+	if p.consume('}') {
+		return out, nil
+	}
+	for {
+		keys, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		p.skip(false)
+		if !p.consume('=') {
+			return nil, errors.New("expected = after key")
+		}
+		p.skip(false)
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := set(out, keys, v); err != nil {
+			return nil, err
+		}
+		p.skip(false)
+		if p.consume('}') {
+			return out, nil
+		}
+		if !p.consume(',') {
+			return nil, errors.New("expected , or } in inline table")
+		}
+		p.skip(false)
+	}
+}
+
+// number parses an integer or float.
+func (p *parser) number() (interface{}, error) {
+	start := p.pos
+	for !p.eof() && strings.IndexByte("+-0123456789._eExabcdfABCDFo", p.peek()) != -1 {
+		p.pos++
+	}
+	s := strings.Replace(p.src[start:p.pos], "_", "", -1)
+	if s == "" {
+		return nil, fmt.Errorf("unexpected character %q", p.peek())
+	}
+	base := 10
+	if len(s) > 2 && s[0] == '0' && strings.IndexByte("xob", s[1]) != -1 {
+		base = 0
+	}
+	if i, err := strconv.ParseInt(s, base, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", p.src[start:p.pos])
+}
+
+// str parses a basic or literal string, either of which may
+// be a multi-line string.
+func (p *parser) str() (string, error) {
+	quote := p.peek()
+	if strings.HasPrefix(p.src[p.pos:], strings.Repeat(string(quote), 3)) {
+		return p.multiline(quote)
+	}
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", errors.New("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && quote == '"':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// multiline parses a multi-line basic or literal string. A
+// newline immediately following the opening delimiter is
+// trimmed.
+func (p *parser) multiline(quote byte) (string, error) {
+	delim := strings.Repeat(string(quote), 3)
+	p.pos += 3
+	if strings.HasPrefix(p.src[p.pos:], "\r\n") {
+		p.pos += 2
+		p.line++
+	} else if p.consume('\n') {
+		p.line++
+	}
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", errors.New("unterminated string")
+		}
+		if strings.HasPrefix(p.src[p.pos:], delim) {
+			p.pos += 3
+			return b.String(), nil
+		}
+		c := p.peek()
+		p.pos++
+		switch {
+		case c == '\\' && quote == '"':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		case c == '\n':
+			p.line++
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// escape parses the escape sequence following a backslash.
+func (p *parser) escape(b *strings.Builder) error {
+	if p.eof() {
+		return errors.New("unterminated string")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.src) {
+			return errors.New("invalid unicode escape")
+		}
+		r, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return errors.New("invalid unicode escape")
+		}
+		p.pos += n
+		b.WriteRune(rune(r))
+	default:
+		return fmt.Errorf("invalid escape sequence \\%c", c)
+	}
+	return nil
+}
+
+// skip skips whitespace and comments, and newlines if
+// requested.
+func (p *parser) skip(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		case newlines && c == '\r':
+			p.pos++
+		case newlines && c == '\n':
+			p.pos++
+			p.line++
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	return p.src[p.pos]
+}
+
+func (p *parser) consume(c byte) bool {
+	if !p.eof() && p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// helper function returns the nested table at the dotted
+// key, creating tables as needed.
+func ensure(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		v, ok := table[key]
+		if !ok {
+			next := map[string]interface{}{}
+			table[key] = next
+			table = next
+			continue
+		}
+		next, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("key %q is not a table", key)
+		}
+		table = next
+	}
+	return table, nil
+}
+
+// helper function sets the value at the dotted key, and
+// returns an error if the key is already defined.
+func set(table map[string]interface{}, keys []string, value interface{}) error {
+	table, err := ensure(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	key := keys[len(keys)-1]
+	if _, ok := table[key]; ok {
+		return fmt.Errorf("duplicate key %q", key)
+	}
+	table[key] = value
+	return nil
+}
+
+func isBare(c byte) bool {
+	return c >= 'a' && c <= 'z' ||
+		c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' ||
+		c == '_' || c == '-'
+}
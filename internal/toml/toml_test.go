@@ -0,0 +1,117 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package toml
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUnmarshal(t *testing.T) {
+	data := `
+# runner configuration
+title = "runner"
+
+[client]
+host = "drone.company.com" # trailing comment
+secret = 'correct\horse'
+
+[macstadium]
+retry_min = "30s"
+max_setup = 4
+ratio = 1.5
+hex = 0x1f
+big = 1_000
+fail_fast = true
+skip_verify = false
+
+[limit]
+repos = [
+  "octocat/*", # comment
+  "drone/*",
+]
+
+[vm]
+aliases = { xcode15 = "90GVentura-Xcode15-*.img", "xcode16" = "90GSonoma-Xcode16-*.img" }
+ssh.port = 22
+
+[vm.nested]
+escaped = "tab\there \"quoted\" \u00e9"
+text = """
+line one
+line two"""
+`
+	got, err := Unmarshal([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"title": "runner",
+		"client": map[string]interface{}{
+			"host":   "drone.company.com",
+			"secret": `correct\horse`,
+		},
+		"macstadium": map[string]interface{}{
+			"retry_min":   "30s",
+			"max_setup":   int64(4),
+			"ratio":       1.5,
+			"hex":         int64(31),
+			"big":         int64(1000),
+			"fail_fast":   true,
+			"skip_verify": false,
+		},
+		"limit": map[string]interface{}{
+			"repos": []interface{}{"octocat/*", "drone/*"},
+		},
+		"vm": map[string]interface{}{
+			"aliases": map[string]interface{}{
+				"xcode15": "90GVentura-Xcode15-*.img",
+				"xcode16": "90GSonoma-Xcode16-*.img",
+			},
+			"ssh": map[string]interface{}{
+				"port": int64(22),
+			},
+			"nested": map[string]interface{}{
+				"escaped": "tab\there \"quoted\" \u00e9",
+				"text":    "line one\nline two",
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unexpected document")
+		t.Log(diff)
+	}
+}
+
+func TestUnmarshal_Invalid(t *testing.T) {
+	tests := []struct {
+		data    string
+		message string
+	}{
+		{"key = ", "toml: line 1: expected value"},
+		{"key = \"unterminated\n", "toml: line 1: unterminated string"},
+		{"key = 1\nkey = 2", `toml: line 2: duplicate key "key"`},
+		{"key = 1 2", `toml: line 1: unexpected character '2'`},
+		{"[table\nkey = 1", "toml: line 1: expected ] after table name"},
+		{"[[servers]]\nkey = 1", "toml: line 1: arrays of tables are not supported"},
+		{"key = 1\n[key]", `toml: line 2: key "key" is not a table`},
+		{"key = [1, 2", "toml: line 1: expected , or ] in array"},
+		{"date = 1979-05-27T07:32:00Z", `toml: line 1: invalid value "1979-05-27"`},
+		{"key = \"\\q\"", `toml: line 1: invalid escape sequence \q`},
+		{"[", "toml: line 1: expected key"},
+		{"key = {a = 1,", "toml: line 1: expected key"},
+	}
+	for _, test := range tests {
+		_, err := Unmarshal([]byte(test.data))
+		if err == nil {
+			t.Errorf("Expect error for %q", test.data)
+			continue
+		}
+		if got, want := err.Error(), test.message; got != want {
+			t.Errorf("Want error %q, got %q", want, got)
+		}
+	}
+}
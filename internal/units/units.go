@@ -0,0 +1,49 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package units parses human-readable sizes.
+package units
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// errInvalidSize is returned when the size cannot be parsed.
+var errInvalidSize = errors.New("invalid size")
+
+// size multipliers, using binary units.
+var multipliers = map[string]int64{
+	"":   1,
+	"b":  1,
+	"k":  1 << 10,
+	"kb": 1 << 10,
+	"m":  1 << 20,
+	"mb": 1 << 20,
+	"g":  1 << 30,
+	"gb": 1 << 30,
+}
+
+// ParseBytes parses the human-readable size, for example
+// 512MB or 4g, and returns the size in bytes. Units are
+// case-insensitive and use binary multiples.
+func ParseBytes(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+	mult, ok := multipliers[strings.TrimSpace(s[i:])]
+	if !ok {
+		return 0, errInvalidSize
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n <= 0 {
+		return 0, errInvalidSize
+	}
+	return int64(n * float64(mult)), nil
+}
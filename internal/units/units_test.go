@@ -0,0 +1,41 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package units
+
+import "testing"
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+		err  bool
+	}{
+		{s: "1024", want: 1024},
+		{s: "512MB", want: 512 << 20},
+		{s: "4g", want: 4 << 30},
+		{s: "1.5 GB", want: 3 << 29},
+		{s: "2k", want: 2048},
+		{s: "", err: true},
+		{s: "0", err: true},
+		{s: "GB", err: true},
+		{s: "4tb", err: true},
+	}
+	for _, test := range tests {
+		got, err := ParseBytes(test.s)
+		if test.err {
+			if err == nil {
+				t.Errorf("Want error parsing %q", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", test.s, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Want %d bytes for %q, got %d", test.want, test.s, got)
+		}
+	}
+}
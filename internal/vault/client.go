@@ -0,0 +1,152 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+)
+
+// ErrNotFound is returned when the secret path does not exist.
+var ErrNotFound = errors.New("vault: secret not found")
+
+// Client provides a vault client.
+type Client struct {
+	Client   *http.Client
+	Dumper   logger.Dumper
+	Address  string
+	Token    string
+	RoleID   string
+	SecretID string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Read reads the secret at the named path.
+func (c *Client) Read(ctx context.Context, path string) (*Secret, error) {
+	token, err := c.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	uri := fmt.Sprintf("%s/v1/%s", c.Address, strings.TrimPrefix(path, "/"))
+	out := new(Secret)
+	err = c.do(ctx, "GET", uri, token, nil, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// login returns the client token. If the client is configured
+// with an approle, the token is requested from the server and
+// renewed when the lease expires.
+func (c *Client) login(ctx context.Context) (string, error) {
+	if c.RoleID == "" {
+		return c.Token, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// re-use the cached token until it is within one minute
+	// of expiration.
+	if c.token != "" && time.Now().Add(time.Minute).Before(c.expires) {
+		return c.token, nil
+	}
+
+	in := map[string]string{
+		"role_id":   c.RoleID,
+		"secret_id": c.SecretID,
+	}
+	uri := fmt.Sprintf("%s/v1/auth/approle/login", c.Address)
+	out := new(Secret)
+	err := c.do(ctx, "POST", uri, "", &in, out)
+	if err != nil {
+		return "", err
+	}
+	if out.Auth == nil || out.Auth.ClientToken == "" {
+		return "", errors.New("vault: approle login did not return a token")
+	}
+	c.token = out.Auth.ClientToken
+	c.expires = time.Now().Add(
+		time.Duration(out.Auth.LeaseDuration) * time.Second,
+	)
+	return c.token, nil
+}
+
+// do makes an http.Request to the target endpoint.
+func (c *Client) do(ctx context.Context, method, endpoint, token string, in, out interface{}) error {
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	if in != nil {
+		dec, _ := json.Marshal(in)
+		buf := bytes.NewBuffer(dec)
+		req.Body = ioutil.NopCloser(buf)
+		req.ContentLength = int64(len(dec))
+		req.Header.Set("Content-Length", fmt.Sprint(len(dec)))
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if token != "" {
+		req.Header.Add("X-Vault-Token", token)
+	}
+
+	if c.Dumper != nil {
+		c.Dumper.DumpRequest(req)
+	}
+
+	res, err := c.client().Do(req)
+	if res != nil && res.Body != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.Dumper != nil {
+		c.Dumper.DumpResponse(res)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode == 404 {
+		return ErrNotFound
+	}
+	if res.StatusCode > 299 {
+		errs := new(Errors)
+		json.Unmarshal(body, errs)
+		if len(errs.Errors) == 0 {
+			return fmt.Errorf("vault: unexpected status code %d", res.StatusCode)
+		}
+		return errs
+	}
+	return json.Unmarshal(body, out)
+}
+
+func (c *Client) client() *http.Client {
+	if c.Client == nil {
+		return http.DefaultClient
+	}
+	return c.Client
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package vault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/secret"
+	"github.com/h2non/gock"
+)
+
+func TestRead(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://vault.company.com").
+		Get("/v1/secret/data/drone").
+		MatchHeader("X-Vault-Token", "token").
+		Reply(200).
+		Type("application/json").
+		File("testdata/secret.json")
+
+	client := &Client{
+		Address: "http://vault.company.com",
+		Token:   "token",
+	}
+	got, err := client.Read(context.Background(), "secret/data/drone")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := got.Values()["username"], "octocat"; got != want {
+		t.Errorf("Want username %q, got %q", want, got)
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestReadNotFound(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://vault.company.com").
+		Get("/v1/secret/data/drone").
+		Reply(404).
+		Type("application/json").
+		BodyString(`{"errors":[]}`)
+
+	client := &Client{
+		Address: "http://vault.company.com",
+		Token:   "token",
+	}
+	_, err := client.Read(context.Background(), "secret/data/drone")
+	if err != ErrNotFound {
+		t.Errorf("Expect ErrNotFound, got %v", err)
+	}
+}
+
+func TestAppRole(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://vault.company.com").
+		Post("/v1/auth/approle/login").
+		Times(1).
+		Reply(200).
+		Type("application/json").
+		File("testdata/login.json")
+
+	gock.New("http://vault.company.com").
+		Get("/v1/secret/data/drone").
+		MatchHeader("X-Vault-Token", "s.b7Xn2DkqsbcA6kNcPwVqP1Dd").
+		Times(2).
+		Reply(200).
+		Type("application/json").
+		File("testdata/secret.json")
+
+	client := &Client{
+		Address:  "http://vault.company.com",
+		RoleID:   "drone",
+		SecretID: "s3cr3t",
+	}
+	// the second read re-uses the token acquired by the
+	// first login.
+	for i := 0; i < 2; i++ {
+		_, err := client.Read(context.Background(), "secret/data/drone")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestProvider_Dynamic(t *testing.T) {
+	defer gock.Off()
+
+	// the dynamic secret is leased and should only be
+	// requested once for the build.
+	gock.New("http://vault.company.com").
+		Get("/v1/aws/creds/signing").
+		Times(1).
+		Reply(200).
+		Type("application/json").
+		File("testdata/dynamic.json")
+
+	client := &Client{
+		Address: "http://vault.company.com",
+		Token:   "token",
+	}
+	provider := NewProvider(client, "aws/creds/signing")
+	for _, name := range []string{"access_key", "secret_key"} {
+		got, err := provider.Find(context.Background(), &secret.Request{
+			Name:  name,
+			Build: &drone.Build{ID: 1, Event: drone.EventPush},
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if got == nil || got.Data == "" {
+			t.Errorf("Expect secret %s", name)
+		}
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Pending mocks")
+	}
+}
+
+func TestProvider_PullRequest(t *testing.T) {
+	client := &Client{
+		Address: "http://vault.company.com",
+		Token:   "token",
+	}
+	provider := NewProvider(client, "secret/data/drone")
+	got, err := provider.Find(context.Background(), &secret.Request{
+		Name:  "password",
+		Build: &drone.Build{Event: drone.EventPullRequest},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if got != nil {
+		t.Errorf("Expect secret restricted from pull requests")
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/secret"
+)
+
+// NewProvider returns a new vault secret provider. Secrets
+// referenced in the yaml by a named secret resource with a
+// get path are read from that path. Otherwise the secret
+// name is resolved as a key in the default path, if set.
+func NewProvider(client *Client, path string) secret.Provider {
+	return &provider{
+		client: client,
+		path:   path,
+		cache:  map[string]*entry{},
+	}
+}
+
+type provider struct {
+	client *Client
+	path   string
+
+	mu    sync.Mutex
+	cache map[string]*entry
+}
+
+// entry caches a leased secret so that every step in a
+// build receives the same dynamic credentials.
+type entry struct {
+	secret  *Secret
+	expires time.Time
+}
+
+func (p *provider) Find(ctx context.Context, in *secret.Request) (*drone.Secret, error) {
+	if p.client == nil {
+		return nil, nil
+	}
+
+	logger := logger.FromContext(ctx).
+		WithField("name", in.Name).
+		WithField("kind", "secret")
+
+	path, key, ok := p.lookup(in.Conf, in.Name)
+	if !ok {
+		logger.Trace("secret: vault: no matching secret")
+		return nil, nil
+	}
+
+	// secrets are restricted from pull requests, consistent
+	// with the behavior of the static secret provider.
+	if in.Build != nil && in.Build.Event == drone.EventPullRequest {
+		logger.Trace("secret: vault: restricted from pull requests")
+		return nil, nil
+	}
+
+	res, err := p.read(ctx, in.Build, path)
+	if err == ErrNotFound {
+		logger.Trace("secret: vault: no matching path")
+		return nil, nil
+	}
+	if err != nil {
+		logger.WithError(err).Debug("secret: vault: cannot get secret")
+		return nil, err
+	}
+
+	data, ok := res.Values()[key]
+	if !ok || data == "" {
+		logger.Trace("secret: vault: secret is empty")
+		return nil, nil
+	}
+
+	logger.Trace("secret: vault: found matching secret")
+	return &drone.Secret{
+		Name: in.Name,
+		Data: data,
+	}, nil
+}
+
+// read reads the secret at the named path. Leased (dynamic)
+// secrets are cached for the duration of the lease, scoped
+// to the build, so that short-lived credentials are issued
+// once per build instead of once per step.
+func (p *provider) read(ctx context.Context, build *drone.Build, path string) (*Secret, error) {
+	var id int64
+	if build != nil {
+		id = build.ID
+	}
+	key := fmt.Sprintf("%d/%s", id, path)
+	now := time.Now()
+
+	p.mu.Lock()
+	for k, v := range p.cache {
+		if now.After(v.expires) {
+			delete(p.cache, k)
+		}
+	}
+	cached, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok {
+		return cached.secret, nil
+	}
+
+	res, err := p.client.Read(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if res.LeaseID != "" && res.LeaseDuration > 0 {
+		p.mu.Lock()
+		p.cache[key] = &entry{
+			secret:  res,
+			expires: now.Add(time.Duration(res.LeaseDuration) * time.Second),
+		}
+		p.mu.Unlock()
+	}
+	return res, nil
+}
+
+// lookup returns the vault path and key for the named secret.
+func (p *provider) lookup(conf *manifest.Manifest, name string) (path, key string, ok bool) {
+	if conf != nil {
+		for _, resource := range conf.Resources {
+			secret, ok := resource.(*manifest.Secret)
+			if !ok {
+				continue
+			}
+			if secret.Name != name {
+				continue
+			}
+			if secret.Get.Path == "" {
+				continue
+			}
+			key := secret.Get.Name
+			if key == "" {
+				key = name
+			}
+			return secret.Get.Path, key, true
+		}
+	}
+	if p.path == "" {
+		return
+	}
+	return p.path, name, true
+}
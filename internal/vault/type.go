@@ -0,0 +1,64 @@
+// Copyright 2020 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package vault
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// Secret provides the secret API response.
+	Secret struct {
+		RequestID     string                 `json:"request_id"`
+		LeaseID       string                 `json:"lease_id"`
+		LeaseDuration int                    `json:"lease_duration"`
+		Renewable     bool                   `json:"renewable"`
+		Data          map[string]interface{} `json:"data"`
+		Auth          *Auth                  `json:"auth"`
+	}
+
+	// Auth provides the authentication API response.
+	Auth struct {
+		ClientToken   string   `json:"client_token"`
+		Accessor      string   `json:"accessor"`
+		Policies      []string `json:"policies"`
+		LeaseDuration int      `json:"lease_duration"`
+		Renewable     bool     `json:"renewable"`
+	}
+)
+
+// Values returns the secret key value pairs. If the secret
+// was read from a version 2 key value engine, the nested
+// data values are returned.
+func (s *Secret) Values() map[string]string {
+	data := s.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	out := map[string]string{}
+	for k, v := range data {
+		switch v := v.(type) {
+		case string:
+			out[k] = v
+		case nil:
+		default:
+			out[k] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
+
+// Errors represents an API error.
+type Errors struct {
+	Errors []string `json:"errors"`
+}
+
+// Error returns the error message.
+func (e *Errors) Error() string {
+	return "vault: " + strings.Join(e.Errors, "; ")
+}